@@ -0,0 +1,139 @@
+package blocks
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"strings"
+)
+
+// BlockAnalysis is the analysis of a single concept block. Retrieved and RelRet are the documents and relevant
+// documents the block retrieves on its own, and Recall is the proportion of the relevant documents it retrieves.
+// DeltaRetrieved and DeltaRecall are the change in the documents retrieved and the recall of the entire query if the
+// block were removed from it (i.e. a negative change in recall means the block is responsible for retrieving relevant
+// documents, and a positive change means the block excludes relevant documents).
+type BlockAnalysis struct {
+	Block
+	Measurements   map[string]float64 `json:"measurements,omitempty"`
+	Retrieved      int                `json:"retrieved"`
+	RelRet         int                `json:"relret"`
+	Recall         float64            `json:"recall"`
+	DeltaRetrieved int                `json:"delta_retrieved"`
+	DeltaRecall    float64            `json:"delta_recall"`
+}
+
+// Analysis is the analysis of every concept block of a query for a topic.
+type Analysis struct {
+	Topic     string          `json:"topic"`
+	Retrieved int             `json:"retrieved"`
+	Relevant  int             `json:"relevant"`
+	RelRet    int             `json:"relret"`
+	Recall    float64         `json:"recall"`
+	Blocks    []BlockAnalysis `json:"blocks"`
+}
+
+// Analyse computes the measurements, retrieval counts, and recall contribution of each block of a query. Blocks are
+// retrieved using the statistics source, and the documents of atoms are read from (and added to) the cache.
+func Analyse(q pipeline.Query, blocks []Block, ss stats.StatisticsSource, cache combinator.QueryCacher, qrels trecresults.Qrels, measurements ...analysis.Measurement) (Analysis, error) {
+	rel := combinator.RelevantDocuments(qrels)
+	docs, err := documents(q, q.Query, ss, cache)
+	if err != nil {
+		return Analysis{}, err
+	}
+	a := Analysis{
+		Topic:     q.Topic,
+		Retrieved: docs.Len(),
+		Relevant:  rel.Len(),
+		RelRet:    docs.Intersect(rel).Len(),
+		Recall:    recall(docs, rel),
+		Blocks:    make([]BlockAnalysis, len(blocks)),
+	}
+
+	names := analysis.MeasurementNames(measurements...)
+	for i, block := range blocks {
+		b := BlockAnalysis{Block: block}
+
+		if len(measurements) > 0 {
+			b.Measurements = make(map[string]float64, len(names))
+			j := 0
+			for _, m := range measurements {
+				values, err := analysis.ExecuteMeasurement(m, pipeline.NewQuery(q.Name, q.Topic, block.Query), ss)
+				if err != nil {
+					return Analysis{}, err
+				}
+				for _, v := range values {
+					b.Measurements[names[j]] = v
+					j++
+				}
+			}
+		}
+
+		blockDocs, err := documents(q, block.Query, ss, cache)
+		if err != nil {
+			return Analysis{}, err
+		}
+		b.Retrieved = blockDocs.Len()
+		b.RelRet = blockDocs.Intersect(rel).Len()
+		b.Recall = recall(blockDocs, rel)
+
+		// Removing the only block of a query retrieves nothing.
+		var without combinator.Documents
+		if r := remove(q.Query, block.Path); r != nil {
+			without, err = documents(q, r, ss, cache)
+			if err != nil {
+				return Analysis{}, err
+			}
+		} else {
+			without = combinator.NewDocuments()
+		}
+		b.DeltaRetrieved = without.Len() - a.Retrieved
+		b.DeltaRecall = recall(without, rel) - a.Recall
+
+		a.Blocks[i] = b
+	}
+	return a, nil
+}
+
+// documents retrieves the documents of a clause of a query.
+func documents(q pipeline.Query, clause cqr.CommonQueryRepresentation, ss stats.StatisticsSource, cache combinator.QueryCacher) (combinator.Documents, error) {
+	tree, cache, err := combinator.NewLogicalTree(pipeline.NewQuery(q.Name, q.Topic, clause), ss, cache)
+	if err != nil {
+		return combinator.Documents{}, err
+	}
+	return tree.Documents(cache)
+}
+
+// remove copies a query without the clause at a path, or returns nil if nothing of the query would remain.
+func remove(q cqr.CommonQueryRepresentation, path []int) cqr.CommonQueryRepresentation {
+	if len(path) == 0 {
+		return nil
+	}
+	bq := q.(cqr.BooleanQuery)
+	children := make([]cqr.CommonQueryRepresentation, 0, len(bq.Children))
+	for i, child := range bq.Children {
+		if i != path[0] {
+			children = append(children, child)
+			continue
+		}
+		if c := remove(child, path[1:]); c != nil {
+			children = append(children, c)
+		} else if i == 0 && strings.ToLower(bq.Operator) == cqr.NOT {
+			// There is nothing left to exclude documents from.
+			return nil
+		}
+	}
+	if len(children) == 0 {
+		return nil
+	}
+	return cqr.BooleanQuery{Operator: bq.Operator, Children: children, Options: bq.Options}
+}
+
+func recall(docs, rel combinator.Documents) float64 {
+	if rel.Len() == 0 {
+		return 0
+	}
+	return float64(docs.Intersect(rel).Len()) / float64(rel.Len())
+}
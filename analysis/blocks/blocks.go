@@ -0,0 +1,217 @@
+// Package blocks segments systematic review queries into concept blocks. Systematic review queries are usually an
+// `and` query of `or` queries, where each `or` query (a block) describes a single concept of the review, such as the
+// population, the intervention, or the study design. Blocks are labelled by the concept they describe, and can be
+// analysed separately.
+package blocks
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/preprocess"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/transmute/fields"
+	"strings"
+)
+
+// Label is the concept a block describes.
+type Label string
+
+const (
+	// Unknown is a block whose concept could not be identified.
+	Unknown Label = "unknown"
+	// Population is a block that describes the condition or population of a review.
+	Population Label = "population"
+	// Intervention is a block that describes the intervention or exposure of a review.
+	Intervention Label = "intervention"
+	// StudyDesign is a block that restricts a query to studies of a design, e.g. randomised controlled trials.
+	StudyDesign Label = "study_design"
+)
+
+// Block is a concept block of a query. The path is the index of each child descended into from the root of the query
+// to reach the block. An excluded block is a block whose documents are removed from the query by a `not` clause.
+type Block struct {
+	Path     []int                         `json:"path"`
+	Clause   string                        `json:"clause"`
+	Label    Label                         `json:"label"`
+	Excluded bool                          `json:"excluded"`
+	Query    cqr.CommonQueryRepresentation `json:"-"`
+}
+
+// Filter is a known search filter, e.g. the randomised controlled trials filter, which is labelled when it is used as
+// a block of a query.
+type Filter struct {
+	Label Label
+	Query cqr.CommonQueryRepresentation
+}
+
+// StudyDesignFilters are the study design filters known to groove (i.e. preprocess.RCTFilter).
+func StudyDesignFilters() []Filter {
+	rct := preprocess.RCTFilter(cqr.NewBooleanQuery(cqr.AND, nil))().(cqr.BooleanQuery)
+	return []Filter{{Label: StudyDesign, Query: rct.Children[1]}}
+}
+
+// Segmenter identifies and labels the concept blocks of queries.
+type Segmenter struct {
+	mesh       *analysis.MeSH
+	filters    []Filter
+	minOverlap float64
+}
+
+// SegmentMeSH is the MeSH tree used to label blocks by the categories of their MeSH headings. By default, the default
+// MeSH tree is used.
+func SegmentMeSH(mesh *analysis.MeSH) func(s *Segmenter) {
+	return func(s *Segmenter) {
+		s.mesh = mesh
+	}
+}
+
+// SegmentFilters are the known search filters used to label blocks (default StudyDesignFilters).
+func SegmentFilters(filters ...Filter) func(s *Segmenter) {
+	return func(s *Segmenter) {
+		s.filters = filters
+	}
+}
+
+// SegmentMinOverlap is the smallest proportion of the keywords of a block that must be in a filter for the block to be
+// labelled as the filter (default 0.5).
+func SegmentMinOverlap(p float64) func(s *Segmenter) {
+	return func(s *Segmenter) {
+		s.minOverlap = p
+	}
+}
+
+// NewSegmenter creates a new segmenter.
+func NewSegmenter(options ...func(s *Segmenter)) Segmenter {
+	s := Segmenter{
+		filters:    StudyDesignFilters(),
+		minOverlap: 0.5,
+	}
+	for _, option := range options {
+		option(&s)
+	}
+	return s
+}
+
+// Segment identifies the concept blocks of a query. The children of an `and` query (and of any `and` query nested
+// directly in it) are blocks, and the children excluded by a `not` query are excluded blocks. A query that is not an
+// `and` query is a single block.
+//
+// A block is labelled as a known filter when enough of its keywords are in the filter. Otherwise, each MeSH heading of
+// the block votes for the label of its MeSH categories, and each publication type votes for the study design; the
+// block is labelled with the most votes, or unknown if there is a tie.
+func (s Segmenter) Segment(q cqr.CommonQueryRepresentation) ([]Block, error) {
+	blocks := segment(q, []int{}, false)
+	for i := range blocks {
+		var err error
+		blocks[i].Label, err = s.label(blocks[i].Query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return blocks, nil
+}
+
+func segment(q cqr.CommonQueryRepresentation, path []int, excluded bool) []Block {
+	if bq, ok := q.(cqr.BooleanQuery); ok && !stats.IsProximityOperator(bq.Operator) {
+		switch strings.ToLower(bq.Operator) {
+		case cqr.AND:
+			var blocks []Block
+			for i, child := range bq.Children {
+				blocks = append(blocks, segment(child, appendPath(path, i), excluded)...)
+			}
+			return blocks
+		case cqr.NOT:
+			var blocks []Block
+			for i, child := range bq.Children {
+				if i == 0 {
+					blocks = append(blocks, segment(child, appendPath(path, i), excluded)...)
+					continue
+				}
+				blocks = append(blocks, Block{Path: appendPath(path, i), Clause: child.String(), Excluded: !excluded, Query: child})
+			}
+			return blocks
+		}
+	}
+	return []Block{{Path: path, Clause: q.String(), Excluded: excluded, Query: q}}
+}
+
+func (s Segmenter) label(q cqr.CommonQueryRepresentation) (Label, error) {
+	keywords := analysis.QueryKeywords(q)
+	if len(keywords) == 0 {
+		return Unknown, nil
+	}
+
+	for _, f := range s.filters {
+		terms := make(map[string]bool)
+		for _, kw := range analysis.QueryKeywords(f.Query) {
+			terms[normalise(kw.QueryString)] = true
+		}
+		n := 0
+		for _, kw := range keywords {
+			if terms[normalise(kw.QueryString)] {
+				n++
+			}
+		}
+		if float64(n)/float64(len(keywords)) >= s.minOverlap {
+			return f.Label, nil
+		}
+	}
+
+	votes := make(map[Label]int)
+	var mesh *analysis.MeSH
+	for _, kw := range keywords {
+		for _, field := range kw.Fields {
+			switch field {
+			case fields.PublicationType:
+				votes[StudyDesign]++
+			case fields.MeshHeadings, fields.MeSHTerms, fields.MeSHMajorTopic, fields.MajorFocusMeshHeading:
+				if mesh == nil {
+					var err error
+					mesh, err = analysis.MeSHOrDefault(s.mesh)
+					if err != nil {
+						return Unknown, err
+					}
+				}
+				for _, c := range mesh.Categories(strings.Trim(strings.Replace(kw.QueryString, "*", "", -1), `"`)) {
+					switch c {
+					case analysis.ConditionMeSH:
+						votes[Population]++
+					case analysis.TreatmentMeSH:
+						votes[Intervention]++
+					case analysis.StudyTypeMeSH:
+						votes[StudyDesign]++
+					}
+				}
+			default:
+				continue
+			}
+			// Each keyword only votes once, however many of the fields it is searched in.
+			break
+		}
+	}
+
+	label, max, tie := Unknown, 0, false
+	for _, l := range []Label{Population, Intervention, StudyDesign} {
+		switch {
+		case votes[l] > max:
+			label, max, tie = l, votes[l], false
+		case votes[l] == max && max > 0:
+			tie = true
+		}
+	}
+	if tie {
+		return Unknown, nil
+	}
+	return label, nil
+}
+
+// normalise removes the quotes and wildcards of a keyword so that it can be compared to other keywords.
+func normalise(s string) string {
+	s = strings.Replace(s, "*", "", -1)
+	s = strings.Replace(s, `"`, "", -1)
+	return strings.ToLower(strings.TrimSpace(s))
+}
+
+func appendPath(p []int, i int) []int {
+	return append(append([]int{}, p...), i)
+}
@@ -0,0 +1,71 @@
+package blocks_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis/blocks"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestBlocks(t *testing.T) {
+	cache := combinator.NewMapQueryCache()
+	keyword := func(term string, docs ...combinator.Document) cqr.CommonQueryRepresentation {
+		kw := cqr.NewKeyword(term, "title")
+		if err := cache.Set(kw, combinator.NewDocuments(docs...)); err != nil {
+			t.Fatal(err)
+		}
+		return kw
+	}
+
+	// ((a OR b) AND (randomized OR placebo OR trial)) NOT e
+	q := cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{
+		cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+			cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{keyword("a", 1, 2), keyword("b", 3)}),
+			cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{keyword("randomized", 1, 3, 4), keyword("placebo", 2), keyword("trial", 5)}),
+		}),
+		keyword("e", 3),
+	})
+
+	segments, err := blocks.NewSegmenter().Segment(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 3 {
+		t.Fatalf("expected three blocks, got %d", len(segments))
+	}
+	for i, expected := range []blocks.Block{
+		{Path: []int{0, 0}, Label: blocks.Unknown},
+		{Path: []int{0, 1}, Label: blocks.StudyDesign},
+		{Path: []int{1}, Label: blocks.Unknown, Excluded: true},
+	} {
+		s := segments[i]
+		if !reflect.DeepEqual(s.Path, expected.Path) || s.Label != expected.Label || s.Excluded != expected.Excluded {
+			t.Errorf("expected block %d to be %+v, got %+v", i, expected, s)
+		}
+	}
+
+	qrels := trecresults.Qrels{"1": &trecresults.Qrel{Score: 2}, "3": &trecresults.Qrel{Score: 2}}
+	a, err := blocks.Analyse(pipeline.NewQuery("1", "1", q), segments, nil, cache, qrels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Retrieved != 2 || a.RelRet != 1 || a.Recall != 0.5 {
+		t.Errorf("unexpected totals %+v", a)
+	}
+
+	// Removing the excluded block retrieves the relevant document it excludes.
+	e := a.Blocks[2]
+	if e.Retrieved != 1 || e.DeltaRetrieved != 1 || math.Abs(e.DeltaRecall-0.5) > 1e-9 {
+		t.Errorf("unexpected analysis of the excluded block %+v", e)
+	}
+
+	// Removing the study design filter does not change the query.
+	f := a.Blocks[1]
+	if f.Retrieved != 5 || f.RelRet != 2 || f.DeltaRetrieved != 0 || f.DeltaRecall != 0 {
+		t.Errorf("unexpected analysis of the filter block %+v", f)
+	}
+}
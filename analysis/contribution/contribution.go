@@ -0,0 +1,168 @@
+// Package contribution analyses which clauses of a Boolean query are responsible for retrieving which relevant
+// documents, and how the effectiveness of the query would change if each clause were removed. It also finds the clauses
+// that are redundant, as they add (or exclude) few or no documents that their siblings do not.
+package contribution
+
+import (
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/trecresults"
+)
+
+// Node is the contribution of a single clause of a query. The path is the index of each child descended into from the
+// root of the query to reach the clause.
+//
+// Unique is the number of relevant documents retrieved by the clause that none of its sibling clauses retrieve.
+// DeltaRecall and DeltaPrecision are the change in recall and precision of the entire query if the clause were removed
+// from it (i.e. a negative change in recall means the clause is responsible for retrieving relevant documents).
+type Node struct {
+	Clause         string  `json:"clause"`
+	Query          string  `json:"query"`
+	Path           []int   `json:"path"`
+	Retrieved      int     `json:"retrieved"`
+	Relevant       int     `json:"relevant"`
+	Unique         int     `json:"unique"`
+	DeltaRecall    float64 `json:"delta_recall"`
+	DeltaPrecision float64 `json:"delta_precision"`
+	Children       []Node  `json:"children,omitempty"`
+}
+
+// Analysis is the contribution of every clause of a query for a topic.
+type Analysis struct {
+	Topic     string  `json:"topic"`
+	Retrieved int     `json:"retrieved"`
+	Relevant  int     `json:"relevant"`
+	RelRet    int     `json:"relret"`
+	Recall    float64 `json:"recall"`
+	Precision float64 `json:"precision"`
+	Root      Node    `json:"root"`
+}
+
+// combine computes the documents of a combinator from the documents of its children, excluding one of them.
+func combine(e *combinator.EvaluatedNode, exclude *combinator.EvaluatedNode) (combinator.Documents, error) {
+	var nodes []combinator.LogicalTreeNode
+	for _, child := range e.Children {
+		if child == exclude {
+			continue
+		}
+		nodes = append(nodes, child)
+	}
+	return e.LogicalTreeNode.(combinator.Combinator).Operator.Combine(nodes, nil)
+}
+
+// isBase returns if a node is the first clause of a `not` combinator, i.e. what the other clauses are removed from.
+func isBase(e *combinator.EvaluatedNode) bool {
+	if e.Parent == nil {
+		return false
+	}
+	c := e.Parent.LogicalTreeNode.(combinator.Combinator)
+	return c.Operator == combinator.NotOperator && e.Parent.Children[0] == e
+}
+
+// without computes the documents the entire query retrieves when the node is removed from it. Only the ancestors of the
+// node are re-computed. When a combinator is left without any clauses, it is removed as well, and removing the first
+// clause of a `not` combinator removes the entire `not` combinator (as there is nothing left to exclude documents from).
+func without(e *combinator.EvaluatedNode) (combinator.Documents, error) {
+	removed := e
+	for removed.Parent != nil && (len(removed.Parent.Children) == 1 || isBase(removed)) {
+		removed = removed.Parent
+	}
+	if removed.Parent == nil {
+		return combinator.NewDocuments(), nil
+	}
+
+	docs, err := combine(removed.Parent, removed)
+	if err != nil {
+		return combinator.Documents{}, err
+	}
+	for n := removed.Parent; n.Parent != nil; n = n.Parent {
+		var nodes []combinator.LogicalTreeNode
+		for _, sibling := range n.Parent.Children {
+			if sibling == n {
+				nodes = append(nodes, combinator.NewEvaluatedNode(n.LogicalTreeNode, docs))
+				continue
+			}
+			nodes = append(nodes, sibling)
+		}
+		docs, err = n.Parent.LogicalTreeNode.(combinator.Combinator).Operator.Combine(nodes, nil)
+		if err != nil {
+			return combinator.Documents{}, err
+		}
+	}
+	return docs, nil
+}
+
+func recallPrecision(docs, rel combinator.Documents) (float64, float64) {
+	relret := float64(docs.Intersect(rel).Len())
+	var recall, precision float64
+	if rel.Len() > 0 {
+		recall = relret / float64(rel.Len())
+	}
+	if docs.Len() > 0 {
+		precision = relret / float64(docs.Len())
+	}
+	return recall, precision
+}
+
+// annotate creates the contribution of a node, and all of its children.
+func annotate(e *combinator.EvaluatedNode, path []int, rel combinator.Documents, recall, precision float64) (Node, error) {
+	n := Node{
+		Clause:    e.String(),
+		Path:      path,
+		Retrieved: e.Docs.Len(),
+		Relevant:  e.Docs.Intersect(rel).Len(),
+	}
+	if e.Query() != nil {
+		n.Query = e.Query().String()
+	}
+
+	if e.Parent != nil {
+		unique := e.Docs.Intersect(rel)
+		for _, sibling := range e.Parent.Children {
+			if sibling != e {
+				unique = unique.Difference(sibling.Docs)
+			}
+		}
+		n.Unique = unique.Len()
+
+		docs, err := without(e)
+		if err != nil {
+			return Node{}, err
+		}
+		r, p := recallPrecision(docs, rel)
+		n.DeltaRecall = r - recall
+		n.DeltaPrecision = p - precision
+	}
+
+	for i, child := range e.Children {
+		c, err := annotate(child, append(append([]int{}, path...), i), rel, recall, precision)
+		if err != nil {
+			return Node{}, err
+		}
+		n.Children = append(n.Children, c)
+	}
+	return n, nil
+}
+
+// Analyse computes the contribution of every clause of a query for a topic. The documents of the atoms of the tree are
+// read from the cache; use combinator.NewFetchQueryCache to retrieve atoms that are missing from it.
+func Analyse(tree combinator.LogicalTree, cache combinator.QueryCacher, topic string, qrels trecresults.Qrels) (Analysis, error) {
+	root, err := combinator.Evaluate(tree.Root, cache)
+	if err != nil {
+		return Analysis{}, err
+	}
+	rel := combinator.RelevantDocuments(qrels)
+	recall, precision := recallPrecision(root.Docs, rel)
+	n, err := annotate(root, []int{}, rel, recall, precision)
+	if err != nil {
+		return Analysis{}, err
+	}
+	return Analysis{
+		Topic:     topic,
+		Retrieved: root.Docs.Len(),
+		Relevant:  rel.Len(),
+		RelRet:    root.Docs.Intersect(rel).Len(),
+		Recall:    recall,
+		Precision: precision,
+		Root:      n,
+	}, nil
+}
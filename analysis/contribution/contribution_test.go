@@ -0,0 +1,205 @@
+package contribution_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis/contribution"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/trecresults"
+	"math"
+	"testing"
+)
+
+func TestAnalyse(t *testing.T) {
+	cache := combinator.NewMapQueryCache()
+	atom := func(term string, docs ...combinator.Document) combinator.Atom {
+		kw := cqr.NewKeyword(term, "title")
+		if err := cache.Set(kw, combinator.NewDocuments(docs...)); err != nil {
+			t.Fatal(err)
+		}
+		return combinator.NewAtom(kw)
+	}
+
+	// (a AND b) OR c
+	a, b, c := atom("a", 1, 2, 3), atom("b", 2, 3, 4), atom("c", 3, 5)
+	and := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{a.Query(), b.Query()}), combinator.AndOperator, a, b)
+	or := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{and.Query(), c.Query()}), combinator.OrOperator, and, c)
+
+	qrels := trecresults.Qrels{}
+	for _, id := range []string{"2", "3", "5", "9"} {
+		qrels[id] = &trecresults.Qrel{Score: 2}
+	}
+
+	analysis, err := contribution.Analyse(combinator.LogicalTree{Root: or}, cache, "1", qrels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Retrieved != 3 || analysis.RelRet != 3 || analysis.Relevant != 4 {
+		t.Errorf("unexpected totals %+v", analysis)
+	}
+
+	// Removing c loses the only relevant document it retrieves.
+	nc := analysis.Root.Children[1]
+	if nc.Unique != 1 || math.Abs(nc.DeltaRecall+0.25) > 1e-9 || nc.DeltaPrecision != 0 {
+		t.Errorf("unexpected contribution for c %+v", nc)
+	}
+
+	// Removing a retrieves an extra non-relevant document, without changing recall.
+	na := analysis.Root.Children[0].Children[0]
+	if na.Unique != 0 || na.DeltaRecall != 0 || math.Abs(na.DeltaPrecision+0.25) > 1e-9 {
+		t.Errorf("unexpected contribution for a %+v", na)
+	}
+	if len(na.Path) != 2 || na.Path[0] != 0 || na.Path[1] != 0 {
+		t.Errorf("expected the path [0 0], got %v", na.Path)
+	}
+
+	if _, err := contribution.Text([]contribution.Analysis{analysis}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAnalyseNot(t *testing.T) {
+	cache := combinator.NewMapQueryCache()
+	atom := func(term string, docs ...combinator.Document) combinator.Atom {
+		kw := cqr.NewKeyword(term, "title")
+		if err := cache.Set(kw, combinator.NewDocuments(docs...)); err != nil {
+			t.Fatal(err)
+		}
+		return combinator.NewAtom(kw)
+	}
+
+	// (a NOT b NOT c) OR d
+	a, b, c, d := atom("a", 1, 2, 3, 4), atom("b", 2), atom("c", 3), atom("d", 5)
+	not := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{a.Query(), b.Query(), c.Query()}), combinator.NotOperator, a, b, c)
+	or := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{not.Query(), d.Query()}), combinator.OrOperator, not, d)
+
+	qrels := trecresults.Qrels{"1": &trecresults.Qrel{Score: 2}, "3": &trecresults.Qrel{Score: 2}, "5": &trecresults.Qrel{Score: 2}}
+	analysis, err := contribution.Analyse(combinator.LogicalTree{Root: or}, cache, "1", qrels)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Removing a removes the entire `not` clause, rather than excluding c from b.
+	na := analysis.Root.Children[0].Children[0]
+	if math.Abs(na.DeltaRecall+1.0/3) > 1e-9 || math.Abs(na.DeltaPrecision-1.0/3) > 1e-9 {
+		t.Errorf("unexpected contribution for a %+v", na)
+	}
+
+	// Removing c retrieves the relevant document it excludes.
+	nc := analysis.Root.Children[0].Children[2]
+	if math.Abs(nc.DeltaRecall-1.0/3) > 1e-9 {
+		t.Errorf("unexpected contribution for c %+v", nc)
+	}
+}
+
+func TestRedundancies(t *testing.T) {
+	cache := combinator.NewMapQueryCache()
+	atom := func(term string, docs ...combinator.Document) combinator.Atom {
+		kw := cqr.NewKeyword(term, "title")
+		if err := cache.Set(kw, combinator.NewDocuments(docs...)); err != nil {
+			t.Fatal(err)
+		}
+		return combinator.NewAtom(kw)
+	}
+
+	// (a OR b OR c) AND d, where a subsumes b, and c adds a single document.
+	a, b, c, d := atom("a", 1, 2, 3, 4, 6), atom("b", 2, 3), atom("c", 4, 5), atom("d", 1, 2, 3, 4, 5, 6, 7, 8)
+	or := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a.Query(), b.Query(), c.Query()}), combinator.OrOperator, a, b, c)
+	and := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{or.Query(), d.Query()}), combinator.AndOperator, or, d)
+
+	qrels := trecresults.Qrels{"5": &trecresults.Qrel{Score: 2}, "2": &trecresults.Qrel{Score: 2}}
+	analysis, err := contribution.Redundancies(combinator.LogicalTree{Root: and}, cache, "1", qrels, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redundant := make(map[string]contribution.Redundancy)
+	for _, r := range analysis.Redundancies {
+		redundant[r.Query] = r
+	}
+	if len(redundant) != 3 {
+		t.Fatalf("expected b, c and d to be redundant, got %+v", analysis.Redundancies)
+	}
+
+	rb := redundant[b.Query().String()]
+	if !rb.Subsumed || len(rb.SubsumedBy) != 1 || rb.SubsumedBy[0][1] != 0 || rb.DeltaRetrieved != 0 {
+		t.Errorf("expected b to be subsumed by a, got %+v", rb)
+	}
+	rc := redundant[c.Query().String()]
+	if rc.Subsumed || rc.Change != 1 || rc.DeltaRetrieved != -1 || rc.RelevantLost != 1 {
+		t.Errorf("expected c to add a single relevant document, got %+v", rc)
+	}
+	// d retrieves every document that the disjunction does.
+	rd := redundant[d.Query().String()]
+	if !rd.Subsumed || len(rd.SubsumedBy) != 1 {
+		t.Errorf("expected d to be subsumed by the disjunction, got %+v", rd)
+	}
+
+	if _, err := contribution.RedundancyText([]contribution.RedundancyAnalysis{analysis}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	cache := combinator.NewMapQueryCache()
+	atom := func(term string, docs ...combinator.Document) combinator.Atom {
+		kw := cqr.NewKeyword(term, "title")
+		if err := cache.Set(kw, combinator.NewDocuments(docs...)); err != nil {
+			t.Fatal(err)
+		}
+		return combinator.NewAtom(kw)
+	}
+
+	// (a OR b) AND (c OR a), where 9 is relevant but never retrieved.
+	a, b, c := atom("a", 1, 2, 3), atom("b", 3, 4), atom("c", 2, 5, 6)
+	or1 := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a.Query(), b.Query()}), combinator.OrOperator, a, b)
+	or2 := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{c.Query(), a.Query()}), combinator.OrOperator, c, a)
+	and := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{or1.Query(), or2.Query()}), combinator.AndOperator, or1, or2)
+
+	qrels := trecresults.Qrels{}
+	for _, id := range []string{"1", "4", "5", "9"} {
+		qrels[id] = &trecresults.Qrel{Score: 2}
+	}
+	analysis, err := contribution.Coverage(combinator.LogicalTree{Root: and}, cache, "1", qrels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Relevant != 4 || analysis.Reached != 3 || len(analysis.Unreached) != 1 || analysis.Unreached[0] != "9" {
+		t.Errorf("unexpected coverage %+v", analysis)
+	}
+	if len(analysis.Keywords) != 3 {
+		t.Fatalf("expected a repeated atom to be reported once, got %+v", analysis.Keywords)
+	}
+
+	ka := analysis.Keywords[0]
+	if len(ka.Paths) != 2 || ka.Relevant != 1 || len(ka.Unique) != 1 || ka.Unique[0] != "1" || math.Abs(ka.Recall-0.25) > 1e-9 || math.Abs(ka.Precision-1.0/3) > 1e-9 {
+		t.Errorf("unexpected coverage of a %+v", ka)
+	}
+	kb := analysis.Keywords[1]
+	if kb.Retrieved != 2 || kb.Relevant != 1 || len(kb.Unique) != 1 || kb.Unique[0] != "4" {
+		t.Errorf("unexpected coverage of b %+v", kb)
+	}
+
+	if _, err := output.TextCoverageFormatter([]contribution.CoverageAnalysis{analysis}); err != nil {
+		t.Fatal(err)
+	}
+
+	// In (a OR b) NOT c, c is excluded, so the relevant document only it retrieves is not reached, and the relevant
+	// document only it and a retrieve is unique to a.
+	c = atom("c", 1, 5)
+	not := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{or1.Query(), c.Query()}), combinator.NotOperator, or1, c)
+	analysis, err = contribution.Coverage(combinator.LogicalTree{Root: not}, cache, "1", qrels)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if analysis.Reached != 2 || len(analysis.Unreached) != 2 {
+		t.Errorf("expected atoms excluded by not to reach nothing, got %+v", analysis)
+	}
+	kc := analysis.Keywords[2]
+	if !kc.Excluded || kc.Relevant != 2 || len(kc.Unique) != 0 {
+		t.Errorf("unexpected coverage of c %+v", kc)
+	}
+	if ka := analysis.Keywords[0]; ka.Excluded || len(ka.Unique) != 1 || ka.Unique[0] != "1" {
+		t.Errorf("unexpected coverage of a %+v", ka)
+	}
+}
@@ -0,0 +1,121 @@
+package contribution
+
+import (
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/trecresults"
+	"strconv"
+)
+
+// KeywordCoverage is the coverage of the relevant documents by a single atom of a query (i.e. a keyword, or an
+// adjacency clause), considered on its own rather than as part of the query. An atom that is used several times in a
+// query is only reported once, with the path of each use.
+//
+// Unique lists the relevant documents that the atom retrieves and that no other atom of the query retrieves. Excluded
+// is whether the atom is only used in clauses that are excluded by a `not` operator; the documents of an excluded atom
+// are never retrieved by the query, so they are not unique to it, nor reached.
+type KeywordCoverage struct {
+	Query     string   `json:"query"`
+	Paths     [][]int  `json:"paths"`
+	Excluded  bool     `json:"excluded,omitempty"`
+	Retrieved int      `json:"retrieved"`
+	Relevant  int      `json:"relevant"`
+	Recall    float64  `json:"recall"`
+	Precision float64  `json:"precision"`
+	Unique    []string `json:"unique"`
+}
+
+// CoverageAnalysis is the coverage of the relevant documents of a topic by each atom of a query. Reached is the number
+// of relevant documents retrieved by at least one atom that is not excluded, and Unreached lists the relevant documents
+// no such atom retrieves (and that the query could therefore never retrieve, however its atoms were combined).
+type CoverageAnalysis struct {
+	Topic     string            `json:"topic"`
+	Relevant  int               `json:"relevant"`
+	Reached   int               `json:"reached"`
+	Keywords  []KeywordCoverage `json:"keywords"`
+	Unreached []string          `json:"unreached"`
+}
+
+// atoms finds the atoms of a tree, in the order they appear in the query. Atoms below the clauses a `not` operator
+// excludes are excluded, unless they are also used elsewhere.
+func atoms(node combinator.LogicalTreeNode, path []int, excluded bool, found map[string]*KeywordCoverage, order *[]string) {
+	if c, ok := node.(combinator.Combinator); ok {
+		for i, clause := range c.Clauses {
+			atoms(clause, append(append([]int{}, path...), i), excluded || (c.Operator == combinator.NotOperator && i > 0), found, order)
+		}
+		return
+	}
+	key := node.Query().String()
+	if k, ok := found[key]; ok {
+		k.Paths = append(k.Paths, path)
+		k.Excluded = k.Excluded && excluded
+		return
+	}
+	found[key] = &KeywordCoverage{Query: key, Paths: [][]int{path}, Excluded: excluded}
+	*order = append(*order, key)
+}
+
+// Coverage computes the recall, precision, and uniquely retrieved relevant documents of each atom of a query for a
+// topic, and the relevant documents that no atom retrieves. The documents of the atoms of the tree are read from the
+// cache; use combinator.NewFetchQueryCache to retrieve atoms that are missing from it.
+func Coverage(tree combinator.LogicalTree, cache combinator.QueryCacher, topic string, qrels trecresults.Qrels) (CoverageAnalysis, error) {
+	found := make(map[string]*KeywordCoverage)
+	var order []string
+	atoms(tree.Root, []int{}, false, found, &order)
+
+	rel := combinator.RelevantDocuments(qrels)
+	docs := make([]combinator.Documents, len(order))
+	reached := combinator.NewDocuments()
+	for i, key := range order {
+		k := found[key]
+		var err error
+		docs[i], err = atomDocuments(tree.Root, k.Paths[0], cache)
+		if err != nil {
+			return CoverageAnalysis{}, err
+		}
+		k.Retrieved = docs[i].Len()
+		k.Relevant = docs[i].Intersect(rel).Len()
+		k.Recall, k.Precision = recallPrecision(docs[i], rel)
+		if !k.Excluded {
+			reached = reached.Union(docs[i].Intersect(rel))
+		}
+	}
+
+	a := CoverageAnalysis{
+		Topic:    topic,
+		Relevant: rel.Len(),
+		Keywords: make([]KeywordCoverage, len(order)),
+	}
+	for i, key := range order {
+		k := found[key]
+		unique := combinator.NewDocuments()
+		if !k.Excluded {
+			unique = docs[i].Intersect(rel)
+			for j := range order {
+				if i != j && !found[order[j]].Excluded {
+					unique = unique.Difference(docs[j])
+				}
+			}
+		}
+		k.Unique = documentIDs(unique)
+		a.Keywords[i] = *k
+	}
+	a.Reached = reached.Len()
+	a.Unreached = documentIDs(rel.Difference(reached))
+	return a, nil
+}
+
+// atomDocuments retrieves the documents of the atom at a path of a tree.
+func atomDocuments(node combinator.LogicalTreeNode, path []int, cache combinator.QueryCacher) (combinator.Documents, error) {
+	for _, i := range path {
+		node = node.(combinator.Combinator).Clauses[i]
+	}
+	return node.Documents(cache)
+}
+
+func documentIDs(docs combinator.Documents) []string {
+	ids := make([]string, 0, docs.Len())
+	for _, id := range docs.IDs() {
+		ids = append(ids, strconv.FormatUint(uint64(id), 10))
+	}
+	return ids
+}
@@ -0,0 +1,183 @@
+package contribution
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/trecresults"
+	"strings"
+)
+
+// Redundancy is a clause of a query that changes the documents retrieved by the clause it is nested in by fewer than a
+// threshold number of documents. For a clause of an `or` query, the change is the number of documents that only it
+// contributes, and for a clause of an `and` or `not` query, it is the number of documents that only it excludes.
+//
+// A subsumed clause does not change the documents retrieved at all. SubsumedBy lists the paths of the sibling clauses
+// that subsume it on their own (e.g. a text word retrieving nothing that an exploded MeSH heading does not); a subsumed
+// clause may also be subsumed only by the combination of its siblings.
+//
+// DeltaRetrieved, DeltaRecall and RelevantLost are the effect of removing the clause on the entire query.
+type Redundancy struct {
+	Clause         string  `json:"clause"`
+	Query          string  `json:"query"`
+	Path           []int   `json:"path"`
+	Operator       string  `json:"operator"`
+	Retrieved      int     `json:"retrieved"`
+	Change         int     `json:"change"`
+	Subsumed       bool    `json:"subsumed"`
+	SubsumedBy     [][]int `json:"subsumed_by,omitempty"`
+	DeltaRetrieved int     `json:"delta_retrieved"`
+	DeltaRecall    float64 `json:"delta_recall"`
+	RelevantLost   int     `json:"relevant_lost"`
+}
+
+// RedundancyAnalysis is every redundant clause of a query for a topic.
+type RedundancyAnalysis struct {
+	Topic        string       `json:"topic"`
+	Threshold    int          `json:"threshold"`
+	Retrieved    int          `json:"retrieved"`
+	RelRet       int          `json:"relret"`
+	Recall       float64      `json:"recall"`
+	Redundancies []Redundancy `json:"redundancies"`
+}
+
+// subsumes returns if a sibling clause makes a clause redundant on its own. For an `or` query, the sibling must
+// retrieve every document the clause does, and for an `and` query, the clause must retrieve every document the sibling
+// does. For a `not` query, an excluded sibling must exclude every document the clause excludes.
+func subsumes(operator string, e, sibling, first *combinator.EvaluatedNode) bool {
+	switch operator {
+	case "or":
+		return e.Docs.Difference(sibling.Docs).Len() == 0
+	case "and":
+		return sibling.Docs.Difference(e.Docs).Len() == 0
+	case "not":
+		return sibling != first && e.Docs.Intersect(first.Docs).Difference(sibling.Docs).Len() == 0
+	}
+	return false
+}
+
+// redundancies finds the redundant clauses nested in a node.
+func redundancies(e *combinator.EvaluatedNode, path []int, threshold int, rel combinator.Documents, root *combinator.EvaluatedNode, recall float64) ([]Redundancy, error) {
+	var found []Redundancy
+	c, ok := e.LogicalTreeNode.(combinator.Combinator)
+	if !ok {
+		return nil, nil
+	}
+	operator := c.Operator.String()
+
+	for i, child := range e.Children {
+		p := append(append([]int{}, path...), i)
+		nested, err := redundancies(child, p, threshold, rel, root, recall)
+		if err != nil {
+			return nil, err
+		}
+
+		// Removing the first clause of a `not` query changes its meaning, rather than its documents.
+		if operator == "not" && i == 0 {
+			found = append(found, nested...)
+			continue
+		}
+
+		docs, err := combine(e, child)
+		if err != nil {
+			return nil, err
+		}
+		change := docs.Len() - e.Docs.Len()
+		if change < 0 {
+			change = -change
+		}
+		if change >= threshold && change > 0 {
+			found = append(found, nested...)
+			continue
+		}
+
+		r := Redundancy{
+			Clause:    child.String(),
+			Path:      p,
+			Operator:  operator,
+			Retrieved: child.Docs.Len(),
+			Change:    change,
+			Subsumed:  change == 0,
+		}
+		if child.Query() != nil {
+			r.Query = child.Query().String()
+		}
+		for j, sibling := range e.Children {
+			if sibling != child && subsumes(operator, child, sibling, e.Children[0]) {
+				r.SubsumedBy = append(r.SubsumedBy, append(append([]int{}, path...), j))
+			}
+		}
+
+		remaining, err := without(child)
+		if err != nil {
+			return nil, err
+		}
+		withoutRecall, _ := recallPrecision(remaining, rel)
+		r.DeltaRetrieved = remaining.Len() - root.Docs.Len()
+		r.DeltaRecall = withoutRecall - recall
+		r.RelevantLost = root.Docs.Intersect(rel).Difference(remaining).Len()
+
+		found = append(found, r)
+		found = append(found, nested...)
+	}
+	return found, nil
+}
+
+// Redundancies finds the clauses of a query that are subsumed by their siblings, or that change the documents retrieved
+// by the clause they are nested in by fewer than threshold documents, along with the effect of removing them on the
+// retrieval size and recall of the query. Subsumed clauses are always reported. The documents of the atoms of the tree
+// are read from the cache; use combinator.NewFetchQueryCache to retrieve atoms that are missing from it.
+func Redundancies(tree combinator.LogicalTree, cache combinator.QueryCacher, topic string, qrels trecresults.Qrels, threshold int) (RedundancyAnalysis, error) {
+	root, err := combinator.Evaluate(tree.Root, cache)
+	if err != nil {
+		return RedundancyAnalysis{}, err
+	}
+	rel := combinator.RelevantDocuments(qrels)
+	recall, _ := recallPrecision(root.Docs, rel)
+	found, err := redundancies(root, []int{}, threshold, rel, root, recall)
+	if err != nil {
+		return RedundancyAnalysis{}, err
+	}
+	return RedundancyAnalysis{
+		Topic:        topic,
+		Threshold:    threshold,
+		Retrieved:    root.Docs.Len(),
+		RelRet:       root.Docs.Intersect(rel).Len(),
+		Recall:       recall,
+		Redundancies: found,
+	}, nil
+}
+
+// RedundancyJSON outputs the redundancy analyses in a JSON format.
+func RedundancyJSON(analyses []RedundancyAnalysis) (string, error) {
+	v, err := json.MarshalIndent(analyses, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// RedundancyText outputs the redundancy analyses as a report, one line per redundant clause.
+func RedundancyText(analyses []RedundancyAnalysis) (string, error) {
+	var b bytes.Buffer
+	for i, a := range analyses {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "topic %s: retrieved %d, relret %d, recall %.4f, %d redundant clauses (threshold %d)\n",
+			a.Topic, a.Retrieved, a.RelRet, a.Recall, len(a.Redundancies), a.Threshold)
+		for _, r := range a.Redundancies {
+			status := fmt.Sprintf("changes %d documents", r.Change)
+			if r.Subsumed {
+				status = "subsumed"
+				if len(r.SubsumedBy) > 0 {
+					status = fmt.Sprintf("subsumed by %v", r.SubsumedBy)
+				}
+			}
+			fmt.Fprintf(&b, "  %v %s (%s, ret=%d): %s; removing it: Δretrieved=%+d Δrecall=%+.4f relevant lost=%d\n",
+				r.Path, strings.Replace(r.Query, "\n", " ", -1), r.Operator, r.Retrieved, status, r.DeltaRetrieved, r.DeltaRecall, r.RelevantLost)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,41 @@
+package contribution
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// JSON outputs the contribution analyses in a JSON format.
+func JSON(analyses []Analysis) (string, error) {
+	v, err := json.MarshalIndent(analyses, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// Text outputs the contribution analyses as an indented report, one per topic, where each clause is indented under
+// the clause it is nested in.
+func Text(analyses []Analysis) (string, error) {
+	var b bytes.Buffer
+	for i, a := range analyses {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "topic %s: retrieved %d, relevant %d/%d, recall %.4f, precision %.4f\n",
+			a.Topic, a.Retrieved, a.RelRet, a.Relevant, a.Recall, a.Precision)
+		writeNode(&b, a.Root, 1)
+	}
+	return b.String(), nil
+}
+
+func writeNode(b *bytes.Buffer, n Node, depth int) {
+	clause := strings.Replace(n.Clause, "\n", " ", -1)
+	fmt.Fprintf(b, "%s%s ret=%d rel=%d unique=%d Δrecall=%+.4f Δprecision=%+.4f\n",
+		strings.Repeat("  ", depth), clause, n.Retrieved, n.Relevant, n.Unique, n.DeltaRecall, n.DeltaPrecision)
+	for _, child := range n.Children {
+		writeNode(b, child, depth+1)
+	}
+}
@@ -0,0 +1,179 @@
+// Package diagnosis explains why relevant documents were not retrieved by a Boolean query, by identifying the clauses
+// of the query that excluded them.
+package diagnosis
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"strconv"
+	"strings"
+)
+
+// Match is a keyword in a failing clause that would have matched the document had it been searched in another field.
+type Match struct {
+	Keyword string   `json:"keyword"`
+	Fields  []string `json:"fields"`
+	Field   string   `json:"field"`
+}
+
+// Failure is the highest clause of a query that a document failed to match, i.e. a clause whose failure caused the
+// entire query to not retrieve the document. The path is the index of each child descended into from the root of the
+// query to reach the clause. When Excluded is true, the document was retrieved but then removed by a `not` clause.
+type Failure struct {
+	Path     []int   `json:"path"`
+	Clause   string  `json:"clause"`
+	Excluded bool    `json:"excluded"`
+	Matches  []Match `json:"matches,omitempty"`
+}
+
+// Diagnosis is the reason a single relevant document was not retrieved.
+type Diagnosis struct {
+	Document string    `json:"document"`
+	Failures []Failure `json:"failures"`
+}
+
+// Report is the diagnosis of every relevant document a query did not retrieve for a topic.
+type Report struct {
+	Topic  string      `json:"topic"`
+	Missed []Diagnosis `json:"missed"`
+}
+
+// failures walks down the tree from a node that did not retrieve a document, to the highest clauses that are
+// responsible. A conjunction fails because of each of the conjuncts the document does not match, and a disjunction
+// (none of whose disjuncts match the document) fails as a whole.
+func failures(n *combinator.EvaluatedNode, doc combinator.Document, path []int) []Failure {
+	c, ok := n.LogicalTreeNode.(combinator.Combinator)
+	if !ok || len(n.Children) == 0 || (c.Operator != combinator.AndOperator && c.Operator != combinator.NotOperator) {
+		return []Failure{{Path: path, Clause: n.Query().String()}}
+	}
+
+	child := func(i int) []int {
+		return append(append([]int{}, path...), i)
+	}
+
+	var f []Failure
+	switch c.Operator {
+	case combinator.AndOperator:
+		for i, ch := range n.Children {
+			if !ch.Docs.Contains(doc) {
+				f = append(f, Failure{Path: child(i), Clause: ch.Query().String()})
+			}
+		}
+	case combinator.NotOperator:
+		if !n.Children[0].Docs.Contains(doc) {
+			return failures(n.Children[0], doc, child(0))
+		}
+		for i, ch := range n.Children[1:] {
+			if ch.Docs.Contains(doc) {
+				f = append(f, Failure{Path: child(i + 1), Clause: ch.Query().String(), Excluded: true})
+			}
+		}
+	}
+	return f
+}
+
+// lookup finds the clause at the path.
+func lookup(n *combinator.EvaluatedNode, path []int) *combinator.EvaluatedNode {
+	for _, i := range path {
+		n = n.Children[i]
+	}
+	return n
+}
+
+// matches finds the keywords in a clause that would have retrieved a document if they were searched in other fields.
+func matches(query cqr.CommonQueryRepresentation, doc combinator.Document, fields []string, cache combinator.QueryCacher) ([]Match, error) {
+	var m []Match
+	for _, kw := range analysis.QueryKeywords(query) {
+		for _, field := range fields {
+			searched := false
+			for _, f := range kw.Fields {
+				if f == field {
+					searched = true
+					break
+				}
+			}
+			if searched {
+				continue
+			}
+
+			alt := kw
+			alt.Fields = []string{field}
+			docs, err := cache.Get(alt)
+			if err != nil {
+				return nil, err
+			}
+			if docs.Contains(doc) {
+				m = append(m, Match{Keyword: kw.QueryString, Fields: kw.Fields, Field: field})
+			}
+		}
+	}
+	return m, nil
+}
+
+// Diagnose finds the relevant documents that a query does not retrieve for a topic, and reports the highest clauses of
+// the query each of them failed. For each failing clause, the keywords in the clause are searched in each of the
+// fields to identify the keywords that would have matched the document in another field. Atoms missing from the cache
+// and the keywords searched in other fields are retrieved from the statistics source.
+func Diagnose(tree combinator.LogicalTree, cache combinator.QueryCacher, ss stats.StatisticsSource, topic string, qrels trecresults.Qrels, fields []string) (Report, error) {
+	cache = combinator.NewFetchQueryCache(cache, ss)
+	root, err := combinator.Evaluate(tree.Root, cache)
+	if err != nil {
+		return Report{}, err
+	}
+
+	missed := combinator.RelevantDocuments(qrels).Difference(root.Docs).IDs()
+
+	report := Report{Topic: topic, Missed: make([]Diagnosis, len(missed))}
+	for i, id := range missed {
+		doc := combinator.Document(id)
+		f := failures(root, doc, []int{})
+		for j := range f {
+			if f[j].Excluded {
+				continue
+			}
+			f[j].Matches, err = matches(lookup(root, f[j].Path).Query(), doc, fields, cache)
+			if err != nil {
+				return Report{}, err
+			}
+		}
+		report.Missed[i] = Diagnosis{Document: doc.String(), Failures: f}
+	}
+	return report, nil
+}
+
+// blockName describes a clause by its position in the query, i.e. `concept block 2` for the second clause of the
+// root, or `concept block 2.1` for the first clause nested in it.
+func blockName(path []int) string {
+	if len(path) == 0 {
+		return "the query"
+	}
+	p := make([]string, len(path))
+	for i, j := range path {
+		p[i] = strconv.Itoa(j + 1)
+	}
+	return "concept block " + strings.Join(p, ".")
+}
+
+// String outputs the report as a list of actionable reasons, one line for each clause a missed document failed.
+func (r Report) String() string {
+	var b bytes.Buffer
+	for _, d := range r.Missed {
+		for _, f := range d.Failures {
+			if f.Excluded {
+				fmt.Fprintf(&b, "%s: doc %s is excluded by %s\n", r.Topic, d.Document, blockName(f.Path))
+				continue
+			}
+			fmt.Fprintf(&b, "%s: doc %s fails %s", r.Topic, d.Document, blockName(f.Path))
+			for _, m := range f.Matches {
+				fmt.Fprintf(&b, "; matches '%s' in %s but block restricted to %v", m.Keyword, m.Field, m.Fields)
+			}
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
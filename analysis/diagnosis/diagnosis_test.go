@@ -0,0 +1,113 @@
+package diagnosis_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis/diagnosis"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"strconv"
+	"testing"
+)
+
+// fieldSource is a statistics source backed by an in-memory posting list of fields to keywords to documents.
+type fieldSource struct {
+	postings map[string]map[string][]uint32
+}
+
+func (f fieldSource) SearchOptions() stats.SearchOptions { return stats.SearchOptions{} }
+func (f fieldSource) Parameters() map[string]float64     { return nil }
+func (f fieldSource) TermFrequency(term, field, document string) (float64, error) {
+	return 0, nil
+}
+func (f fieldSource) TermVector(document string) (stats.TermVector, error)   { return nil, nil }
+func (f fieldSource) DocumentFrequency(term, field string) (float64, error)  { return 0, nil }
+func (f fieldSource) TotalTermFrequency(term, field string) (float64, error) { return 0, nil }
+func (f fieldSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	return 0, nil
+}
+func (f fieldSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	return 0, nil
+}
+func (f fieldSource) VocabularySize(field string) (float64, error) { return 0, nil }
+func (f fieldSource) Execute(query pipeline.Query, options stats.SearchOptions) (trecresults.ResultList, error) {
+	var results trecresults.ResultList
+	if kw, ok := query.Query.(cqr.Keyword); ok {
+		for _, field := range kw.Fields {
+			for _, id := range f.postings[field][kw.QueryString] {
+				results = append(results, &trecresults.Result{Topic: query.Topic, DocId: strconv.Itoa(int(id))})
+			}
+		}
+	}
+	return results, nil
+}
+func (f fieldSource) CollectionSize() (float64, error) { return 10, nil }
+
+func TestDiagnose(t *testing.T) {
+	ss := fieldSource{map[string]map[string][]uint32{
+		"title": {
+			"heart":    {1, 2, 7},
+			"neoplasm": {1},
+			"tumour":   {2},
+		},
+		"abstract": {
+			"neoplasm": {7},
+		},
+	}}
+	// heart[ti] AND (neoplasm[ti] OR tumour[ti])
+	q := pipeline.NewQuery("diagnose", "1", cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+		cqr.NewKeyword("heart", "title"),
+		cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("neoplasm", "title"),
+			cqr.NewKeyword("tumour", "title"),
+		}),
+	}))
+	cache := combinator.NewMapQueryCache()
+	tree, _, err := combinator.NewLogicalTree(q, ss, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qrels := trecresults.Qrels{
+		"1": &trecresults.Qrel{Score: 2},
+		"7": &trecresults.Qrel{Score: 2},
+	}
+	report, err := diagnosis.Diagnose(tree, cache, ss, "1", qrels, []string{"title", "abstract"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missed) != 1 || report.Missed[0].Document != "7" {
+		t.Fatalf("expected document 7 to be missed, got %+v", report.Missed)
+	}
+	f := report.Missed[0].Failures
+	if len(f) != 1 || len(f[0].Path) != 1 || f[0].Path[0] != 1 {
+		t.Fatalf("expected the second concept block to fail, got %+v", f)
+	}
+	if len(f[0].Matches) != 1 || f[0].Matches[0].Keyword != "neoplasm" || f[0].Matches[0].Field != "abstract" {
+		t.Errorf("expected neoplasm to match in the abstract, got %+v", f[0].Matches)
+	}
+
+	// neoplasm[ti] OR (tumour[ti] AND heart[ti]) fails as a whole, rather than at each of its disjuncts.
+	q = pipeline.NewQuery("diagnose", "1", cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+		cqr.NewKeyword("neoplasm", "title"),
+		cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("tumour", "title"),
+			cqr.NewKeyword("heart", "title"),
+		}),
+	}))
+	tree, _, err = combinator.NewLogicalTree(q, ss, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = diagnosis.Diagnose(tree, cache, ss, "1", qrels, []string{"title", "abstract"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Missed) != 1 || len(report.Missed[0].Failures) != 1 || len(report.Missed[0].Failures[0].Path) != 0 {
+		t.Fatalf("expected the entire query to fail, got %+v", report.Missed)
+	}
+	if m := report.Missed[0].Failures[0].Matches; len(m) != 1 || m[0].Keyword != "neoplasm" {
+		t.Errorf("expected neoplasm to match in the abstract, got %+v", m)
+	}
+}
@@ -0,0 +1,417 @@
+// Package diff compares two Boolean queries, e.g. a query before and after it has been rewritten by a query chain. It
+// computes a tree edit distance between the queries, and an aligned diff that lists the clauses that were added,
+// removed, or modified.
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/transmute"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Operation is the way a clause was changed.
+type Operation string
+
+const (
+	// Added is a clause that is only in the modified query.
+	Added Operation = "added"
+	// Removed is a clause that is only in the original query.
+	Removed Operation = "removed"
+	// Modified is a keyword whose query string or options were changed.
+	Modified Operation = "modified"
+	// OperatorChanged is a Boolean clause whose operator was changed.
+	OperatorChanged Operation = "operator"
+	// FieldsChanged is a keyword whose fields were changed.
+	FieldsChanged Operation = "fields"
+)
+
+// Change is a single change between the original and the modified query. The paths are the index of each child
+// descended into from the root of each query to reach the clause; the original path of an added clause and the
+// modified path of a removed clause are nil. Clauses are written in PubMed syntax.
+type Change struct {
+	Operation    Operation `json:"operation"`
+	OriginalPath []int     `json:"original_path"`
+	ModifiedPath []int     `json:"modified_path"`
+	Original     string    `json:"original,omitempty"`
+	Modified     string    `json:"modified,omitempty"`
+}
+
+func (c Change) String() string {
+	switch c.Operation {
+	case Added:
+		return fmt.Sprintf("+ /%s %s", join(c.ModifiedPath), c.Modified)
+	case Removed:
+		return fmt.Sprintf("- /%s %s", join(c.OriginalPath), c.Original)
+	}
+	return fmt.Sprintf("~ /%s -> /%s %s: %s => %s", join(c.OriginalPath), join(c.ModifiedPath), c.Operation, c.Original, c.Modified)
+}
+
+// Diff is the alignment of an original and a modified query. The distance is the number of clauses that were added,
+// removed, or modified to turn the original query into the modified query.
+type Diff struct {
+	Distance float64  `json:"distance"`
+	Changes  []Change `json:"changes"`
+	root     *alignment
+}
+
+// alignment is a pair of aligned clauses. Either clause is nil when the other clause was added or removed.
+type alignment struct {
+	original, modified         cqr.CommonQueryRepresentation
+	originalPath, modifiedPath []int
+	cost                       float64
+	children                   []*alignment
+}
+
+// Compare aligns the clauses of two queries. Children are only aligned with children of aligned clauses, however the
+// order of children does not matter, as the order of the clauses of a Boolean query does not change the documents it
+// retrieves. Each child is aligned using a minimum cost assignment, so the distance is a constrained unordered tree
+// edit distance in which adding, removing, or modifying a clause each cost one.
+func Compare(original, modified cqr.CommonQueryRepresentation) Diff {
+	root := align(original, modified, []int{}, []int{})
+	d := Diff{Distance: root.cost, root: root}
+	d.Changes = changes(root, d.Changes)
+	return d
+}
+
+// Distance is the tree edit distance between two queries.
+func Distance(original, modified cqr.CommonQueryRepresentation) float64 {
+	return Compare(original, modified).Distance
+}
+
+func (d Diff) String() string {
+	b := new(bytes.Buffer)
+	for _, c := range d.Changes {
+		fmt.Fprintln(b, c)
+	}
+	return b.String()
+}
+
+// SideBySide writes the original and the modified query next to each other, one clause per line, in PubMed syntax.
+// Each line is marked with how the clause was changed.
+func (d Diff) SideBySide() string {
+	var left, right, markers []string
+	var walk func(a *alignment, depth int)
+	walk = func(a *alignment, depth int) {
+		if a.original == nil && a.modified == nil {
+			// A replaced clause.
+			for _, child := range a.children {
+				walk(child, depth)
+			}
+			return
+		}
+		indent := strings.Repeat("  ", depth)
+		l, r, m := "", "", " "
+		if a.original != nil {
+			l = indent + label(a.original)
+		}
+		if a.modified != nil {
+			r = indent + label(a.modified)
+		}
+		switch {
+		case a.original == nil:
+			m = "+"
+		case a.modified == nil:
+			m = "-"
+		case l != r:
+			m = "~"
+		}
+		left, right, markers = append(left, l), append(right, r), append(markers, m)
+		for _, child := range a.children {
+			walk(child, depth+1)
+		}
+	}
+	walk(d.root, 0)
+
+	width := 0
+	for _, l := range left {
+		if len(l) > width {
+			width = len(l)
+		}
+	}
+	b := new(bytes.Buffer)
+	for i := range left {
+		fmt.Fprintln(b, strings.TrimRight(fmt.Sprintf("%-*s %s %s", width, left[i], markers[i], right[i]), " "))
+	}
+	return b.String()
+}
+
+// align computes the lowest cost alignment of two clauses.
+func align(a, b cqr.CommonQueryRepresentation, pa, pb []int) *alignment {
+	switch x := a.(type) {
+	case cqr.Keyword:
+		if y, ok := b.(cqr.Keyword); ok {
+			n := &alignment{original: x, modified: y, originalPath: pa, modifiedPath: pb}
+			if x.QueryString != y.QueryString || !sameFields(x, y) || !sameOptions(x.Options, y.Options) {
+				n.cost = 1
+			}
+			return n
+		}
+	case cqr.BooleanQuery:
+		if y, ok := b.(cqr.BooleanQuery); ok {
+			n := &alignment{original: x, modified: y, originalPath: pa, modifiedPath: pb}
+			if !strings.EqualFold(x.Operator, y.Operator) || !sameOptions(x.Options, y.Options) {
+				n.cost = 1
+			}
+			n.children = alignChildren(x.Children, y.Children, pa, pb)
+			for _, child := range n.children {
+				n.cost += child.cost
+			}
+			return n
+		}
+	}
+	// A keyword can only be replaced by a Boolean clause (and vice versa) by removing one and adding the other.
+	return &alignment{
+		cost: size(a) + size(b),
+		children: []*alignment{
+			unaligned(a, pa, true),
+			unaligned(b, pb, false),
+		},
+	}
+}
+
+// alignChildren aligns the children of two Boolean clauses using a minimum cost assignment. A child can be assigned to
+// a child of the other clause, or be added or removed at the cost of the size of the child.
+func alignChildren(a, b []cqr.CommonQueryRepresentation, pa, pb []int) []*alignment {
+	n := len(a) + len(b)
+	pairs := make([][]*alignment, len(a))
+	cost := make([][]float64, n)
+	for i := range cost {
+		cost[i] = make([]float64, n)
+	}
+	for i := range a {
+		pairs[i] = make([]*alignment, len(b))
+		for j := range b {
+			pairs[i][j] = align(a[i], b[j], child(pa, i), child(pb, j))
+			cost[i][j] = pairs[i][j].cost
+		}
+		for j := len(b); j < n; j++ {
+			cost[i][j] = size(a[i])
+		}
+	}
+	for i := len(a); i < n; i++ {
+		for j := range b {
+			cost[i][j] = size(b[j])
+		}
+	}
+
+	assigned := assign(cost)
+	var children, added []*alignment
+	for i := range a {
+		j := assigned[i]
+		// Replacing a clause costs as much as removing and adding it, so it is reported as such.
+		if j < len(b) && pairs[i][j].original != nil {
+			children = append(children, pairs[i][j])
+			continue
+		}
+		children = append(children, unaligned(a[i], child(pa, i), true))
+	}
+	for i := len(a); i < n; i++ {
+		if j := assigned[i]; j < len(b) {
+			added = append(added, unaligned(b[j], child(pb, j), false))
+		}
+	}
+	for i := range a {
+		if j := assigned[i]; j < len(b) && pairs[i][j].original == nil {
+			added = append(added, unaligned(b[j], child(pb, j), false))
+		}
+	}
+	sort.Slice(added, func(i, j int) bool {
+		return added[i].modifiedPath[len(added[i].modifiedPath)-1] < added[j].modifiedPath[len(added[j].modifiedPath)-1]
+	})
+	return append(children, added...)
+}
+
+// unaligned is a clause, and all of its children, that is only in the original query (when removed is true) or only in
+// the modified query.
+func unaligned(q cqr.CommonQueryRepresentation, p []int, removed bool) *alignment {
+	n := &alignment{cost: 1}
+	if removed {
+		n.original, n.originalPath = q, p
+	} else {
+		n.modified, n.modifiedPath = q, p
+	}
+	if bq, ok := q.(cqr.BooleanQuery); ok {
+		for i, c := range bq.Children {
+			child := unaligned(c, child(p, i), removed)
+			n.children = append(n.children, child)
+			n.cost += child.cost
+		}
+	}
+	return n
+}
+
+// changes lists the changes of an alignment. An added or removed clause is a single change, rather than a change for
+// each of its children.
+func changes(a *alignment, c []Change) []Change {
+	switch {
+	case a.original == nil && a.modified == nil:
+		// A replaced clause.
+		for _, child := range a.children {
+			c = changes(child, c)
+		}
+		return c
+	case a.original == nil:
+		return append(c, Change{Operation: Added, ModifiedPath: a.modifiedPath, Modified: pubmed(a.modified)})
+	case a.modified == nil:
+		return append(c, Change{Operation: Removed, OriginalPath: a.originalPath, Original: pubmed(a.original)})
+	}
+
+	change := Change{OriginalPath: a.originalPath, ModifiedPath: a.modifiedPath}
+	switch x := a.original.(type) {
+	case cqr.Keyword:
+		y := a.modified.(cqr.Keyword)
+		change.Original, change.Modified = pubmed(x), pubmed(y)
+		if x.QueryString != y.QueryString || !sameOptions(x.Options, y.Options) {
+			change.Operation = Modified
+			c = append(c, change)
+		}
+		if !sameFields(x, y) {
+			change.Operation = FieldsChanged
+			c = append(c, change)
+		}
+	case cqr.BooleanQuery:
+		y := a.modified.(cqr.BooleanQuery)
+		if !strings.EqualFold(x.Operator, y.Operator) || !sameOptions(x.Options, y.Options) {
+			change.Operation = OperatorChanged
+			change.Original, change.Modified = label(x), label(y)
+			c = append(c, change)
+		}
+		for _, child := range a.children {
+			c = changes(child, c)
+		}
+	}
+	return c
+}
+
+// size is the number of clauses in a query.
+func size(q cqr.CommonQueryRepresentation) float64 {
+	s := 1.0
+	if bq, ok := q.(cqr.BooleanQuery); ok {
+		for _, c := range bq.Children {
+			s += size(c)
+		}
+	}
+	return s
+}
+
+func sameFields(a, b cqr.Keyword) bool {
+	if len(a.Fields) != len(b.Fields) {
+		return false
+	}
+	x, y := append([]string{}, a.Fields...), append([]string{}, b.Fields...)
+	sort.Strings(x)
+	sort.Strings(y)
+	for i := range x {
+		if x[i] != y[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func sameOptions(a, b map[string]interface{}) bool {
+	for k, v := range a {
+		if fmt.Sprint(v) != fmt.Sprint(b[k]) {
+			return false
+		}
+	}
+	for k, v := range b {
+		if fmt.Sprint(v) != fmt.Sprint(a[k]) {
+			return false
+		}
+	}
+	return true
+}
+
+// label is a single line description of a clause; the operator of a Boolean clause, or a keyword in PubMed syntax.
+func label(q cqr.CommonQueryRepresentation) string {
+	if bq, ok := q.(cqr.BooleanQuery); ok {
+		l := strings.ToUpper(bq.Operator)
+		if len(bq.Options) > 0 {
+			l += fmt.Sprint(bq.Options)
+		}
+		return l
+	}
+	return pubmed(q)
+}
+
+// pubmed writes a clause in PubMed syntax, or in CQR if it cannot be.
+func pubmed(q cqr.CommonQueryRepresentation) string {
+	s, err := transmute.CompileCqr2PubMed(q)
+	if err != nil {
+		return q.String()
+	}
+	return s
+}
+
+func child(p []int, i int) []int {
+	return append(append([]int{}, p...), i)
+}
+
+func join(path []int) string {
+	p := make([]string, len(path))
+	for i, v := range path {
+		p[i] = fmt.Sprintf("%d", v)
+	}
+	return strings.Join(p, "/")
+}
+
+// assign is the minimum cost assignment of the rows of a square cost matrix to its columns (i.e. the Hungarian
+// algorithm). The column assigned to each row is returned.
+func assign(cost [][]float64) []int {
+	n := len(cost)
+	u, v := make([]float64, n+1), make([]float64, n+1)
+	p, way := make([]int, n+1), make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		used := make([]bool, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		for {
+			used[j0] = true
+			i0, delta, j1 := p[j0], math.Inf(1), 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				if c := cost[i0-1][j-1] - u[i0] - v[j]; c < minv[j] {
+					minv[j], way[j] = c, j0
+				}
+				if minv[j] < delta {
+					delta, j1 = minv[j], j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+	assigned := make([]int, n)
+	for j := 1; j <= n; j++ {
+		if p[j] > 0 {
+			assigned[p[j]-1] = j - 1
+		}
+	}
+	return assigned
+}
@@ -0,0 +1,90 @@
+package diff_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis/diff"
+	"github.com/hscells/groove/pipeline"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCompare(t *testing.T) {
+	original := cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+		cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("heart attack", "title"),
+			cqr.NewKeyword("myocardial infarction", "title"),
+		}),
+		cqr.NewKeyword("aspirin", "title"),
+		cqr.NewKeyword("placebo", "title"),
+	})
+
+	// Reordering clauses does not change the query.
+	reordered := cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+		cqr.NewKeyword("placebo", "title"),
+		cqr.NewKeyword("aspirin", "title"),
+		cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("myocardial infarction", "title"),
+			cqr.NewKeyword("heart attack", "title"),
+		}),
+	})
+	if d := diff.Compare(original, reordered); d.Distance != 0 || len(d.Changes) != 0 {
+		t.Errorf("expected reordered queries to be the same, got %v", d.Changes)
+	}
+
+	modified := cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+		cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("heart attack", "title", "abstract"),
+			cqr.NewKeyword("myocardial infarction", "title"),
+			cqr.NewKeyword("cardiac arrest", "title"),
+		}),
+		cqr.NewKeyword("aspirin*", "title"),
+	})
+	d := diff.Compare(original, modified)
+	if d.Distance != 5 {
+		t.Errorf("expected a distance of 5, got %f", d.Distance)
+	}
+
+	type change struct {
+		operation diff.Operation
+		original  []int
+		modified  []int
+	}
+	expected := []change{
+		{diff.OperatorChanged, []int{}, []int{}},
+		{diff.FieldsChanged, []int{0, 0}, []int{0, 0}},
+		{diff.Added, nil, []int{0, 2}},
+		{diff.Modified, []int{1}, []int{1}},
+		{diff.Removed, []int{2}, nil},
+	}
+	actual := make([]change, len(d.Changes))
+	for i, c := range d.Changes {
+		actual[i] = change{c.Operation, c.OriginalPath, c.ModifiedPath}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected changes %v, got %v", expected, actual)
+	}
+}
+
+func TestDistanceFromOriginal(t *testing.T) {
+	a, b := cqr.NewKeyword("a", "title"), cqr.NewKeyword("b", "title")
+	m := diff.NewDistanceFromOriginal(pipeline.NewQuery("1", "1", a))
+
+	// The name identifies the original queries, so measurements are not shared between different originals.
+	if m.Name() == diff.NewDistanceFromOriginal(pipeline.NewQuery("1", "1", b)).Name() {
+		t.Errorf("expected different original queries to have different names, got %s", m.Name())
+	}
+	if m.Name() != diff.NewDistanceFromOriginal(pipeline.NewQuery("1", "1", a)).Name() {
+		t.Errorf("expected the same original queries to have the same name, got %s", m.Name())
+	}
+	if !strings.HasPrefix(m.Name(), diff.DistanceFromOriginalName+"@") {
+		t.Errorf("expected the name to start with %s@, got %s", diff.DistanceFromOriginalName, m.Name())
+	}
+
+	if d, err := m.Execute(pipeline.NewQuery("1", "1", a), nil); err != nil || d != 0 {
+		t.Errorf("expected a distance of 0, got %f (%v)", d, err)
+	}
+	if _, err := m.Execute(pipeline.NewQuery("2", "2", a), nil); err == nil {
+		t.Error("expected an error for a topic without an original query")
+	}
+}
@@ -0,0 +1,60 @@
+package diff
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"sort"
+)
+
+// DistanceFromOriginalName is the name of the measurement created by NewDistanceFromOriginal, without the hash of its
+// original queries.
+const DistanceFromOriginalName = "DistanceFromOriginal"
+
+type distanceFromOriginal struct {
+	name      string
+	originals map[string]cqr.CommonQueryRepresentation
+}
+
+// NewDistanceFromOriginal creates a measurement of the tree edit distance between a query and the original query of
+// its topic, e.g. to measure how far a query chain has rewritten a query. The name of the measurement includes a hash
+// of the original queries (e.g. DistanceFromOriginal@1a2b3c4d), so measurements computed for different sets of
+// original queries are cached separately.
+func NewDistanceFromOriginal(originals ...pipeline.Query) analysis.Measurement {
+	d := distanceFromOriginal{originals: make(map[string]cqr.CommonQueryRepresentation)}
+	for _, q := range originals {
+		d.originals[q.Topic] = q.Query
+	}
+
+	topics := make([]string, 0, len(d.originals))
+	for topic := range d.originals {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	h := sha256.New()
+	for _, topic := range topics {
+		h.Write([]byte(topic))
+		h.Write([]byte{0})
+		if d.originals[topic] != nil {
+			h.Write([]byte(d.originals[topic].String()))
+		}
+		h.Write([]byte{0})
+	}
+	d.name = fmt.Sprintf("%s@%x", DistanceFromOriginalName, h.Sum(nil)[:4])
+	return d
+}
+
+func (d distanceFromOriginal) Name() string {
+	return d.name
+}
+
+func (d distanceFromOriginal) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	original, ok := d.originals[q.Topic]
+	if !ok {
+		return 0, fmt.Errorf("no original query for topic %s", q.Topic)
+	}
+	return Distance(original, q.Query), nil
+}
@@ -0,0 +1,368 @@
+// Package lint inspects Boolean queries for problems that are easy to introduce when writing or translating a search
+// strategy, e.g. fields the search engine does not support, or quotes left over from parsing.
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/transmute/fields"
+	"sort"
+	"strings"
+)
+
+// Severity is how much of a problem a diagnostic is.
+type Severity int
+
+const (
+	// Info is a diagnostic that does not change the documents the query retrieves, e.g. a clause that can be simplified.
+	Info Severity = iota
+	// Warning is a diagnostic that likely changes the documents the query retrieves in a way that was not intended.
+	Warning
+	// Error is a diagnostic that prevents the query from being executed as written.
+	Error
+)
+
+func (s Severity) String() string {
+	switch s {
+	case Info:
+		return "info"
+	case Warning:
+		return "warning"
+	case Error:
+		return "error"
+	}
+	return fmt.Sprintf("severity(%d)", int(s))
+}
+
+// MarshalJSON encodes the severity as its name.
+func (s Severity) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// Names of the checks made by the linter.
+const (
+	UnsupportedField = "unsupported-field"
+	ShortTruncation  = "short-truncation"
+	UnknownMeSH      = "unknown-mesh"
+	BroadExclusion   = "broad-exclusion"
+	DuplicateKeyword = "duplicate-keyword"
+	SingleChild      = "single-child"
+	NestedOperator   = "nested-operator"
+	MismatchedQuotes = "mismatched-quotes"
+)
+
+// Diagnostic is a problem with a clause of a query. The path is the index of each child descended into from the root
+// of the query to reach the clause.
+type Diagnostic struct {
+	Check    string   `json:"check"`
+	Severity Severity `json:"severity"`
+	Path     []int    `json:"path"`
+	Clause   string   `json:"clause"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s [%s] /%s: %s (%s)", d.Severity, d.Check, join(d.Path), d.Message, d.Clause)
+}
+
+// PubMedFields are the fields that can be searched in PubMed.
+var PubMedFields = []string{
+	fields.Title,
+	fields.Abstract,
+	fields.TitleAbstract,
+	fields.MeshHeadings,
+	fields.MeSHTerms,
+	fields.MeSHSubheading,
+	fields.MeSHMajorTopic,
+	fields.MajorFocusMeshHeading,
+	fields.FloatingMeshHeadings,
+	fields.PublicationType,
+	fields.PublicationDate,
+}
+
+// meshFields are the fields that contain MeSH headings.
+var meshFields = map[string]bool{
+	fields.MeshHeadings:          true,
+	fields.MeSHTerms:             true,
+	fields.MeSHMajorTopic:        true,
+	fields.MajorFocusMeshHeading: true,
+}
+
+// Linter checks queries for problems.
+type Linter struct {
+	fields       map[string]bool
+	minStem      int
+	mesh         *analysis.MeSH
+	ss           stats.StatisticsSource
+	maxExclusion float64
+	ignore       map[string]bool
+}
+
+// LintFields are the fields the search engine supports. By default, any field is supported.
+func LintFields(f ...string) func(l *Linter) {
+	return func(l *Linter) {
+		l.fields = make(map[string]bool)
+		for _, field := range f {
+			l.fields[field] = true
+		}
+	}
+}
+
+// LintMinStem is the shortest stem that may be truncated (default 4).
+func LintMinStem(n int) func(l *Linter) {
+	return func(l *Linter) {
+		l.minStem = n
+	}
+}
+
+// LintMeSH is the MeSH tree exploded headings are looked up in. By default, the default MeSH tree is used.
+func LintMeSH(mesh *analysis.MeSH) func(l *Linter) {
+	return func(l *Linter) {
+		l.mesh = mesh
+	}
+}
+
+// LintStatistics is the statistics source used to find `not` clauses that exclude very broad sets of documents. Without
+// a statistics source, the breadth of exclusions is not checked.
+func LintStatistics(ss stats.StatisticsSource) func(l *Linter) {
+	return func(l *Linter) {
+		l.ss = ss
+	}
+}
+
+// LintMaxExclusion is the largest proportion of the collection a `not` clause may exclude (default 0.05).
+func LintMaxExclusion(p float64) func(l *Linter) {
+	return func(l *Linter) {
+		l.maxExclusion = p
+	}
+}
+
+// LintIgnore disables checks by name.
+func LintIgnore(checks ...string) func(l *Linter) {
+	return func(l *Linter) {
+		for _, check := range checks {
+			l.ignore[check] = true
+		}
+	}
+}
+
+// New creates a new linter.
+func New(options ...func(l *Linter)) Linter {
+	l := Linter{
+		minStem:      4,
+		maxExclusion: 0.05,
+		ignore:       make(map[string]bool),
+	}
+	for _, option := range options {
+		option(&l)
+	}
+	return l
+}
+
+// linting is the state of linting a single query.
+type linting struct {
+	Linter
+	mesh        *analysis.MeSH
+	n           float64
+	seen        map[string][]int
+	diagnostics []Diagnostic
+}
+
+// Lint checks a query, and returns the problems found ordered by their position in the query.
+func (l Linter) Lint(q cqr.CommonQueryRepresentation) ([]Diagnostic, error) {
+	s := &linting{Linter: l, seen: make(map[string][]int)}
+	if !l.ignore[UnknownMeSH] {
+		var err error
+		s.mesh, err = analysis.MeSHOrDefault(l.mesh)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if l.ss != nil && !l.ignore[BroadExclusion] {
+		var err error
+		s.n, err = l.ss.CollectionSize()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := s.lint(q, nil); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(s.diagnostics, func(i, j int) bool {
+		return less(s.diagnostics[i].Path, s.diagnostics[j].Path)
+	})
+	return s.diagnostics, nil
+}
+
+func (s *linting) report(check string, severity Severity, path []int, clause cqr.CommonQueryRepresentation, format string, a ...interface{}) {
+	if s.ignore[check] {
+		return
+	}
+	s.diagnostics = append(s.diagnostics, Diagnostic{
+		Check:    check,
+		Severity: severity,
+		Path:     append([]int{}, path...),
+		Clause:   clause.String(),
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+func (s *linting) lint(q cqr.CommonQueryRepresentation, path []int) error {
+	switch c := q.(type) {
+	case cqr.Keyword:
+		s.keyword(c, path)
+	case cqr.BooleanQuery:
+		if err := s.boolean(c, path); err != nil {
+			return err
+		}
+		for i, child := range c.Children {
+			if err := s.lint(child, append(path, i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *linting) keyword(kw cqr.Keyword, path []int) {
+	for _, field := range kw.Fields {
+		if s.fields != nil && !s.fields[field] {
+			s.report(UnsupportedField, Error, path, kw, "the field %s is not supported", field)
+		}
+	}
+
+	if strings.Count(kw.QueryString, `"`)%2 != 0 {
+		s.report(MismatchedQuotes, Error, path, kw, "the keyword has mismatched quotes")
+	}
+
+	for _, stem := range stems(kw) {
+		if len([]rune(stem)) < s.minStem {
+			s.report(ShortTruncation, Warning, path, kw, "the truncated stem %s is shorter than %d characters", stem, s.minStem)
+		}
+	}
+
+	if s.mesh != nil && exploded(kw) {
+		heading := strings.ToLower(strings.Trim(strings.Replace(kw.QueryString, "*", "", -1), `"`))
+		// Subheadings (e.g. `neoplasms/therapy`) are not part of the tree.
+		if i := strings.Index(heading, "/"); i >= 0 {
+			heading = heading[:i]
+		}
+		if !s.mesh.Contains(heading) {
+			s.report(UnknownMeSH, Error, path, kw, "the exploded heading %s is not in the %s MeSH tree", heading, s.mesh.Version)
+		}
+	}
+
+	key := keywordKey(kw)
+	if first, ok := s.seen[key]; ok {
+		severity := Info
+		if siblings(first, path) {
+			// Repeating a keyword within the same clause is likely a mistake rather than a keyword reused in another
+			// concept.
+			severity = Warning
+		}
+		s.report(DuplicateKeyword, severity, path, kw, "the keyword is a duplicate of /%s", join(first))
+	} else {
+		s.seen[key] = append([]int{}, path...)
+	}
+}
+
+func (s *linting) boolean(bq cqr.BooleanQuery, path []int) error {
+	op := strings.ToLower(bq.Operator)
+	if len(bq.Children) == 1 && op != cqr.NOT {
+		s.report(SingleChild, Warning, path, bq, "the %s clause has a single child", op)
+	}
+
+	if op == cqr.AND || op == cqr.OR {
+		for i, child := range bq.Children {
+			if c, ok := child.(cqr.BooleanQuery); ok && strings.ToLower(c.Operator) == op {
+				s.report(NestedOperator, Info, append(path, i), c, "the %s clause can be merged into its parent", op)
+			}
+		}
+	}
+
+	if op == cqr.NOT && s.n > 0 {
+		// The first child of a `not` clause is what the other children are excluded from.
+		for i := 1; i < len(bq.Children); i++ {
+			size, err := s.ss.RetrievalSize(bq.Children[i])
+			if err != nil {
+				return err
+			}
+			if p := size / s.n; p > s.maxExclusion {
+				s.report(BroadExclusion, Warning, append(path, i), bq.Children[i], "the excluded clause retrieves %.1f%% of the collection", p*100)
+			}
+		}
+	}
+	return nil
+}
+
+// stems are the truncated words of a keyword. Words are truncated by a wildcard left in the keyword, or the last word
+// is truncated by the truncation option.
+func stems(kw cqr.Keyword) []string {
+	words := strings.Fields(kw.QueryString)
+	var s []string
+	for _, word := range words {
+		if strings.ContainsAny(word, "*$") {
+			s = append(s, strings.Trim(word, `"*$`))
+		}
+	}
+	if t, ok := kw.Options[cqr.TruncatedString].(bool); ok && t && len(s) == 0 && len(words) > 0 {
+		s = append(s, strings.Trim(words[len(words)-1], `"`))
+	}
+	return s
+}
+
+// exploded is whether a keyword is an exploded MeSH heading.
+func exploded(kw cqr.Keyword) bool {
+	if e, ok := kw.Options[cqr.ExplodedString].(bool); !ok || !e {
+		return false
+	}
+	for _, field := range kw.Fields {
+		if meshFields[field] {
+			return true
+		}
+	}
+	return false
+}
+
+// keywordKey identifies keywords that retrieve the same documents.
+func keywordKey(kw cqr.Keyword) string {
+	f := append([]string{}, kw.Fields...)
+	sort.Strings(f)
+	e, _ := kw.Options[cqr.ExplodedString].(bool)
+	t, _ := kw.Options[cqr.TruncatedString].(bool)
+	return fmt.Sprintf("%s|%s|%t|%t", strings.ToLower(strings.TrimSpace(kw.QueryString)), strings.Join(f, ","), e, t)
+}
+
+// less orders paths by their position in the query.
+func less(a, b []int) bool {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return len(a) < len(b)
+}
+
+// siblings is whether two paths are children of the same clause.
+func siblings(a, b []int) bool {
+	if len(a) != len(b) || len(a) == 0 {
+		return false
+	}
+	for i := 0; i < len(a)-1; i++ {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func join(path []int) string {
+	p := make([]string, len(path))
+	for i, v := range path {
+		p[i] = fmt.Sprintf("%d", v)
+	}
+	return strings.Join(p, "/")
+}
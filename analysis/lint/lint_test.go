@@ -0,0 +1,55 @@
+package lint_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis/lint"
+	"github.com/hscells/transmute/fields"
+	"reflect"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	q := cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+		cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("ca*", fields.TitleAbstract),
+			cqr.NewKeyword(`"heart attack`, fields.TitleAbstract),
+			cqr.NewKeyword("ca*", fields.TitleAbstract),
+		}),
+		cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("myocardial infarction", "unknown"),
+		}),
+		cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("ca*", fields.TitleAbstract),
+			cqr.NewKeyword("cardiac", fields.Title),
+		}),
+	})
+
+	diagnostics, err := lint.New(lint.LintFields(lint.PubMedFields...), lint.LintIgnore(lint.UnknownMeSH)).Lint(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type problem struct {
+		check    string
+		severity lint.Severity
+		path     []int
+	}
+	expected := []problem{
+		{lint.ShortTruncation, lint.Warning, []int{0, 0}},
+		{lint.MismatchedQuotes, lint.Error, []int{0, 1}},
+		{lint.ShortTruncation, lint.Warning, []int{0, 2}},
+		{lint.DuplicateKeyword, lint.Warning, []int{0, 2}},
+		{lint.SingleChild, lint.Warning, []int{1}},
+		{lint.UnsupportedField, lint.Error, []int{1, 0}},
+		{lint.NestedOperator, lint.Info, []int{2}},
+		{lint.ShortTruncation, lint.Warning, []int{2, 0}},
+		{lint.DuplicateKeyword, lint.Info, []int{2, 0}},
+	}
+	actual := make([]problem, len(diagnostics))
+	for i, d := range diagnostics {
+		actual[i] = problem{d.Check, d.Severity, d.Path}
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected diagnostics %v, got %v", expected, diagnostics)
+	}
+}
@@ -54,6 +54,13 @@ type MeasurementExecutor struct {
 	cache MeasurementCacher
 }
 
+// NewMeasurementExecutor creates a measurement executor that caches to any measurement cache.
+func NewMeasurementExecutor(cache MeasurementCacher) MeasurementExecutor {
+	return MeasurementExecutor{
+		cache: cache,
+	}
+}
+
 // NewDiskMeasurementExecutor creates a measurement executor that caches to disk.
 func NewDiskMeasurementExecutor(d *diskv.Diskv) MeasurementExecutor {
 	return MeasurementExecutor{
@@ -68,42 +75,68 @@ func NewMemoryMeasurementExecutor() MeasurementExecutor {
 	}
 }
 
-// hash hashes a query and measurement pair ready to be cached.
-func hash(representation cqr.CommonQueryRepresentation, measurement Measurement) string {
+// hash hashes a query and measurement pair in the namespace of a statistics source ready to be cached.
+func hash(representation cqr.CommonQueryRepresentation, measurement Measurement, namespace string) string {
 	if representation == nil {
 		return "0"
 	}
-	return fmt.Sprintf("%x", sha256.Sum256([]byte(representation.String()+measurement.Name())))
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(namespace+"\x00"+representation.String()+"\x00"+measurement.Name())))
 	//h := fnv.New32()
 	//h.Write([]byte(representation.String() + measurement.Name()))
 	//return strconv.Itoa(int(h.Sum32()))
 }
 
-// Execute executes the specified measurements on the query using the statistics source.
+// legacyHash is the key a query and measurement pair was cached under before keys were namespaced.
+func legacyHash(representation cqr.CommonQueryRepresentation, measurement Measurement) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(representation.String()+measurement.Name())))
+}
+
+// Execute executes the specified measurements on the query using the statistics source. The values of vector
+// measurements are flattened, so the results are in the order of MeasurementNames. Cached measurements record the
+// query they were computed for, and are recomputed if it does not match (i.e. the hashes of two queries collide).
+// Measurements cached before keys were namespaced by the statistics source are assumed to have been computed using
+// the statistics source, and are copied to their namespaced key when they are read.
 func (m MeasurementExecutor) Execute(query pipeline.Query, ss stats.StatisticsSource, measurements ...Measurement) ([]float64, error) {
-	results := make([]float64, len(measurements))
-	for i, measurement := range measurements {
-		qHash := hash(query.Query, measurement)
-		if v, err := m.cache.Read(qHash); err == nil && len(v) > 0 {
-			bits := binary.BigEndian.Uint64(v)
-			f := math.Float64frombits(bits)
-			results[i] = f
+	results := make([]float64, 0, len(measurements))
+	namespace := stats.Namespace(ss)
+	var canonical string
+	if query.Query != nil {
+		canonical = query.Query.String()
+	}
+	for _, measurement := range measurements {
+		n := len(MeasurementNames(measurement))
+		qHash := hash(query.Query, measurement, namespace)
+		if v, err := m.cache.Read(qHash); err == nil && len(v) >= 8*n && string(v[8*n:]) == canonical {
+			for i := 0; i < n; i++ {
+				bits := binary.BigEndian.Uint64(v[8*i:])
+				results = append(results, math.Float64frombits(bits))
+			}
 			continue
-		} else if reflect.TypeOf(err) != reflect.TypeOf(&os.PathError{}) {
+		} else if err != nil && err != combinator.ErrCacheMiss && reflect.TypeOf(err) != reflect.TypeOf(&os.PathError{}) {
 			return nil, err
 		}
 
-		var v float64
-		v, err := measurement.Execute(query, ss)
+		// Entries cached before keys were namespaced only contain a single value.
+		if n == 1 && query.Query != nil {
+			if v, err := m.cache.Read(legacyHash(query.Query, measurement)); err == nil && len(v) == 8 {
+				results = append(results, math.Float64frombits(binary.BigEndian.Uint64(v)))
+				m.cache.Write(qHash, append(append(make([]byte, 0, 8+len(canonical)), v...), canonical...))
+				continue
+			}
+		}
+
+		v, err := ExecuteMeasurement(measurement, query, ss)
 		if err != nil {
 			log.Println(measurement.Name())
 			panic(err)
 			return nil, err
 		}
-		results[i] = v
-		buff := make([]byte, 8)
-		binary.BigEndian.PutUint64(buff[:], math.Float64bits(v))
-		m.cache.Write(qHash, buff)
+		results = append(results, v...)
+		buff := make([]byte, 8*n, 8*n+len(canonical))
+		for i, f := range v {
+			binary.BigEndian.PutUint64(buff[8*i:], math.Float64bits(f))
+		}
+		m.cache.Write(qHash, append(buff, canonical...))
 	}
 	return results, nil
 }
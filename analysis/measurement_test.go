@@ -0,0 +1,55 @@
+package analysis_test
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"math"
+	"testing"
+)
+
+type countingMeasurement struct {
+	calls *int
+}
+
+func (countingMeasurement) Name() string {
+	return "Counting"
+}
+
+func (c countingMeasurement) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	*c.calls++
+	return 1, nil
+}
+
+func TestLegacyMeasurementCache(t *testing.T) {
+	calls := 0
+	m := countingMeasurement{calls: &calls}
+	q := pipeline.NewQuery("1", "1", cqr.NewKeyword("a", "title"))
+
+	// Measurements cached before keys were namespaced are keyed by the query and the name of the measurement.
+	cache := make(analysis.MemoryMeasurementCache)
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, math.Float64bits(2))
+	cache[fmt.Sprintf("%x", sha256.Sum256([]byte(q.Query.String()+m.Name())))] = v
+
+	me := analysis.NewMeasurementExecutor(cache)
+	for i := 0; i < 2; i++ {
+		values, err := me.Execute(q, nil, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(values) != 1 || values[0] != 2 {
+			t.Errorf("expected the legacy cached value, got %v", values)
+		}
+	}
+	if calls != 0 {
+		t.Errorf("expected the measurement to be read from the cache, got %d computations", calls)
+	}
+	if len(cache) != 2 {
+		t.Errorf("expected the measurement to be copied to its namespaced key, got %d entries", len(cache))
+	}
+}
@@ -3,21 +3,30 @@ package analysis
 import (
 	"github.com/hscells/groove/pipeline"
 	"github.com/hscells/groove/stats"
-	"github.com/hscells/meshexp"
 	"github.com/hscells/transmute/fields"
 	"strings"
 )
 
-var MeSHTree, _ = meshexp.Default()
-
 var (
 	MeshKeywordCount     = meshKeywordCount{}
 	MeshExplodedCount    = meshExplodedCount{}
 	MeshNonExplodedCount = meshNonExplodedCount{}
-	MeshAvgDepth         = meshAvgDepth{}
-	MeshMaxDepth         = meshMaxDepth{}
+	// MeshAvgDepth and MeshMaxDepth use the default MeSH tree; use NewMeshAvgDepth and NewMeshMaxDepth to measure
+	// depth with a specific version of MeSH.
+	MeshAvgDepth = meshAvgDepth{}
+	MeshMaxDepth = meshMaxDepth{}
 )
 
+// NewMeshAvgDepth measures the average depth of the MeSH keywords of a query in a MeSH tree.
+func NewMeshAvgDepth(mesh *MeSH) Measurement {
+	return meshAvgDepth{mesh: mesh}
+}
+
+// NewMeshMaxDepth measures the maximum depth of the MeSH keywords of a query in a MeSH tree.
+func NewMeshMaxDepth(mesh *MeSH) Measurement {
+	return meshMaxDepth{mesh: mesh}
+}
+
 func normalise(q string) string {
 	q = strings.Replace(q, "*", "", -1)
 	q = strings.Replace(q, `"`, "", -1)
@@ -54,38 +63,50 @@ func (meshNonExplodedCount) Execute(q pipeline.Query, s stats.StatisticsSource)
 	return float64(len(MeshNonExplodedKeywords(q.Query))), nil
 }
 
-type meshAvgDepth struct{}
+type meshAvgDepth struct {
+	mesh *MeSH
+}
 
 func (meshAvgDepth) Name() string {
 	return "MeshAvgDepth"
 }
 
-func (meshAvgDepth) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+func (m meshAvgDepth) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
 	keywords := KeywordsWithField(q.Query, fields.MeshHeadings)
 	if len(keywords) == 0 {
 		return 0, nil
 	}
+	mesh, err := MeSHOrDefault(m.mesh)
+	if err != nil {
+		return 0, err
+	}
 	var sum int64
 	for _, kw := range keywords {
-		sum += MeSHTree.Depth(normalise(kw.QueryString))
+		sum += mesh.Depth(normalise(kw.QueryString))
 	}
 	return float64(sum) / float64(len(keywords)), nil
 }
 
-type meshMaxDepth struct{}
+type meshMaxDepth struct {
+	mesh *MeSH
+}
 
 func (meshMaxDepth) Name() string {
 	return "MeshMaxDepth"
 }
 
-func (meshMaxDepth) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+func (m meshMaxDepth) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
 	keywords := KeywordsWithField(q.Query, fields.MeshHeadings)
 	if len(keywords) == 0 {
 		return 0, nil
 	}
+	mesh, err := MeSHOrDefault(m.mesh)
+	if err != nil {
+		return 0, err
+	}
 	var max int64
 	for _, kw := range keywords {
-		d := MeSHTree.Depth(normalise(kw.QueryString))
+		d := mesh.Depth(normalise(kw.QueryString))
 		if d > max {
 			max = d
 		}
@@ -0,0 +1,190 @@
+package analysis
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"github.com/hscells/meshexp"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MeSH is a version of the MeSH tree, which is used by the MeSH measurements, the MeSH transformations of query chains
+// and query formulation. Experiments should use the version of MeSH that matches the collection (e.g. the 2015 MeSH
+// tree for a 2015 snapshot of PubMed). Version describes the file or year the tree was loaded from.
+type MeSH struct {
+	*meshexp.MeSHTree
+	Version string
+}
+
+// meSHTreesURL is the location of the MeSH trees file for a year on the NLM FTP server.
+const meSHTreesURL = "https://nlmpubs.nlm.nih.gov/projects/mesh/%d/meshtrees/mtrees%d.bin"
+
+// meSHClient downloads MeSH trees files, so that an unresponsive server cannot stall loading a MeSH tree forever.
+var meSHClient = &http.Client{Timeout: 5 * time.Minute}
+
+var (
+	defaultMeSH     *MeSH
+	defaultMeSHErr  error
+	defaultMeSHOnce sync.Once
+)
+
+// DefaultMeSH loads the MeSH tree that is distributed with meshexp. It is only loaded once, and shared by every
+// component that is not configured with a MeSH tree of its own.
+func DefaultMeSH() (*MeSH, error) {
+	defaultMeSHOnce.Do(func() {
+		tree, err := meshexp.Default()
+		if err != nil {
+			defaultMeSHErr = fmt.Errorf("could not load the default MeSH tree: %v", err)
+			return
+		}
+		defaultMeSH = &MeSH{MeSHTree: tree, Version: "default"}
+	})
+	return defaultMeSH, defaultMeSHErr
+}
+
+// MeSHOrDefault returns the MeSH tree if it is not nil, and otherwise the default MeSH tree.
+func MeSHOrDefault(mesh *MeSH) (*MeSH, error) {
+	if mesh != nil {
+		return mesh, nil
+	}
+	return DefaultMeSH()
+}
+
+// MeSHCategory is the kind of concept a MeSH heading describes, e.g. the condition, treatment, or study type of a
+// systematic review.
+type MeSHCategory int
+
+const (
+	// ConditionMeSH are headings that describe diseases, organisms, anatomy, and other aspects of a population.
+	ConditionMeSH MeSHCategory = iota + 1
+	// TreatmentMeSH are headings that describe chemicals, drugs, techniques, and equipment.
+	TreatmentMeSH
+	// StudyTypeMeSH are headings that describe publication characteristics, and other aspects of a study design.
+	StudyTypeMeSH
+)
+
+// Categories classifies a heading by the top-level categories of the trees it is in. A heading can be in several
+// categories, or in none (e.g. headings of the humanities or technology trees).
+func (m *MeSH) Categories(heading string) []MeSHCategory {
+	seen := make(map[MeSHCategory]bool)
+	var categories []MeSHCategory
+	for _, ref := range m.Reference(heading) {
+		if len(ref.TreeLocation) == 0 || len(ref.TreeLocation[0]) == 0 {
+			continue
+		}
+		var c MeSHCategory
+		switch ref.TreeLocation[0][0] {
+		case 'A', 'B', 'C', 'F', 'G', 'H', 'M':
+			c = ConditionMeSH
+		case 'D', 'E':
+			c = TreatmentMeSH
+		case 'L', 'V', 'Z':
+			c = StudyTypeMeSH
+		default:
+			continue
+		}
+		if !seen[c] {
+			seen[c] = true
+			categories = append(categories, c)
+		}
+	}
+	return categories
+}
+
+// NewMeSH reads a MeSH tree in the MeSH trees (mtrees) format, i.e. a heading and a tree number on each line separated
+// by a semicolon. Lines in any other format are an error.
+func NewMeSH(r io.Reader, version string) (*MeSH, error) {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read MeSH tree %s: %v", version, err)
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 {
+			continue
+		}
+		parts := strings.Split(line, ";")
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("could not read MeSH tree %s: line %d is not a heading and a tree number", version, n)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read MeSH tree %s: %v", version, err)
+	}
+
+	tree, err := meshexp.New(bytes.NewReader(b))
+	if err != nil {
+		return nil, fmt.Errorf("could not read MeSH tree %s: %v", version, err)
+	}
+	return &MeSH{MeSHTree: tree, Version: version}, nil
+}
+
+// LoadMeSH loads a MeSH tree from a local MeSH trees (mtrees) file.
+func LoadMeSH(file string) (*MeSH, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewMeSH(f, file)
+}
+
+// LoadMeSHYear loads the MeSH tree of a year. The MeSH trees file for the year is downloaded from the NLM into a
+// directory the first time it is loaded, and read from the directory after that.
+func LoadMeSHYear(year int, dir string) (*MeSH, error) {
+	file := path.Join(dir, fmt.Sprintf("mtrees%d.bin", year))
+	if _, err := os.Stat(file); os.IsNotExist(err) {
+		if err := downloadMeSH(fmt.Sprintf(meSHTreesURL, year, year), file); err != nil {
+			return nil, fmt.Errorf("could not download the %d MeSH tree: %v", year, err)
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	mesh, err := LoadMeSH(file)
+	if err != nil {
+		return nil, err
+	}
+	mesh.Version = strconv.Itoa(year)
+	return mesh, nil
+}
+
+// downloadMeSH downloads a MeSH trees file. The file is written to a temporary file first, so that a failed download
+// is never mistaken for a complete one.
+func downloadMeSH(url, file string) error {
+	resp, err := meSHClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+
+	if err := os.MkdirAll(path.Dir(file), 0755); err != nil {
+		return err
+	}
+	tmp := file + ".download"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, resp.Body); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, file)
+}
@@ -0,0 +1,85 @@
+package analysis_test
+
+import (
+	"github.com/hscells/groove/analysis"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const mtrees = `Neoplasms;C04
+Antineoplastic Agents;D27.505.954.248
+Randomized Controlled Trial;V03.175.250.500.500
+Clinical Trials as Topic;E05.318.760.500
+Clinical Trials as Topic;N05.715.360.775.175.250
+Humanities;K01
+`
+
+func TestMeSHCategories(t *testing.T) {
+	mesh, err := analysis.NewMeSH(strings.NewReader(mtrees), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mesh.Version != "test" {
+		t.Errorf("expected the version test, got %s", mesh.Version)
+	}
+
+	for heading, expected := range map[string][]analysis.MeSHCategory{
+		"Neoplasms":                   {analysis.ConditionMeSH},
+		"Antineoplastic Agents":       {analysis.TreatmentMeSH},
+		"Randomized Controlled Trial": {analysis.StudyTypeMeSH},
+		// Only the trees of a heading that are categorised are counted.
+		"Clinical Trials as Topic": {analysis.TreatmentMeSH},
+		"Humanities":               nil,
+		"Unknown Heading":          nil,
+	} {
+		if c := mesh.Categories(heading); !reflect.DeepEqual(c, expected) {
+			t.Errorf("expected %s to be in the categories %v, got %v", heading, expected, c)
+		}
+	}
+}
+
+func TestLoadMeSH(t *testing.T) {
+	for _, malformed := range []string{
+		"Neoplasms\n",
+		"Neoplasms;C04;C05\n",
+		"Neoplasms;\n",
+	} {
+		if _, err := analysis.NewMeSH(strings.NewReader(malformed), "malformed"); err == nil {
+			t.Errorf("expected an error reading %q", malformed)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "groove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if _, err := analysis.LoadMeSH(path.Join(dir, "mtrees.bin")); err == nil {
+		t.Errorf("expected an error loading a MeSH tree that does not exist")
+	}
+
+	file := path.Join(dir, "mtrees2015.bin")
+	if err := ioutil.WriteFile(file, []byte(mtrees), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mesh, err := analysis.LoadMeSH(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mesh.Version != file {
+		t.Errorf("expected the version %s, got %s", file, mesh.Version)
+	}
+
+	// A MeSH tree that has already been downloaded is read from the directory.
+	mesh, err = analysis.LoadMeSHYear(2015, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mesh.Version != "2015" || len(mesh.Categories("Neoplasms")) != 1 {
+		t.Errorf("expected the 2015 MeSH tree to be read from %s", dir)
+	}
+}
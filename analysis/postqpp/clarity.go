@@ -1,24 +1,72 @@
 package postqpp
 
 import (
+	"github.com/hscells/groove/analysis"
 	"github.com/hscells/groove/pipeline"
 	"github.com/hscells/groove/stats"
 	"gonum.org/v1/gonum/stat"
+	"strconv"
 )
 
-type clarityScore struct{}
+type clarityScore struct {
+	// lambda is nil unless it was set explicitly, as zero is a valid amount of smoothing.
+	lambda *float64
+	field  string
+}
 
-// ClarityScore aims to measure how much the query language model diverges from the collection language model.
+// ClarityScore aims to measure how much the query language model diverges from the collection language model. The
+// smoothing parameter is read from the `lambda` parameter of the statistics source (0.6 by default), and the language
+// models are estimated from the `tiab` field. Use NewClarityScore to configure them explicitly.
 var ClarityScore = clarityScore{}
 
-func (clarityScore) Name() string {
-	return "ClarityScore"
+// ClarityLambda sets the Jelinek-Mercer smoothing parameter of the clarity score.
+func ClarityLambda(lambda float64) func(c *clarityScore) {
+	return func(c *clarityScore) {
+		c.lambda = &lambda
+	}
 }
 
-func (clarityScore) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
-	lambda, ok := s.Parameters()["lambda"]
-	if !ok {
-		lambda = 0.6
+// ClarityField sets the field the language models of the clarity score are estimated from.
+func ClarityField(field string) func(c *clarityScore) {
+	return func(c *clarityScore) {
+		c.field = field
+	}
+}
+
+// NewClarityScore creates a clarity score with explicit parameters, which are part of its name.
+func NewClarityScore(options ...func(c *clarityScore)) analysis.Measurement {
+	var c clarityScore
+	for _, option := range options {
+		option(&c)
+	}
+	return c
+}
+
+func (c clarityScore) Name() string {
+	parameters := make(map[string]string)
+	if c.lambda != nil {
+		parameters["lambda"] = strconv.FormatFloat(*c.lambda, 'g', -1, 64)
+	}
+	if len(c.field) > 0 {
+		parameters["field"] = c.field
+	}
+	return analysis.ParameterisedName("ClarityScore", parameters)
+}
+
+func (c clarityScore) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	var lambda float64
+	if c.lambda != nil {
+		lambda = *c.lambda
+	} else {
+		var ok bool
+		lambda, ok = s.Parameters()["lambda"]
+		if !ok {
+			lambda = 0.6
+		}
+	}
+	field := c.field
+	if len(field) == 0 {
+		field = "tiab"
 	}
 
 	results, err := s.Execute(q, s.SearchOptions())
@@ -42,7 +90,7 @@ func (clarityScore) Execute(q pipeline.Query, s stats.StatisticsSource) (float64
 		weights[i] = (score / avgScore) / float64(N)
 	}
 
-	lm, err := stats.NewLanguageModel(s, docIds, scores, "tiab", stats.LanguageModelWeights(weights))
+	lm, err := stats.NewLanguageModel(s, docIds, scores, field, stats.LanguageModelWeights(weights))
 	if err != nil {
 		return 0.0, err
 	}
@@ -0,0 +1,95 @@
+package postqpp
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/xtgo/set"
+)
+
+type queryFeedback struct {
+	field string
+}
+
+// QueryFeedback aims to measure how robust the results of a query are by building a new query from a relevance model
+// of the top k ranked documents, and measuring the overlap of the top "feedback_n" documents retrieved by both
+// queries. The new query is a disjunction of the "feedback_terms" most likely terms of the relevance model, searched
+// in the fields of the original query. The relevance model is estimated from the `tiab` field. Use NewQueryFeedback
+// to configure it explicitly.
+var QueryFeedback = queryFeedback{}
+
+// QueryFeedbackField sets the field the relevance model of query feedback is estimated from.
+func QueryFeedbackField(field string) func(f *queryFeedback) {
+	return func(f *queryFeedback) {
+		f.field = field
+	}
+}
+
+// NewQueryFeedback creates a query feedback predictor with explicit parameters, which are part of its name.
+func NewQueryFeedback(options ...func(f *queryFeedback)) analysis.Measurement {
+	var f queryFeedback
+	for _, option := range options {
+		option(&f)
+	}
+	return f
+}
+
+func (f queryFeedback) Name() string {
+	parameters := make(map[string]string)
+	if len(f.field) > 0 {
+		parameters["field"] = f.field
+	}
+	return analysis.ParameterisedName("QueryFeedback", parameters)
+}
+
+func (f queryFeedback) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	results, err := s.Execute(q, s.SearchOptions())
+	if err != nil {
+		return 0.0, err
+	}
+	if len(results) == 0 {
+		return 0.0, nil
+	}
+
+	_, terms, err := relevanceModel(s, results, f.field)
+	if err != nil {
+		return 0.0, err
+	}
+	if len(terms) == 0 {
+		return 0.0, nil
+	}
+
+	fields := set.Strings(analysis.QueryFields(q.Query))
+	keywords := make([]cqr.CommonQueryRepresentation, len(terms))
+	for i, t := range terms {
+		keywords[i] = cqr.NewKeyword(t.term, fields...)
+	}
+	feedback, err := s.Execute(pipeline.NewQuery(q.Name, q.Topic, cqr.NewBooleanQuery(cqr.OR, keywords)), s.SearchOptions())
+	if err != nil {
+		return 0.0, err
+	}
+
+	n := int(parameter(s, "feedback_n", 50))
+	if n < 1 {
+		return 0.0, nil
+	}
+	if len(results) > n {
+		results = results[:n]
+	}
+	if len(feedback) > n {
+		feedback = feedback[:n]
+	}
+
+	retrieved := make(map[string]bool, len(results))
+	for _, result := range results {
+		retrieved[result.DocId] = true
+	}
+	overlap := 0.0
+	for _, result := range feedback {
+		if retrieved[result.DocId] {
+			overlap++
+		}
+	}
+	return overlap / float64(n), nil
+}
@@ -0,0 +1,198 @@
+package postqpp_test
+
+import (
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis/postqpp"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"math"
+	"testing"
+)
+
+// rankingSource retrieves a fixed ranking for the query it was created for, and another ranking for any other query
+// (i.e. the queries that predictors create). Only the vocabulary of a single field is known.
+type rankingSource struct {
+	query      string
+	field      string
+	results    trecresults.ResultList
+	feedback   trecresults.ResultList
+	vectors    map[string]stats.TermVector
+	parameters map[string]float64
+}
+
+func (r rankingSource) SearchOptions() stats.SearchOptions { return stats.SearchOptions{} }
+func (r rankingSource) Parameters() map[string]float64     { return r.parameters }
+func (r rankingSource) TermFrequency(term, field, document string) (float64, error) {
+	return 0, nil
+}
+func (r rankingSource) TermVector(document string) (stats.TermVector, error) {
+	return r.vectors[document], nil
+}
+func (r rankingSource) DocumentFrequency(term, field string) (float64, error)  { return 0, nil }
+func (r rankingSource) TotalTermFrequency(term, field string) (float64, error) { return 0, nil }
+func (r rankingSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	return 0, nil
+}
+func (r rankingSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	return 0, nil
+}
+func (r rankingSource) VocabularySize(field string) (float64, error) {
+	if field != r.field {
+		return 0, fmt.Errorf("unknown field %s", field)
+	}
+	return 100, nil
+}
+func (r rankingSource) Execute(query pipeline.Query, options stats.SearchOptions) (trecresults.ResultList, error) {
+	if query.Query.String() == r.query {
+		return r.results, nil
+	}
+	return r.feedback, nil
+}
+func (r rankingSource) CollectionSize() (float64, error) { return 10, nil }
+
+// ranking creates a result list of documents with the scores, in order.
+func ranking(docs []string, scores ...float64) trecresults.ResultList {
+	results := make(trecresults.ResultList, len(docs))
+	for i, doc := range docs {
+		results[i] = &trecresults.Result{Topic: "1", DocId: doc, Rank: int64(i + 1), Score: scores[i]}
+	}
+	return results
+}
+
+// vector creates a term vector of the terms and their frequencies in a document.
+func vector(tf map[string]float64) stats.TermVector {
+	var tv stats.TermVector
+	for term, f := range tf {
+		tv = append(tv, stats.TermVectorTerm{Term: term, Field: "tiab", TermFrequency: f, TotalTermFrequency: 10})
+	}
+	return tv
+}
+
+type constant float64
+
+func (constant) Name() string {
+	return "Constant"
+}
+
+func (c constant) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	return float64(c), nil
+}
+
+func source(scores ...float64) rankingSource {
+	q := pipeline.NewQuery("1", "1", cqr.NewKeyword("a", "title"))
+	return rankingSource{
+		query:   q.Query.String(),
+		field:   "tiab",
+		results: ranking([]string{"1", "2", "3"}, scores...),
+		vectors: map[string]stats.TermVector{
+			"1": vector(map[string]float64{"a": 4}),
+			"2": vector(map[string]float64{"a": 2, "b": 2}),
+			"3": vector(map[string]float64{"b": 4}),
+		},
+		parameters: map[string]float64{"k": 3, "mu": 1, "feedback_n": 3},
+	}
+}
+
+func TestScoreMagnitudeVariance(t *testing.T) {
+	q := pipeline.NewQuery("1", "1", cqr.NewKeyword("a", "title"))
+	v, err := postqpp.ScoreMagnitudeVariance.Execute(q, source(4, 2, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// (4|ln(4/(7/3))| + 2|ln(2/(7/3))| + 1|ln(1/(7/3))|) / (3 * 1)
+	if math.Abs(v-1.1038617) > 1e-6 {
+		t.Errorf("expected 1.1038617, got %f", v)
+	}
+
+	// Scores that do not vary are not spread around their mean.
+	v, err = postqpp.ScoreMagnitudeVariance.Execute(q, source(2, 2, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0 {
+		t.Errorf("expected 0, got %f", v)
+	}
+}
+
+func TestQueryFeedback(t *testing.T) {
+	q := pipeline.NewQuery("1", "1", cqr.NewKeyword("a", "title"))
+
+	s := source(3, 2, 1)
+	s.feedback = ranking([]string{"3", "1", "2"}, 3, 2, 1)
+	v, err := postqpp.QueryFeedback.Execute(q, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 1 {
+		t.Errorf("expected the rankings to overlap completely, got %f", v)
+	}
+
+	s.feedback = ranking([]string{"4", "5", "6"}, 3, 2, 1)
+	v, err = postqpp.QueryFeedback.Execute(q, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != 0 {
+		t.Errorf("expected the rankings not to overlap, got %f", v)
+	}
+}
+
+func TestUtilityEstimationFramework(t *testing.T) {
+	q := pipeline.NewQuery("1", "1", cqr.NewKeyword("a", "title"))
+
+	// The relevance model is dominated by the terms of the top ranked document, so it agrees with the ranking, and
+	// the prediction keeps its sign.
+	for _, prediction := range []float64{2, -2} {
+		v, err := postqpp.NewUtilityEstimationFramework(constant(prediction)).Execute(q, source(3, 2, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v == 0 || math.Signbit(v) != math.Signbit(prediction) || math.Abs(v) > math.Abs(prediction) {
+			t.Errorf("expected a prediction with the sign of %f and a smaller magnitude, got %f", prediction, v)
+		}
+	}
+}
+
+func TestRelevanceModelField(t *testing.T) {
+	q := pipeline.NewQuery("1", "1", cqr.NewKeyword("a", "title"))
+	s := source(3, 2, 1)
+	s.field = "ab"
+	s.feedback = ranking([]string{"3", "1", "2"}, 3, 2, 1)
+
+	// The relevance models are estimated from the tiab field, unless another field is configured.
+	if _, err := postqpp.QueryFeedback.Execute(q, s); err == nil {
+		t.Errorf("expected query feedback to estimate a relevance model from the tiab field")
+	}
+	if _, err := postqpp.NewUtilityEstimationFramework(constant(2)).Execute(q, s); err == nil {
+		t.Errorf("expected UEF to estimate a relevance model from the tiab field")
+	}
+
+	feedback := postqpp.NewQueryFeedback(postqpp.QueryFeedbackField("ab"))
+	if feedback.Name() != "QueryFeedback[field=ab]" {
+		t.Errorf("expected the field to be part of the name, got %s", feedback.Name())
+	}
+	if v, err := feedback.Execute(q, s); err != nil || v != 1 {
+		t.Errorf("expected the rankings to overlap completely, got %f (%v)", v, err)
+	}
+	uef := postqpp.NewUtilityEstimationFramework(constant(2), postqpp.UEFField("ab"))
+	if uef.Name() != "UEFConstant[field=ab]" {
+		t.Errorf("expected the field to be part of the name, got %s", uef.Name())
+	}
+	if v, err := uef.Execute(q, s); err != nil || v <= 0 {
+		t.Errorf("expected a positive prediction, got %f (%v)", v, err)
+	}
+}
+
+func TestClarityScoreName(t *testing.T) {
+	for expected, measurement := range map[string]interface{ Name() string }{
+		"ClarityScore":                      postqpp.ClarityScore,
+		"ClarityScore[lambda=0]":            postqpp.NewClarityScore(postqpp.ClarityLambda(0)),
+		"ClarityScore[field=ab,lambda=0.5]": postqpp.NewClarityScore(postqpp.ClarityField("ab"), postqpp.ClarityLambda(0.5)),
+	} {
+		if measurement.Name() != expected {
+			t.Errorf("expected the name %s, got %s", expected, measurement.Name())
+		}
+	}
+}
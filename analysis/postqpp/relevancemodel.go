@@ -0,0 +1,113 @@
+package postqpp
+
+import (
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"math"
+	"sort"
+)
+
+// parameter reads a parameter of a statistics source, or a default value when it has not been set.
+func parameter(s stats.StatisticsSource, name string, value float64) float64 {
+	if v, ok := s.Parameters()[name]; ok {
+		return v
+	}
+	return value
+}
+
+// topK is the first k results of a result list, where k is the "k" parameter of the statistics source.
+func topK(s stats.StatisticsSource, results trecresults.ResultList) trecresults.ResultList {
+	k := int(parameter(s, "k", 10))
+	if k < 1 {
+		k = 1
+	}
+	if len(results) < k {
+		k = len(results)
+	}
+	return results[:k]
+}
+
+// weightedTerm is a term of a relevance model.
+type weightedTerm struct {
+	term   string
+	weight float64
+}
+
+// relevanceModel estimates a relevance model (RM1) from a field (`tiab` if it is empty) of the top ranked documents of a
+// result list. The documents are weighted by their normalised retrieval score. The number of terms in the model is the
+// "feedback_terms" parameter of the statistics source, and the weights of the terms are normalised to sum to one.
+func relevanceModel(s stats.StatisticsSource, results trecresults.ResultList, field string) (*stats.LanguageModel, []weightedTerm, error) {
+	if len(field) == 0 {
+		field = "tiab"
+	}
+
+	top := topK(s, results)
+	docIds := make([]string, len(top))
+	scores := make([]float64, len(top))
+	weights := make([]float64, len(top))
+	var total float64
+	for i, result := range top {
+		docIds[i] = result.DocId
+		scores[i] = result.Score
+		total += result.Score
+	}
+	for i := range weights {
+		weights[i] = 1.0 / float64(len(weights))
+		if total > 0 {
+			weights[i] = scores[i] / total
+		}
+	}
+
+	lm, err := stats.NewLanguageModel(s, docIds, scores, field, stats.LanguageModelWeights(weights))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	terms := make([]weightedTerm, 0, len(lm.TermCount))
+	for term := range lm.TermCount {
+		terms = append(terms, weightedTerm{term: term, weight: lm.DocumentTermProbability(term)})
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if terms[i].weight == terms[j].weight {
+			return terms[i].term < terms[j].term
+		}
+		return terms[i].weight > terms[j].weight
+	})
+	if n := int(parameter(s, "feedback_terms", 20)); n > 0 && len(terms) > n {
+		terms = terms[:n]
+	}
+	var norm float64
+	for _, t := range terms {
+		norm += t.weight
+	}
+	if norm > 0 {
+		for i := range terms {
+			terms[i].weight /= norm
+		}
+	}
+	return lm, terms, nil
+}
+
+// likelihood scores a document by the cross entropy between a relevance model and the Dirichlet smoothed language
+// model of the document, where the amount of smoothing is the "mu" parameter of the statistics source.
+func likelihood(s stats.StatisticsSource, lm *stats.LanguageModel, terms []weightedTerm, docID string) (float64, error) {
+	tv, err := s.TermVector(docID)
+	if err != nil {
+		return 0, err
+	}
+	tf := make(map[string]float64)
+	var length float64
+	for _, term := range tv {
+		tf[term.Term] += term.TermFrequency
+		length += term.TermFrequency
+	}
+	mu := parameter(s, "mu", 2500)
+	var score float64
+	for _, t := range terms {
+		p := (tf[t.term] + mu*lm.CollectionTermProbability(t.term)) / (length + mu)
+		if p > 0 {
+			score += t.weight * math.Log(p)
+		}
+	}
+	return score, nil
+}
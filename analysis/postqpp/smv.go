@@ -0,0 +1,51 @@
+package postqpp
+
+import (
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"math"
+)
+
+type scoreMagnitudeVariance struct{}
+
+// ScoreMagnitudeVariance (SMV) combines the magnitude and the variance of the scores of the top k ranked documents,
+// normalised by the score of the lowest ranked document, which stands in for the score of the collection.
+var ScoreMagnitudeVariance = scoreMagnitudeVariance{}
+
+func (scoreMagnitudeVariance) Name() string {
+	return "SMV"
+}
+
+func (scoreMagnitudeVariance) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	results, err := s.Execute(q, s.SearchOptions())
+	if err != nil {
+		return 0.0, err
+	}
+	if len(results) == 0 {
+		return 0.0, nil
+	}
+
+	D := results[len(results)-1].Score
+	if D == 0 {
+		return 0.0, nil
+	}
+
+	top := topK(s, results)
+	mu := 0.0
+	for _, result := range top {
+		mu += result.Score
+	}
+	mu /= float64(len(top))
+	if mu <= 0 {
+		return 0.0, nil
+	}
+
+	score := 0.0
+	for _, result := range top {
+		if result.Score <= 0 {
+			continue
+		}
+		score += result.Score * math.Abs(math.Log(result.Score/mu))
+	}
+	return score / (float64(len(top)) * D), nil
+}
@@ -0,0 +1,81 @@
+package postqpp
+
+import (
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"gonum.org/v1/gonum/stat"
+	"math"
+)
+
+// UtilityEstimationFramework (UEF) scales another predictor by how well the ranking of the top k documents of a query
+// is reproduced when they are re-ranked by a relevance model estimated from them. A ranking that the relevance model
+// agrees with is more likely to be a good one, so the prediction is trusted more. The relevance model is estimated from
+// the `tiab` field, unless it is configured with UEFField.
+type UtilityEstimationFramework struct {
+	predictor analysis.Measurement
+	field     string
+}
+
+// UEFField sets the field the relevance model of a UEF predictor is estimated from.
+func UEFField(field string) func(u *UtilityEstimationFramework) {
+	return func(u *UtilityEstimationFramework) {
+		u.field = field
+	}
+}
+
+// NewUtilityEstimationFramework creates a UEF predictor on top of an existing predictor (e.g. ClarityScore,
+// NormalisedQueryCommitment or WeightedInformationGain). Explicit parameters are part of its name.
+func NewUtilityEstimationFramework(predictor analysis.Measurement, options ...func(u *UtilityEstimationFramework)) UtilityEstimationFramework {
+	u := UtilityEstimationFramework{predictor: predictor}
+	for _, option := range options {
+		option(&u)
+	}
+	return u
+}
+
+func (u UtilityEstimationFramework) Name() string {
+	parameters := make(map[string]string)
+	if len(u.field) > 0 {
+		parameters["field"] = u.field
+	}
+	return analysis.ParameterisedName("UEF"+u.predictor.Name(), parameters)
+}
+
+func (u UtilityEstimationFramework) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	results, err := s.Execute(q, s.SearchOptions())
+	if err != nil {
+		return 0.0, err
+	}
+	top := topK(s, results)
+	if len(top) < 2 {
+		return 0.0, nil
+	}
+
+	lm, terms, err := relevanceModel(s, top, u.field)
+	if err != nil {
+		return 0.0, err
+	}
+
+	scores := make([]float64, len(top))
+	reranked := make([]float64, len(top))
+	for i, result := range top {
+		scores[i] = result.Score
+		reranked[i], err = likelihood(s, lm, terms, result.DocId)
+		if err != nil {
+			return 0.0, err
+		}
+	}
+
+	// The similarity of the two rankings is the correlation of the scores of the documents.
+	similarity := stat.Correlation(scores, reranked, nil)
+	if math.IsNaN(similarity) {
+		return 0.0, nil
+	}
+
+	prediction, err := u.predictor.Execute(q, s)
+	if err != nil {
+		return 0.0, err
+	}
+	return similarity * prediction, nil
+}
@@ -0,0 +1,224 @@
+package preqpp
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"gonum.org/v1/gonum/floats"
+	"math"
+	"strings"
+)
+
+// queryAtoms extracts the atoms (keywords and adjacency clauses) of a query.
+func queryAtoms(query cqr.CommonQueryRepresentation) []cqr.CommonQueryRepresentation {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		return []cqr.CommonQueryRepresentation{q}
+	case cqr.BooleanQuery:
+		if stats.IsProximityOperator(q.Operator) {
+			return []cqr.CommonQueryRepresentation{q}
+		}
+		var atoms []cqr.CommonQueryRepresentation
+		for _, child := range q.Children {
+			atoms = append(atoms, queryAtoms(child)...)
+		}
+		return atoms
+	}
+	return nil
+}
+
+// conceptBlocks splits a query into its concept blocks, which are the clauses of a top-level `and` query (or the
+// clauses that are not excluded from a top-level `not` query). Any other query is a single concept block.
+func conceptBlocks(query cqr.CommonQueryRepresentation) [][]cqr.CommonQueryRepresentation {
+	q, ok := query.(cqr.BooleanQuery)
+	if !ok || stats.IsProximityOperator(q.Operator) {
+		return [][]cqr.CommonQueryRepresentation{queryAtoms(query)}
+	}
+	switch strings.ToLower(q.Operator) {
+	case cqr.AND:
+		var blocks [][]cqr.CommonQueryRepresentation
+		for _, child := range q.Children {
+			blocks = append(blocks, conceptBlocks(child)...)
+		}
+		return blocks
+	case cqr.NOT:
+		if len(q.Children) > 0 {
+			return conceptBlocks(q.Children[0])
+		}
+		return nil
+	}
+	return [][]cqr.CommonQueryRepresentation{queryAtoms(query)}
+}
+
+// pointwiseMutualInformation computes the PMI of every pair of atoms, log(N·df(a ∧ b) / df(a)df(b)). When a query
+// cache is provided, the documents of the atoms are read from (or fetched into) it, and co-occurrences are computed
+// from them. Otherwise, the document frequencies are the retrieval sizes of the atoms, and of an `and` query of each
+// pair of atoms. Pairs where either atom retrieves nothing are skipped.
+//
+// Pairs that never co-occur would have a PMI of negative infinity, so their co-occurrence is smoothed to half a
+// document, log(N·0.5 / df(a)df(b)), which is lower than the PMI of any co-occurrence of the pair. Smoothing can still
+// give a positive PMI to rare atoms, so it is capped at zero. Whether each pair co-occurs is also returned, since a
+// pair that never co-occurs is never coherent.
+func pointwiseMutualInformation(atoms []cqr.CommonQueryRepresentation, s stats.StatisticsSource, cache combinator.QueryCacher) ([]float64, []bool, error) {
+	if len(atoms) < 2 {
+		return nil, nil, nil
+	}
+	N, err := s.CollectionSize()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	type pair struct{ i, j int }
+	var (
+		dfs   = make([]float64, len(atoms))
+		pairs []pair
+		co    []float64
+	)
+	for i := range atoms {
+		for j := i + 1; j < len(atoms); j++ {
+			pairs = append(pairs, pair{i, j})
+		}
+	}
+
+	if cache != nil {
+		fetch := combinator.NewFetchQueryCache(cache, s)
+		docs := make([]combinator.Documents, len(atoms))
+		for i, atom := range atoms {
+			docs[i], err = fetch.Get(atom)
+			if err != nil {
+				return nil, nil, err
+			}
+			dfs[i] = float64(docs[i].Len())
+		}
+		co = make([]float64, len(pairs))
+		for k, p := range pairs {
+			co[k] = float64(docs[p.i].Intersect(docs[p.j]).Len())
+		}
+	} else {
+		dfs, err = stats.RetrievalSizes(s, atoms)
+		if err != nil {
+			return nil, nil, err
+		}
+		queries := make([]cqr.CommonQueryRepresentation, len(pairs))
+		for k, p := range pairs {
+			queries[k] = cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{atoms[p.i], atoms[p.j]})
+		}
+		co, err = stats.RetrievalSizes(s, queries)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var (
+		pmi      []float64
+		cooccurs []bool
+	)
+	for k, p := range pairs {
+		if dfs[p.i] == 0 || dfs[p.j] == 0 {
+			continue
+		}
+		if co[k] == 0 {
+			pmi = append(pmi, math.Min(math.Log(N*0.5/(dfs[p.i]*dfs[p.j])), 0))
+			cooccurs = append(cooccurs, false)
+			continue
+		}
+		pmi = append(pmi, math.Log(N*co[k]/(dfs[p.i]*dfs[p.j])))
+		cooccurs = append(cooccurs, true)
+	}
+	return pmi, cooccurs, nil
+}
+
+// Coherence is a family of predictors that measure how strongly the atoms of a query co-occur in the collection, using
+// pointwise mutual information (PMI). Queries whose terms co-occur are assumed to be about a coherent topic.
+type Coherence struct {
+	name    string
+	cache   combinator.QueryCacher
+	measure func(q pipeline.Query, s stats.StatisticsSource, cache combinator.QueryCacher) (float64, error)
+}
+
+// Name is the name of the predictor.
+func (c Coherence) Name() string {
+	return c.name
+}
+
+// Execute computes the predictor for a query.
+func (c Coherence) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	return c.measure(q, s, c.cache)
+}
+
+// NewAvgPMI creates a predictor for the average PMI of every pair of atoms in a query. The documents of atoms are
+// cached in the query cache, which may be nil to use retrieval sizes instead.
+func NewAvgPMI(cache combinator.QueryCacher) Coherence {
+	return Coherence{name: "AvgPMI", cache: cache, measure: func(q pipeline.Query, s stats.StatisticsSource, cache combinator.QueryCacher) (float64, error) {
+		pmi, _, err := pointwiseMutualInformation(queryAtoms(q.Query), s, cache)
+		if err != nil || len(pmi) == 0 {
+			return 0.0, err
+		}
+		return floats.Sum(pmi) / float64(len(pmi)), nil
+	}}
+}
+
+// NewMaxPMI creates a predictor for the maximum PMI of every pair of atoms in a query.
+func NewMaxPMI(cache combinator.QueryCacher) Coherence {
+	return Coherence{name: "MaxPMI", cache: cache, measure: func(q pipeline.Query, s stats.StatisticsSource, cache combinator.QueryCacher) (float64, error) {
+		pmi, _, err := pointwiseMutualInformation(queryAtoms(q.Query), s, cache)
+		if err != nil || len(pmi) == 0 {
+			return 0.0, err
+		}
+		return floats.Max(pmi), nil
+	}}
+}
+
+// blockCoherence averages a coherence score of the atoms within each concept block of a query. Blocks with fewer than
+// two atoms have no coherence, and are not included in the average.
+func blockCoherence(q pipeline.Query, s stats.StatisticsSource, cache combinator.QueryCacher, score func(pmi []float64, cooccurs []bool) float64) (float64, error) {
+	var (
+		total float64
+		n     int
+	)
+	for _, block := range conceptBlocks(q.Query) {
+		pmi, cooccurs, err := pointwiseMutualInformation(block, s, cache)
+		if err != nil {
+			return 0.0, err
+		}
+		if len(pmi) == 0 {
+			continue
+		}
+		total += score(pmi, cooccurs)
+		n++
+	}
+	if n == 0 {
+		return 0.0, nil
+	}
+	return total / float64(n), nil
+}
+
+// NewBlockAvgPMI creates a predictor for the average PMI of the pairs of atoms within each concept block of a query,
+// averaged over the blocks. Synonyms in a block are expected to co-occur; a block whose atoms do not may have drifted.
+func NewBlockAvgPMI(cache combinator.QueryCacher) Coherence {
+	return Coherence{name: "BlockAvgPMI", cache: cache, measure: func(q pipeline.Query, s stats.StatisticsSource, cache combinator.QueryCacher) (float64, error) {
+		return blockCoherence(q, s, cache, func(pmi []float64, _ []bool) float64 {
+			return floats.Sum(pmi) / float64(len(pmi))
+		})
+	}}
+}
+
+// NewAvgQC creates a predictor for the query coherence (QC) of each concept block of a query, averaged over the blocks.
+// The coherence of a block is the proportion of the pairs of its atoms that co-occur more than they would by chance,
+// i.e. that co-occur and whose PMI is greater than the "qc_threshold" parameter of the statistics source (zero by
+// default).
+func NewAvgQC(cache combinator.QueryCacher) Coherence {
+	return Coherence{name: "AvgQC", cache: cache, measure: func(q pipeline.Query, s stats.StatisticsSource, cache combinator.QueryCacher) (float64, error) {
+		threshold := s.Parameters()["qc_threshold"]
+		return blockCoherence(q, s, cache, func(pmi []float64, cooccurs []bool) float64 {
+			coherent := 0.0
+			for i, v := range pmi {
+				if cooccurs[i] && v > threshold {
+					coherent++
+				}
+			}
+			return coherent / float64(len(pmi))
+		})
+	}}
+}
@@ -0,0 +1,135 @@
+package preqpp_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis/preqpp"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// collectionSource only knows the size of the collection, and the frequency of terms in documents.
+type collectionSource struct {
+	tf     func(term, document string) float64
+	params map[string]float64
+}
+
+func (c collectionSource) SearchOptions() stats.SearchOptions { return stats.SearchOptions{} }
+func (c collectionSource) Parameters() map[string]float64     { return c.params }
+func (c collectionSource) TermFrequency(term, field, document string) (float64, error) {
+	return c.tf(term, document), nil
+}
+func (c collectionSource) TermVector(document string) (stats.TermVector, error) { return nil, nil }
+func (c collectionSource) DocumentFrequency(term, field string) (float64, error) {
+	return 0, nil
+}
+func (c collectionSource) TotalTermFrequency(term, field string) (float64, error) { return 0, nil }
+func (c collectionSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	return 0, nil
+}
+func (c collectionSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	return 0, nil
+}
+func (c collectionSource) VocabularySize(field string) (float64, error) { return 0, nil }
+func (c collectionSource) Execute(query pipeline.Query, options stats.SearchOptions) (trecresults.ResultList, error) {
+	return nil, nil
+}
+func (c collectionSource) CollectionSize() (float64, error) { return 1000, nil }
+
+func documents(from, to int) combinator.Documents {
+	var ids []combinator.Document
+	for i := from; i < to; i++ {
+		ids = append(ids, combinator.Document(i))
+	}
+	return combinator.NewDocuments(ids...)
+}
+
+func TestCoherence(t *testing.T) {
+	a, b, c, d := cqr.NewKeyword("a", "title"), cqr.NewKeyword("b", "title"), cqr.NewKeyword("c", "title"), cqr.NewKeyword("d", "title")
+	cache := combinator.NewMapQueryCache()
+	cache.Set(a, documents(0, 100))
+	cache.Set(b, documents(50, 150))
+	cache.Set(c, documents(500, 600))
+	cache.Set(d, documents(0, 10))
+	s := collectionSource{}
+
+	measure := func(m preqpp.Coherence, query cqr.CommonQueryRepresentation) float64 {
+		v, err := m.Execute(pipeline.NewQuery("1", "1", query), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// PMI(a, b) = ln(1000·50 / 100·100) = ln(5), and PMI(a, d) = ln(1000·10 / 100·10) = ln(10). b and d never
+	// co-occur, so their co-occurrence is smoothed to half a document, ln(1000·0.5 / 100·10) = ln(0.5).
+	q := cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a, b, d})
+	if v := measure(preqpp.NewAvgPMI(cache), q); math.Abs(v-(math.Log(5)+math.Log(10)+math.Log(0.5))/3) > 1e-9 {
+		t.Errorf("expected AvgPMI of %f, got %f", (math.Log(5)+math.Log(10)+math.Log(0.5))/3, v)
+	}
+	if v := measure(preqpp.NewMaxPMI(cache), q); math.Abs(v-math.Log(10)) > 1e-9 {
+		t.Errorf("expected MaxPMI of %f, got %f", math.Log(10), v)
+	}
+
+	// The concept blocks are (a OR b) and (c OR d); the excluded clause is not a concept block. c and d never
+	// co-occur, so the block has a PMI of ln(1000·0.5 / 100·10) = ln(0.5), and is not coherent.
+	q = cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{
+		cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+			cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a, b}),
+			cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{c, d}),
+		}),
+		cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a, c}),
+	})
+	if v := measure(preqpp.NewBlockAvgPMI(cache), q); math.Abs(v-(math.Log(5)+math.Log(0.5))/2) > 1e-9 {
+		t.Errorf("expected BlockAvgPMI of %f, got %f", (math.Log(5)+math.Log(0.5))/2, v)
+	}
+	if v := measure(preqpp.NewAvgQC(cache), q); v != 0.5 {
+		t.Errorf("expected AvgQC of 0.5, got %f", v)
+	}
+
+	// Rare atoms that never co-occur would have a positive smoothed PMI, ln(1000·0.5 / 2·2), so it is capped at zero,
+	// and they are not coherent even below a negative threshold.
+	e, f := cqr.NewKeyword("e", "title"), cqr.NewKeyword("f", "title")
+	cache.Set(e, documents(700, 702))
+	cache.Set(f, documents(800, 802))
+	q = cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{e, f})
+	if v := measure(preqpp.NewMaxPMI(cache), q); v != 0 {
+		t.Errorf("expected MaxPMI of 0, got %f", v)
+	}
+	s.params = map[string]float64{"qc_threshold": -1}
+	if v := measure(preqpp.NewAvgQC(cache), q); v != 0 {
+		t.Errorf("expected AvgQC of 0, got %f", v)
+	}
+}
+
+func TestVariability(t *testing.T) {
+	a := cqr.NewKeyword("a", "title")
+	cache := combinator.NewMapQueryCache()
+	cache.Set(a, documents(0, 100))
+	s := collectionSource{tf: func(term, document string) float64 {
+		id, _ := strconv.Atoi(document)
+		return float64(id%3 + 1)
+	}}
+
+	v, err := preqpp.NewAvgVAR(cache).Execute(pipeline.NewQuery("1", "1", a), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v <= 0 {
+		t.Fatalf("expected the weights of a to vary, got %f", v)
+	}
+
+	// Phrases and wildcards are not terms, so they do not contribute to the average.
+	q := cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a, cqr.NewKeyword("heart attack", "title"), cqr.NewKeyword("neoplas*", "title")})
+	w, err := preqpp.NewAvgVAR(cache).Execute(pipeline.NewQuery("1", "1", q), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w != v {
+		t.Errorf("expected phrases and wildcards to be skipped, got %f rather than %f", w, v)
+	}
+}
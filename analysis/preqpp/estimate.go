@@ -0,0 +1,288 @@
+package preqpp
+
+import (
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis/qppeval"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"math"
+	"sort"
+	"strings"
+)
+
+// EstimationModel is how the estimated sizes of the clauses of a Boolean query are combined.
+type EstimationModel int
+
+const (
+	// IndependenceModel assumes that the atoms of a query occur in documents independently of each other.
+	IndependenceModel EstimationModel = iota
+	// OverlapModel corrects the independence model with the pairwise overlap of the documents of the cached atoms of
+	// a query, so that clauses which tend to co-occur (e.g. synonyms) are not over-counted.
+	OverlapModel
+)
+
+func (m EstimationModel) String() string {
+	switch m {
+	case OverlapModel:
+		return "Overlap"
+	default:
+		return "Independence"
+	}
+}
+
+// RetrievalSizeEstimator predicts the number of documents a Boolean query retrieves from the document frequencies of
+// its atoms (keywords and adjacency clauses), without executing the query. The document frequencies are read from a
+// cache of atoms; when an atom is not cached, the retrieval size of only that atom is requested from the statistics
+// source, and the atom is assumed to be independent of the others.
+type RetrievalSizeEstimator struct {
+	cache          combinator.QueryCacher
+	model          EstimationModel
+	collectionSize float64
+}
+
+// EstimateModel sets the model used to combine clauses (the independence model by default).
+func EstimateModel(model EstimationModel) func(e *RetrievalSizeEstimator) {
+	return func(e *RetrievalSizeEstimator) {
+		e.model = model
+	}
+}
+
+// EstimateCollectionSize sets the number of documents in the collection, rather than asking the statistics source.
+func EstimateCollectionSize(n float64) func(e *RetrievalSizeEstimator) {
+	return func(e *RetrievalSizeEstimator) {
+		e.collectionSize = n
+	}
+}
+
+// NewRetrievalSizeEstimator creates a retrieval size estimator that reads the documents of atoms from a cache.
+func NewRetrievalSizeEstimator(cache combinator.QueryCacher, options ...func(e *RetrievalSizeEstimator)) RetrievalSizeEstimator {
+	e := RetrievalSizeEstimator{cache: cache}
+	for _, option := range options {
+		option(&e)
+	}
+	return e
+}
+
+// Name is the name of the estimator, which includes the model, so that estimates of each model are cached separately.
+func (e RetrievalSizeEstimator) Name() string {
+	return "EstimatedRetrievalSize" + e.model.String()
+}
+
+// Execute estimates the retrieval size of a query.
+func (e RetrievalSizeEstimator) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	return e.Estimate(q.Query, s)
+}
+
+// Estimate estimates the retrieval size of a query. The statistics source is only used for the size of the collection
+// and for atoms that are not cached; it may be nil if neither is required.
+func (e RetrievalSizeEstimator) Estimate(query cqr.CommonQueryRepresentation, s stats.StatisticsSource) (float64, error) {
+	N := e.collectionSize
+	if N <= 0 {
+		if s == nil {
+			return 0, fmt.Errorf("the collection size is required to estimate retrieval size")
+		}
+		var err error
+		N, err = s.CollectionSize()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if N <= 0 {
+		return 0, nil
+	}
+	c, err := e.estimate(query, s, N)
+	if err != nil {
+		return 0, err
+	}
+	return math.Round(c.p * N), nil
+}
+
+// clause is the estimated probability of a document being retrieved by a clause, along with the documents of the
+// cached atoms it contains, which are used to compute the overlap between clauses.
+type clause struct {
+	p     float64
+	atoms []combinator.Documents
+}
+
+func (e RetrievalSizeEstimator) estimate(query cqr.CommonQueryRepresentation, s stats.StatisticsSource, N float64) (clause, error) {
+	q, ok := query.(cqr.BooleanQuery)
+	if !ok || stats.IsProximityOperator(q.Operator) {
+		return e.atom(query, s, N)
+	}
+
+	children := make([]clause, 0, len(q.Children))
+	for _, child := range q.Children {
+		c, err := e.estimate(child, s, N)
+		if err != nil {
+			return clause{}, err
+		}
+		children = append(children, c)
+	}
+	if len(children) == 0 {
+		return clause{}, nil
+	}
+
+	var atoms []combinator.Documents
+	for _, c := range children {
+		atoms = append(atoms, c.atoms...)
+	}
+	lift := func(i, j int) float64 {
+		if e.model != OverlapModel {
+			return 1
+		}
+		return overlap(children[i], children[j], N)
+	}
+
+	var p float64
+	switch strings.ToLower(q.Operator) {
+	case cqr.AND:
+		// P(a ∧ b) = P(a)P(b)·lift(a, b), bounded by the least probable clause.
+		p = 1
+		bound := 1.0
+		for i, c := range children {
+			p *= c.p
+			bound = math.Min(bound, c.p)
+			for j := i + 1; j < len(children); j++ {
+				p *= lift(i, j)
+			}
+		}
+		p = math.Min(p, bound)
+	case cqr.NOT:
+		first := children[0]
+		atoms = first.atoms
+		p = first.p
+		if e.model == IndependenceModel {
+			for _, c := range children[1:] {
+				p *= 1 - c.p
+			}
+			break
+		}
+		// P(a ∧ ¬b) = P(a) - P(a ∧ b), for each excluded clause b.
+		for j := 1; j < len(children); j++ {
+			p -= math.Min(first.p*children[j].p*lift(0, j), first.p)
+		}
+		p = math.Max(p, 0)
+	default:
+		// Clauses of any other operator are treated as disjunctions.
+		if e.model == IndependenceModel {
+			p = 1
+			for _, c := range children {
+				p *= 1 - c.p
+			}
+			p = 1 - p
+			break
+		}
+		// The second-order inclusion-exclusion of the clauses, bounded by the most probable clause and their sum.
+		var sum, max float64
+		for i, c := range children {
+			sum += c.p
+			max = math.Max(max, c.p)
+			p += c.p
+			for j := i + 1; j < len(children); j++ {
+				p -= c.p * children[j].p * lift(i, j)
+			}
+		}
+		p = math.Max(math.Min(p, math.Min(sum, 1)), max)
+	}
+	return clause{p: math.Max(math.Min(p, 1), 0), atoms: atoms}, nil
+}
+
+// atom estimates the probability of a document being retrieved by an atom from its document frequency.
+func (e RetrievalSizeEstimator) atom(query cqr.CommonQueryRepresentation, s stats.StatisticsSource, N float64) (clause, error) {
+	docs, err := e.cache.Get(query)
+	if err == nil {
+		return clause{p: math.Min(float64(docs.Len())/N, 1), atoms: []combinator.Documents{docs}}, nil
+	}
+	if err != combinator.ErrCacheMiss {
+		return clause{}, err
+	}
+	if s == nil {
+		return clause{}, combinator.ErrCacheMiss
+	}
+	df, err := s.RetrievalSize(query)
+	if err != nil {
+		return clause{}, err
+	}
+	return clause{p: math.Min(df/N, 1)}, nil
+}
+
+// overlap is the mean lift, P(a ∧ b) / P(a)P(b), between the cached atoms of two clauses. A lift greater than one means
+// the atoms co-occur more than they would if they were independent. Clauses without cached atoms are independent.
+func overlap(a, b clause, N float64) float64 {
+	var (
+		lift float64
+		n    int
+	)
+	for _, x := range a.atoms {
+		for _, y := range b.atoms {
+			if x.Len() == 0 || y.Len() == 0 {
+				continue
+			}
+			lift += float64(x.Intersect(y).Len()) * N / (float64(x.Len()) * float64(y.Len()))
+			n++
+		}
+	}
+	if n == 0 {
+		return 1
+	}
+	return lift / float64(n)
+}
+
+// EstimationError summarises how closely estimated retrieval sizes match the measured retrieval sizes of queries.
+// The log errors are computed on log10(1 + size), so that an error of 1 means an estimate is off by an order of
+// magnitude. KendallTau is the rank correlation of the estimated and measured sizes, which is what matters when the
+// estimates are used to order or prune candidate queries.
+type EstimationError struct {
+	N                 int     `json:"n"`
+	MeanAbsoluteError float64 `json:"mae"`
+	RootMeanSquared   float64 `json:"rmse"`
+	MeanLogError      float64 `json:"mean_log_error"`
+	MeanAbsLogError   float64 `json:"mean_abs_log_error"`
+	MedianAbsLogError float64 `json:"median_abs_log_error"`
+	KendallTau        float64 `json:"kendall_tau"`
+}
+
+// EstimationErrors compares estimated retrieval sizes to the measured retrieval sizes of the same queries. A positive
+// mean log error means that sizes are over-estimated.
+func EstimationErrors(estimated, measured []float64) (EstimationError, error) {
+	if len(estimated) != len(measured) {
+		return EstimationError{}, fmt.Errorf("%d estimated sizes for %d measured sizes", len(estimated), len(measured))
+	}
+	n := len(estimated)
+	if n == 0 {
+		return EstimationError{}, nil
+	}
+
+	e := EstimationError{N: n}
+	logErrors := make([]float64, n)
+	for i := range estimated {
+		diff := estimated[i] - measured[i]
+		e.MeanAbsoluteError += math.Abs(diff)
+		e.RootMeanSquared += diff * diff
+		logErrors[i] = math.Log10(1+estimated[i]) - math.Log10(1+measured[i])
+		e.MeanLogError += logErrors[i]
+		e.MeanAbsLogError += math.Abs(logErrors[i])
+	}
+	e.MeanAbsoluteError /= float64(n)
+	e.RootMeanSquared = math.Sqrt(e.RootMeanSquared / float64(n))
+	e.MeanLogError /= float64(n)
+	e.MeanAbsLogError /= float64(n)
+
+	for i := range logErrors {
+		logErrors[i] = math.Abs(logErrors[i])
+	}
+	sort.Float64s(logErrors)
+	if n%2 == 1 {
+		e.MedianAbsLogError = logErrors[n/2]
+	} else {
+		e.MedianAbsLogError = (logErrors[n/2-1] + logErrors[n/2]) / 2
+	}
+
+	// The rank correlation is undefined when every size is the same.
+	if tau := qppeval.Kendall(estimated, measured); !math.IsNaN(tau) {
+		e.KendallTau = tau
+	}
+	return e, nil
+}
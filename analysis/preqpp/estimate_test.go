@@ -0,0 +1,63 @@
+package preqpp_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis/preqpp"
+	"github.com/hscells/groove/combinator"
+	"math"
+	"testing"
+)
+
+func TestRetrievalSizeEstimator(t *testing.T) {
+	a, b, c := cqr.NewKeyword("a", "title"), cqr.NewKeyword("b", "title"), cqr.NewKeyword("c", "title")
+	cache := combinator.NewMapQueryCache()
+	docs := func(from, to int) combinator.Documents {
+		var ids []combinator.Document
+		for i := from; i < to; i++ {
+			ids = append(ids, combinator.Document(i))
+		}
+		return combinator.NewDocuments(ids...)
+	}
+	// a and b are synonyms that mostly retrieve the same documents.
+	cache.Set(a, docs(0, 100))
+	cache.Set(b, docs(10, 110))
+	cache.Set(c, docs(500, 600))
+
+	or := cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a, b})
+	independence := preqpp.NewRetrievalSizeEstimator(cache, preqpp.EstimateCollectionSize(1000))
+	overlap := preqpp.NewRetrievalSizeEstimator(cache, preqpp.EstimateCollectionSize(1000), preqpp.EstimateModel(preqpp.OverlapModel))
+
+	i, err := independence.Estimate(or, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := overlap.Estimate(or, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 190 {
+		t.Errorf("expected the independence model to estimate 190 documents, got %f", i)
+	}
+	if math.Abs(o-110) >= math.Abs(i-110) {
+		t.Errorf("expected the overlap model (%f) to be closer to 110 documents than the independence model (%f)", o, i)
+	}
+
+	and := cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{or, c})
+	if o, err = overlap.Estimate(and, nil); err != nil {
+		t.Fatal(err)
+	} else if o != 0 {
+		t.Errorf("expected disjoint clauses to be estimated to retrieve nothing, got %f", o)
+	}
+
+	if _, err := independence.Estimate(cqr.NewKeyword("d", "title"), nil); err != combinator.ErrCacheMiss {
+		t.Errorf("expected an atom missing from the cache without a statistics source to be a cache miss, got %v", err)
+	}
+
+	e, err := preqpp.EstimationErrors([]float64{9, 99, 999}, []float64{9, 999, 99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(e.MedianAbsLogError-1) > 1e-9 || math.Abs(e.KendallTau-1.0/3) > 1e-9 || math.Abs(e.MeanLogError) > 1e-9 {
+		t.Errorf("unexpected estimation errors %+v", e)
+	}
+}
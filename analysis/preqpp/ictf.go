@@ -28,17 +28,24 @@ func (avgi avgICTF) Execute(q pipeline.Query, s stats.StatisticsSource) (float64
 	sumICTF := 0.0
 	fields := analysis.QueryFields(q.Query)
 
+	var tfs []stats.TermField
 	for _, field := range fields {
+		for _, term := range terms {
+			tfs = append(tfs, stats.TermField{Term: term, Field: field})
+		}
+	}
+	ttf, err := stats.TotalTermFrequencies(s, tfs)
+	if err != nil {
+		return 0.0, err
+	}
+
+	for i, field := range fields {
 		W, err := s.VocabularySize(field)
 		if err != nil {
 			return 0.0, err
 		}
-		for _, term := range terms {
-			tf, err := s.TotalTermFrequency(term, field)
-			if err != nil {
-				return 0.0, err
-			}
-			sumICTF += math.Log2(W) - math.Log2(1+tf)
+		for j := range terms {
+			sumICTF += math.Log2(W) - math.Log2(1+ttf[i*len(terms)+j])
 		}
 	}
 	return (1.0 / float64(len(terms))) * sumICTF, nil
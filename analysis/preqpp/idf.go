@@ -1,6 +1,7 @@
 package preqpp
 
 import (
+	"github.com/hscells/cqr"
 	"github.com/hscells/groove/analysis"
 	"github.com/hscells/groove/pipeline"
 	"github.com/hscells/groove/stats"
@@ -31,19 +32,11 @@ func (avg avgIDF) Name() string {
 
 func (avg avgIDF) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
 	keywords := analysis.QueryKeywords(q.Query)
-	sumIDF := 0.0
-
-	for _, k := range keywords {
-		for _, field := range k.Fields {
-			idf, err := s.InverseDocumentFrequency(k.QueryString, field)
-			if err != nil {
-				return 0.0, err
-			}
-			sumIDF += idf
-		}
+	idfs, err := keywordIDFs(keywords, s)
+	if err != nil {
+		return 0.0, err
 	}
-
-	return sumIDF / float64(len(keywords)), nil
+	return floats.Sum(idfs) / float64(len(keywords)), nil
 }
 
 func (sum sumIDF) Name() string {
@@ -51,20 +44,11 @@ func (sum sumIDF) Name() string {
 }
 
 func (sum sumIDF) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
-	keywords := analysis.QueryKeywords(q.Query)
-	sumIDF := 0.0
-
-	for _, k := range keywords {
-		for _, field := range k.Fields {
-			idf, err := s.InverseDocumentFrequency(k.QueryString, field)
-			if err != nil {
-				return 0.0, err
-			}
-			sumIDF += idf
-		}
+	idfs, err := keywordIDFs(analysis.QueryKeywords(q.Query), s)
+	if err != nil {
+		return 0.0, err
 	}
-
-	return sumIDF, nil
+	return floats.Sum(idfs), nil
 }
 
 func (sum maxIDF) Name() string {
@@ -72,17 +56,9 @@ func (sum maxIDF) Name() string {
 }
 
 func (sum maxIDF) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
-	var scores []float64
-	keywords := analysis.QueryKeywords(q.Query)
-
-	for _, k := range keywords {
-		for _, field := range k.Fields {
-			idf, err := s.InverseDocumentFrequency(k.QueryString, field)
-			if err != nil {
-				return 0.0, err
-			}
-			scores = append(scores, idf)
-		}
+	scores, err := keywordIDFs(analysis.QueryKeywords(q.Query), s)
+	if err != nil {
+		return 0.0, err
 	}
 
 	if len(scores) == 0 {
@@ -97,21 +73,15 @@ func (sum stdDevIDF) Name() string {
 }
 
 func (sum stdDevIDF) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
-	var scores []float64
 	keywords := analysis.QueryKeywords(q.Query)
 
 	if len(keywords) == 1 {
 		return 0, nil
 	}
 
-	for _, k := range keywords {
-		for _, field := range k.Fields {
-			idf, err := s.InverseDocumentFrequency(k.QueryString, field)
-			if err != nil {
-				return 0.0, err
-			}
-			scores = append(scores, idf)
-		}
+	scores, err := keywordIDFs(keywords, s)
+	if err != nil {
+		return 0.0, err
 	}
 
 	stdDev := stat.StdDev(scores, nil)
@@ -121,3 +91,90 @@ func (sum stdDevIDF) Execute(q pipeline.Query, s stats.StatisticsSource) (float6
 	}
 	return 0, nil
 }
+
+// IDFStatistics computes the average, sum, maximum and standard deviation of the IDF of the keywords of a query from a
+// single set of statistics. The values have the same names as AvgIDF, SumIDF, MaxIDF and StdDevIDF. The statistics can
+// be restricted to a single field with NewIDFStatistics.
+var IDFStatistics = idfStatistics{}
+
+type idfStatistics struct {
+	field string
+}
+
+// NewIDFStatistics creates an IDFStatistics measurement that only uses the statistics of a field (e.g. `ti`). The name
+// of the field is part of the names of the values.
+func NewIDFStatistics(field string) analysis.VectorMeasurement {
+	return idfStatistics{field: field}
+}
+
+func (i idfStatistics) name(name string) string {
+	if len(i.field) == 0 {
+		return name
+	}
+	return analysis.ParameterisedName(name, map[string]string{"field": i.field})
+}
+
+func (i idfStatistics) Name() string {
+	return i.name("IDFStatistics")
+}
+
+func (i idfStatistics) Names() []string {
+	return []string{i.name(AvgIDF.Name()), i.name(SumIDF.Name()), i.name(MaxIDF.Name()), i.name(StdDevIDF.Name())}
+}
+
+func (i idfStatistics) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	v, err := i.ExecuteVector(q, s)
+	if err != nil {
+		return 0.0, err
+	}
+	return v[0], nil
+}
+
+func (i idfStatistics) ExecuteVector(q pipeline.Query, s stats.StatisticsSource) ([]float64, error) {
+	keywords := analysis.QueryKeywords(q.Query)
+	if len(i.field) > 0 {
+		var restricted []cqr.Keyword
+		for _, k := range keywords {
+			for _, field := range k.Fields {
+				if field == i.field {
+					k.Fields = []string{field}
+					restricted = append(restricted, k)
+					break
+				}
+			}
+		}
+		keywords = restricted
+	}
+	if len(keywords) == 0 {
+		return []float64{0, 0, 0, 0}, nil
+	}
+
+	idfs, err := keywordIDFs(keywords, s)
+	if err != nil {
+		return nil, err
+	}
+	sum := floats.Sum(idfs)
+	var max, stdDev float64
+	if len(idfs) > 0 {
+		max = floats.Max(idfs)
+	}
+	if len(keywords) > 1 {
+		stdDev = stat.StdDev(idfs, nil)
+		if math.IsNaN(stdDev) || math.IsInf(stdDev, 0) {
+			stdDev = 0
+		}
+	}
+	return []float64{sum / float64(len(keywords)), sum, max, stdDev}, nil
+}
+
+// keywordIDFs computes the idf of every keyword in every field it is searched in. The statistics are requested in a
+// single batch when the statistics source supports it.
+func keywordIDFs(keywords []cqr.Keyword, s stats.StatisticsSource) ([]float64, error) {
+	var terms []stats.TermField
+	for _, k := range keywords {
+		for _, field := range k.Fields {
+			terms = append(terms, stats.TermField{Term: k.QueryString, Field: field})
+		}
+	}
+	return stats.InverseDocumentFrequencies(s, terms)
+}
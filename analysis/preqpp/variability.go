@@ -0,0 +1,134 @@
+package preqpp
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"gonum.org/v1/gonum/floats"
+	"gonum.org/v1/gonum/stat"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Variability (VAR) is a family of predictors of the variability of the weights of the terms of a query across their
+// posting lists. A term whose weight varies greatly in the documents containing it is assumed to be more
+// discriminative. The weight of a term in a document is (1 + ln(tf))·ln(1 + N/df), and the variability of a term is
+// the standard deviation of its weights.
+//
+// The posting list of a term is the documents its keyword retrieves, which are read from (or fetched into) a query
+// cache. Computing weights requests the term frequency of the term in every document, so at most the "var_sample"
+// parameter of the statistics source documents (100 by default) are sampled from the posting list of each term.
+// Keywords that are not a single term (i.e. phrases and wildcards) have no term frequency, so they are skipped.
+type Variability struct {
+	name      string
+	cache     combinator.QueryCacher
+	aggregate func(vars []float64) float64
+}
+
+// NewAvgVAR creates a predictor for the average variability of the keywords of a query. A nil query cache caches
+// posting lists in memory for a single query.
+func NewAvgVAR(cache combinator.QueryCacher) Variability {
+	return Variability{name: "AvgVAR", cache: cache, aggregate: func(vars []float64) float64 {
+		return floats.Sum(vars) / float64(len(vars))
+	}}
+}
+
+// NewSumVAR creates a predictor for the summed variability of the keywords of a query.
+func NewSumVAR(cache combinator.QueryCacher) Variability {
+	return Variability{name: "SumVAR", cache: cache, aggregate: floats.Sum}
+}
+
+// NewMaxVAR creates a predictor for the maximum variability of the keywords of a query.
+func NewMaxVAR(cache combinator.QueryCacher) Variability {
+	return Variability{name: "MaxVAR", cache: cache, aggregate: floats.Max}
+}
+
+// Name is the name of the predictor.
+func (v Variability) Name() string {
+	return v.name
+}
+
+// Execute computes the predictor for a query.
+func (v Variability) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	cache := v.cache
+	if cache == nil {
+		cache = combinator.NewMapQueryCache()
+	}
+	cache = combinator.NewFetchQueryCache(cache, s)
+
+	N, err := s.CollectionSize()
+	if err != nil {
+		return 0.0, err
+	}
+	sample := 100
+	if n, ok := s.Parameters()["var_sample"]; ok {
+		sample = int(n)
+	}
+
+	var vars []float64
+	for _, keyword := range analysis.QueryKeywords(q.Query) {
+		if !isTerm(keyword) {
+			continue
+		}
+		variability, err := termVariability(keyword, s, cache, N, sample)
+		if err != nil {
+			return 0.0, err
+		}
+		vars = append(vars, variability)
+	}
+	if len(vars) == 0 {
+		return 0.0, nil
+	}
+	return v.aggregate(vars), nil
+}
+
+// isTerm returns if a keyword is a single term, rather than a phrase or a wildcard.
+func isTerm(keyword cqr.Keyword) bool {
+	if truncated, ok := keyword.Options[cqr.TruncatedString].(bool); ok && truncated {
+		return false
+	}
+	return !strings.ContainsAny(strings.TrimSpace(keyword.QueryString), " *?$")
+}
+
+// termVariability computes the variability of the weights of a keyword in a sample of its posting list.
+func termVariability(keyword cqr.Keyword, s stats.StatisticsSource, cache combinator.QueryCacher, N float64, sample int) (float64, error) {
+	docs, err := cache.Get(keyword)
+	if err != nil {
+		return 0.0, err
+	}
+	df := float64(docs.Len())
+	if df == 0 {
+		return 0.0, nil
+	}
+
+	ids := docs.IDs()
+	// Sample documents evenly from the posting list.
+	step := 1
+	if sample > 0 && len(ids) > sample {
+		step = (len(ids) + sample - 1) / sample
+	}
+
+	var weights []float64
+	for i := 0; i < len(ids); i += step {
+		doc := strconv.FormatUint(uint64(ids[i]), 10)
+		var tf float64
+		for _, field := range keyword.Fields {
+			f, err := s.TermFrequency(keyword.QueryString, field, doc)
+			if err != nil {
+				return 0.0, err
+			}
+			tf += f
+		}
+		if tf <= 0 {
+			continue
+		}
+		weights = append(weights, (1+math.Log(tf))*math.Log(1+N/df))
+	}
+	if len(weights) < 2 {
+		return 0.0, nil
+	}
+	return stat.PopStdDev(weights, nil), nil
+}
@@ -0,0 +1,341 @@
+package probability
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/groove/analysis"
+	"io"
+	"math"
+	"os"
+	"sort"
+)
+
+// Method is the way the probability of an improvement in precision or recall is fitted.
+type Method string
+
+const (
+	// BinnedMLE fits a probability for each bin of measurement values, i.e. the (smoothed) proportion of the
+	// observations in the bin that improved.
+	BinnedMLE Method = "binned"
+	// LogisticRegression fits a logistic function of the measurement value.
+	LogisticRegression Method = "logistic"
+)
+
+// Estimate is a fitted probability that precision or recall improves, as a function of the value of a measurement.
+// For binned estimates, Edges are the (exclusive) upper bounds of every bin but the last, i.e. a value equal to an edge
+// belongs to the bin above it. For logistic estimates, the measurement
+// value is standardised using Mean and Deviation before the Intercept and Slope are applied.
+type Estimate struct {
+	Method        Method    `json:"method"`
+	Observations  int       `json:"observations"`
+	Edges         []float64 `json:"edges,omitempty"`
+	Probabilities []float64 `json:"probabilities,omitempty"`
+	Intercept     float64   `json:"intercept,omitempty"`
+	Slope         float64   `json:"slope,omitempty"`
+	Mean          float64   `json:"mean,omitempty"`
+	Deviation     float64   `json:"deviation,omitempty"`
+}
+
+// Probability is the probability of an improvement for a measurement value.
+func (e Estimate) Probability(m float64) float64 {
+	switch e.Method {
+	case BinnedMLE:
+		if len(e.Probabilities) == 0 {
+			return 0.5
+		}
+		return e.Probabilities[sort.Search(len(e.Edges), func(j int) bool { return e.Edges[j] > m })]
+	case LogisticRegression:
+		x := 0.0
+		if e.Deviation > 0 {
+			x = (m - e.Mean) / e.Deviation
+		}
+		return sigmoid(e.Intercept + e.Slope*x)
+	}
+	return 0.5
+}
+
+// validate checks that every value of a binned estimate is in a bin with a probability, i.e. there is one more
+// probability than there are edges, and the edges are in increasing order.
+func (e Estimate) validate() error {
+	if e.Method != BinnedMLE {
+		return nil
+	}
+	if len(e.Probabilities) != len(e.Edges)+1 {
+		return fmt.Errorf("binned estimate has %d probabilities for %d edges", len(e.Probabilities), len(e.Edges))
+	}
+	for i := 1; i < len(e.Edges); i++ {
+		if e.Edges[i] <= e.Edges[i-1] {
+			return fmt.Errorf("binned estimate has edges out of order")
+		}
+	}
+	return nil
+}
+
+// Model is a Probability learned from observations of how changing a measurement affected precision and recall. Each
+// probability is the probability that precision (or recall) improves when a query with a measurement value is
+// rewritten to increase (addition) or decrease (reduction) the measurement.
+type Model struct {
+	Measurement        string   `json:"measurement"`
+	AdditionPrecision  Estimate `json:"addition_precision"`
+	AdditionRecall     Estimate `json:"addition_recall"`
+	ReductionPrecision Estimate `json:"reduction_precision"`
+	ReductionRecall    Estimate `json:"reduction_recall"`
+}
+
+// ComputeAdditionProbability predicts how increasing the measurement affects precision and recall.
+func (m Model) ComputeAdditionProbability(v float64) PredictionPair {
+	return NewPredictionPair(m.AdditionPrecision.Probability(v), m.AdditionRecall.Probability(v))
+}
+
+// ComputeReductionProbability predicts how decreasing the measurement affects precision and recall.
+func (m Model) ComputeReductionProbability(v float64) PredictionPair {
+	return NewPredictionPair(m.ReductionPrecision.Probability(v), m.ReductionRecall.Probability(v))
+}
+
+// NewLearntMeasurement creates a ProbabilisticMeasurement from a measurement and the model learned for it.
+func NewLearntMeasurement(measurement analysis.Measurement, model Model) (ProbabilisticMeasurement, error) {
+	if measurement.Name() != model.Measurement {
+		return ProbabilisticMeasurement{}, fmt.Errorf("the model was learned for %s, not %s", model.Measurement, measurement.Name())
+	}
+	return ProbabilisticMeasurement{
+		Measurement: measurement,
+		Probability: model,
+	}, nil
+}
+
+// Fitter fits models from observations.
+type Fitter struct {
+	method     Method
+	bins       int
+	smoothing  float64
+	iterations int
+}
+
+// FitMethod is the way probabilities are fitted (default BinnedMLE).
+func FitMethod(method Method) func(f *Fitter) {
+	return func(f *Fitter) {
+		f.method = method
+	}
+}
+
+// FitBins is the maximum number of bins of a binned estimate (default 10). Bins are quantiles of the measurement
+// values, so each bin has about the same number of observations.
+func FitBins(n int) func(f *Fitter) {
+	return func(f *Fitter) {
+		f.bins = n
+	}
+}
+
+// FitSmoothing is the additive (Laplace) smoothing of binned estimates, and the L2 regularisation of logistic
+// estimates (default 1).
+func FitSmoothing(alpha float64) func(f *Fitter) {
+	return func(f *Fitter) {
+		f.smoothing = alpha
+	}
+}
+
+// NewFitter creates a new fitter.
+func NewFitter(options ...func(f *Fitter)) Fitter {
+	f := Fitter{
+		method:     BinnedMLE,
+		bins:       10,
+		smoothing:  1,
+		iterations: 50,
+	}
+	for _, option := range options {
+		option(&f)
+	}
+	return f
+}
+
+// Fit learns a model for a measurement. Observations that increased the measurement are used to fit the addition
+// probabilities, observations that decreased it are used to fit the reduction probabilities, and observations that did
+// not change it are ignored.
+func (f Fitter) Fit(measurement string, observations []Observation) (Model, error) {
+	var addition, reduction []Observation
+	for _, o := range observations {
+		switch {
+		case o.Change > 0:
+			addition = append(addition, o)
+		case o.Change < 0:
+			reduction = append(reduction, o)
+		}
+	}
+
+	m := Model{Measurement: measurement}
+	var err error
+	precision := func(o Observation) bool { return o.Precision > 0 }
+	recall := func(o Observation) bool { return o.Recall > 0 }
+	if m.AdditionPrecision, err = f.estimate(addition, precision); err != nil {
+		return Model{}, err
+	}
+	if m.AdditionRecall, err = f.estimate(addition, recall); err != nil {
+		return Model{}, err
+	}
+	if m.ReductionPrecision, err = f.estimate(reduction, precision); err != nil {
+		return Model{}, err
+	}
+	if m.ReductionRecall, err = f.estimate(reduction, recall); err != nil {
+		return Model{}, err
+	}
+	return m, nil
+}
+
+func (f Fitter) estimate(observations []Observation, improved func(o Observation) bool) (Estimate, error) {
+	x := make([]float64, len(observations))
+	y := make([]bool, len(observations))
+	for i, o := range observations {
+		x[i], y[i] = o.Value, improved(o)
+	}
+	switch f.method {
+	case BinnedMLE:
+		return f.binned(x, y), nil
+	case LogisticRegression:
+		return f.logistic(x, y), nil
+	}
+	return Estimate{}, fmt.Errorf("unknown fitting method %s", f.method)
+}
+
+func (f Fitter) binned(x []float64, y []bool) Estimate {
+	e := Estimate{Method: BinnedMLE, Observations: len(x)}
+	sorted := append([]float64{}, x...)
+	sort.Float64s(sorted)
+
+	// The edges are quantiles of the values, without duplicates, so that equal values are always in the same bin.
+	for i := 1; i < f.bins && len(sorted) > 0; i++ {
+		edge := sorted[i*len(sorted)/f.bins]
+		if edge == sorted[0] || (len(e.Edges) > 0 && edge == e.Edges[len(e.Edges)-1]) {
+			continue
+		}
+		e.Edges = append(e.Edges, edge)
+	}
+
+	improved := make([]float64, len(e.Edges)+1)
+	total := make([]float64, len(e.Edges)+1)
+	for i, v := range x {
+		// A value equal to an edge belongs to the bin above it.
+		b := sort.Search(len(e.Edges), func(j int) bool { return e.Edges[j] > v })
+		total[b]++
+		if y[i] {
+			improved[b]++
+		}
+	}
+	e.Probabilities = make([]float64, len(total))
+	for i := range total {
+		if total[i]+2*f.smoothing == 0 {
+			e.Probabilities[i] = 0.5
+			continue
+		}
+		e.Probabilities[i] = (improved[i] + f.smoothing) / (total[i] + 2*f.smoothing)
+	}
+	return e
+}
+
+// logistic fits a logistic regression of a single standardised variable using Newton's method.
+func (f Fitter) logistic(x []float64, y []bool) Estimate {
+	e := Estimate{Method: LogisticRegression, Observations: len(x)}
+	if len(x) == 0 {
+		return e
+	}
+	for _, v := range x {
+		e.Mean += v
+	}
+	e.Mean /= float64(len(x))
+	for _, v := range x {
+		e.Deviation += (v - e.Mean) * (v - e.Mean)
+	}
+	e.Deviation = math.Sqrt(e.Deviation / float64(len(x)))
+
+	z := make([]float64, len(x))
+	if e.Deviation > 0 {
+		for i, v := range x {
+			z[i] = (v - e.Mean) / e.Deviation
+		}
+	}
+
+	var b0, b1 float64
+	for it := 0; it < f.iterations; it++ {
+		// The gradient and Hessian of the penalised log-likelihood. The intercept is not penalised.
+		g0, g1 := 0.0, -f.smoothing*b1
+		h00, h01, h11 := 0.0, 0.0, f.smoothing
+		for i := range z {
+			p := sigmoid(b0 + b1*z[i])
+			t := 0.0
+			if y[i] {
+				t = 1
+			}
+			g0 += t - p
+			g1 += (t - p) * z[i]
+			w := p * (1 - p)
+			h00 += w
+			h01 += w * z[i]
+			h11 += w * z[i] * z[i]
+		}
+		det := h00*h11 - h01*h01
+		if det <= 1e-12 {
+			break
+		}
+		d0 := (h11*g0 - h01*g1) / det
+		d1 := (h00*g1 - h01*g0) / det
+		b0, b1 = b0+d0, b1+d1
+		if math.Abs(d0)+math.Abs(d1) < 1e-9 {
+			break
+		}
+	}
+	e.Intercept, e.Slope = b0, b1
+	return e
+}
+
+func sigmoid(x float64) float64 {
+	return 1 / (1 + math.Exp(-x))
+}
+
+// WriteModel writes a model in a JSON format.
+func WriteModel(w io.Writer, m Model) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(m)
+}
+
+// ReadModel reads a model written by WriteModel. Binned estimates that do not have a probability for every bin are an
+// error.
+func ReadModel(r io.Reader) (Model, error) {
+	var m Model
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return Model{}, err
+	}
+	for name, e := range map[string]Estimate{
+		"addition_precision":  m.AdditionPrecision,
+		"addition_recall":     m.AdditionRecall,
+		"reduction_precision": m.ReductionPrecision,
+		"reduction_recall":    m.ReductionRecall,
+	} {
+		if err := e.validate(); err != nil {
+			return Model{}, fmt.Errorf("invalid model for %s: %s: %v", m.Measurement, name, err)
+		}
+	}
+	return m, nil
+}
+
+// SaveModel saves a model to a file.
+func SaveModel(file string, m Model) error {
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	if err := WriteModel(f, m); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// LoadModel loads a model saved by SaveModel.
+func LoadModel(file string) (Model, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return Model{}, err
+	}
+	defer f.Close()
+	return ReadModel(f)
+}
@@ -0,0 +1,94 @@
+package probability_test
+
+import (
+	"bytes"
+	"github.com/hscells/groove/analysis/probability"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// observations where adding to queries with a low measurement improves recall, and adding to queries with a high
+// measurement never improves it; removing from queries always improves precision.
+func observations() []probability.Observation {
+	var obs []probability.Observation
+	for i := 0; i < 100; i++ {
+		v := float64(i % 10)
+		recall := 0.0
+		if v < 5 {
+			recall = 0.1
+		}
+		obs = append(obs,
+			probability.Observation{Topic: "1", Value: v, Change: 1, Recall: recall},
+			probability.Observation{Topic: "1", Value: v, Change: -1, Precision: 0.1},
+			probability.Observation{Topic: "1", Value: v, Change: 0, Precision: -1, Recall: -1})
+	}
+	return obs
+}
+
+func TestFit(t *testing.T) {
+	for _, method := range []probability.Method{probability.BinnedMLE, probability.LogisticRegression} {
+		m, err := probability.NewFitter(probability.FitMethod(method)).Fit("test", observations())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.AdditionRecall.Observations != 100 || m.ReductionPrecision.Observations != 100 {
+			t.Errorf("%s: unchanged measurements should not be observed", method)
+		}
+
+		low, high := m.ComputeAdditionProbability(1), m.ComputeAdditionProbability(8)
+		if low.RecallProbability < 0.9 || high.RecallProbability > 0.1 {
+			t.Errorf("%s: expected recall to improve for low measurements only, got %f and %f", method, low.RecallProbability, high.RecallProbability)
+		}
+		if low.PrecisionProbability > 0.1 {
+			t.Errorf("%s: expected precision not to improve, got %f", method, low.PrecisionProbability)
+		}
+		if p := m.ComputeReductionProbability(5).PrecisionProbability; p < 0.9 {
+			t.Errorf("%s: expected precision to improve, got %f", method, p)
+		}
+		// 5 is an edge of the bins, and it is in the same bin as the observations of 5 (which never improve recall).
+		if p := m.ComputeAdditionProbability(5).RecallProbability; method == probability.BinnedMLE && p > 0.1 {
+			t.Errorf("%s: expected recall not to improve on an edge, got %f", method, p)
+		}
+
+		var b bytes.Buffer
+		if err := probability.WriteModel(&b, m); err != nil {
+			t.Fatal(err)
+		}
+		loaded, err := probability.ReadModel(&b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(m, loaded) {
+			t.Errorf("%s: expected the loaded model to equal the saved model", method)
+		}
+	}
+}
+
+func TestReadModel(t *testing.T) {
+	for _, model := range []string{
+		// There must be a probability for the bin above the last edge.
+		`{"measurement": "test", "addition_recall": {"method": "binned", "edges": [1, 2], "probabilities": [0.1, 0.2]}}`,
+		`{"measurement": "test", "reduction_precision": {"method": "binned", "edges": [1]}}`,
+		`{"measurement": "test", "addition_precision": {"method": "binned", "edges": [2, 1], "probabilities": [0.1, 0.2, 0.3]}}`,
+	} {
+		if _, err := probability.ReadModel(strings.NewReader(model)); err == nil {
+			t.Errorf("expected an error reading the invalid model %s", model)
+		}
+	}
+}
+
+func TestObservations(t *testing.T) {
+	obs := observations()
+	var b bytes.Buffer
+	if err := probability.WriteObservations(&b, obs); err != nil {
+		t.Fatal(err)
+	}
+	read, err := probability.ReadObservations(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(obs, read) {
+		t.Error("expected the read observations to equal the written observations")
+	}
+}
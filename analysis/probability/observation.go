@@ -0,0 +1,192 @@
+package probability
+
+import (
+	"encoding/csv"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/learning"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"io"
+	"strconv"
+)
+
+// Observation is how a single rewrite of a query changed a measurement, and how it changed precision and recall. Value
+// is the measurement of the query before it was rewritten, and Change, Precision, and Recall are the differences between
+// the rewritten query and the query before it was rewritten.
+type Observation struct {
+	Topic     string
+	Value     float64
+	Change    float64
+	Precision float64
+	Recall    float64
+}
+
+type effectiveness struct {
+	measurement, precision, recall float64
+}
+
+// ObserveChains observes how the measurement, precision, and recall of the query each candidate was derived from (the
+// last query in its chain) changed when it was rewritten into the candidate. Candidates without a chain are skipped.
+// Queries are retrieved using the statistics source, and the documents of atoms are read from (and added to) the cache.
+func ObserveChains(measurement analysis.Measurement, candidates []learning.CandidateQuery, ss stats.StatisticsSource, cache combinator.QueryCacher, qrels trecresults.QrelsFile) ([]Observation, error) {
+	seen := make(map[string]effectiveness)
+	evaluate := func(topic string, q cqr.CommonQueryRepresentation) (effectiveness, error) {
+		key := topic + q.String()
+		if e, ok := seen[key]; ok {
+			return e, nil
+		}
+		pq := pipeline.NewQuery(topic, topic, q)
+		m, err := measurement.Execute(pq, ss)
+		if err != nil {
+			return effectiveness{}, err
+		}
+		tree, _, err := combinator.NewLogicalTree(pq, ss, cache)
+		if err != nil {
+			return effectiveness{}, err
+		}
+		docs, err := tree.Documents(cache)
+		if err != nil {
+			return effectiveness{}, err
+		}
+		rel := combinator.RelevantDocuments(qrels.Qrels[topic])
+		e := effectiveness{measurement: m}
+		if docs.Len() > 0 {
+			e.precision = float64(docs.Intersect(rel).Len()) / float64(docs.Len())
+		}
+		if rel.Len() > 0 {
+			e.recall = float64(docs.Intersect(rel).Len()) / float64(rel.Len())
+		}
+		seen[key] = e
+		return e, nil
+	}
+
+	var observations []Observation
+	for _, candidate := range candidates {
+		if len(candidate.Chain) == 0 {
+			continue
+		}
+		parent := candidate.Chain[len(candidate.Chain)-1]
+		pre, err := evaluate(candidate.Topic, parent.Query)
+		if err != nil {
+			return nil, err
+		}
+		post, err := evaluate(candidate.Topic, candidate.Query)
+		if err != nil {
+			return nil, err
+		}
+		observations = append(observations, Observation{
+			Topic:     candidate.Topic,
+			Value:     pre.measurement,
+			Change:    post.measurement - pre.measurement,
+			Precision: post.precision - pre.precision,
+			Recall:    post.recall - pre.recall,
+		})
+	}
+	return observations, nil
+}
+
+// Baseline is the precision and recall of the query that candidates of a topic were derived from.
+type Baseline struct {
+	Precision float64
+	Recall    float64
+}
+
+// ObserveFeatures observes how a measurement changed from the features of generated candidates (i.e. the output of
+// learning.QueryChain.Generate). The measurement before the rewrite is recovered from the value and delta features of
+// the measurement, and precision and recall are read from the scores at the precision and recall indices (i.e. the order
+// of the evaluators used to generate the candidates). Generated candidates do not record the precision and recall of
+// the query they were derived from, so they are instead compared to the baseline of their topic; this is exact for
+// candidates that are a single transformation of the query the baseline was computed for. Candidates whose topic has no
+// baseline are skipped.
+func ObserveFeatures(measurement string, features []learning.LearntFeature, precision, recall int, baselines map[string]Baseline) ([]Observation, error) {
+	id, ok := learning.MeasurementFeatureKey(measurement)
+	if !ok {
+		return nil, fmt.Errorf("%s is not registered as a feature in MeasurementFeatureKeys", measurement)
+	}
+	delta := id + len(learning.MeasurementFeatureKeys)
+
+	var observations []Observation
+	for _, lf := range features {
+		baseline, ok := baselines[lf.Topic]
+		if !ok {
+			continue
+		}
+		if precision >= len(lf.Scores) || recall >= len(lf.Scores) {
+			return nil, fmt.Errorf("candidate %s of topic %s has only %d scores", lf.Comment, lf.Topic, len(lf.Scores))
+		}
+		var post, d float64
+		for _, f := range lf.Features {
+			switch f.ID {
+			case id:
+				post = f.Score
+			case delta:
+				d = f.Score
+			}
+		}
+		// Deltas are the measurement before the rewrite minus the measurement after it.
+		observations = append(observations, Observation{
+			Topic:     lf.Topic,
+			Value:     post + d,
+			Change:    -d,
+			Precision: lf.Scores[precision] - baseline.Precision,
+			Recall:    lf.Scores[recall] - baseline.Recall,
+		})
+	}
+	return observations, nil
+}
+
+var observationHeader = []string{"topic", "value", "change", "precision", "recall"}
+
+// WriteObservations writes observations as CSV, so they can be collected once and fitted many times.
+func WriteObservations(w io.Writer, observations []Observation) error {
+	c := csv.NewWriter(w)
+	if err := c.Write(observationHeader); err != nil {
+		return err
+	}
+	for _, o := range observations {
+		record := []string{o.Topic}
+		for _, v := range []float64{o.Value, o.Change, o.Precision, o.Recall} {
+			record = append(record, strconv.FormatFloat(v, 'g', -1, 64))
+		}
+		if err := c.Write(record); err != nil {
+			return err
+		}
+	}
+	c.Flush()
+	return c.Error()
+}
+
+// ReadObservations reads observations written by WriteObservations.
+func ReadObservations(r io.Reader) ([]Observation, error) {
+	c := csv.NewReader(r)
+	c.FieldsPerRecord = len(observationHeader)
+	records, err := c.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	observations := make([]Observation, 0, len(records)-1)
+	for i, record := range records[1:] {
+		var v [4]float64
+		for j := range v {
+			v[j], err = strconv.ParseFloat(record[j+1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", i+2, err)
+			}
+		}
+		observations = append(observations, Observation{
+			Topic:     record[0],
+			Value:     v[0],
+			Change:    v[1],
+			Precision: v[2],
+			Recall:    v[3],
+		})
+	}
+	return observations, nil
+}
@@ -0,0 +1,306 @@
+// Package qppeval evaluates how well query performance predictors predict the effectiveness of queries. The values
+// of predictors (i.e. the measurement output of a pipeline) are joined with the evaluation scores of the same topics,
+// and every predictor is correlated with every evaluation measure. Predictors are also evaluated by how well they
+// separate hard topics from the rest.
+package qppeval
+
+import (
+	"fmt"
+	"gonum.org/v1/gonum/stat"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Options configure how predictors are evaluated.
+type Options struct {
+	// Samples is the number of bootstrap samples used to compute confidence intervals. No intervals are computed when
+	// it is zero.
+	Samples int
+	// Confidence is the level of the confidence intervals (e.g. 0.95).
+	Confidence float64
+	// Hard is the proportion of topics, with the lowest evaluation scores, that are considered hard.
+	Hard float64
+	// Seed seeds the random resampling of topics.
+	Seed int64
+}
+
+// BootstrapSamples sets the number of bootstrap samples (1000 by default).
+func BootstrapSamples(n int) func(o *Options) {
+	return func(o *Options) {
+		o.Samples = n
+	}
+}
+
+// ConfidenceLevel sets the level of the confidence intervals (0.95 by default).
+func ConfidenceLevel(level float64) func(o *Options) {
+	return func(o *Options) {
+		o.Confidence = level
+	}
+}
+
+// HardTopics sets the proportion of topics that are considered hard (0.25 by default).
+func HardTopics(proportion float64) func(o *Options) {
+	return func(o *Options) {
+		o.Hard = proportion
+	}
+}
+
+// Seed sets the seed of the bootstrap resampling, so that confidence intervals are reproducible (1 by default).
+func Seed(seed int64) func(o *Options) {
+	return func(o *Options) {
+		o.Seed = seed
+	}
+}
+
+// Coefficient is a correlation coefficient, along with its bootstrap confidence interval.
+type Coefficient struct {
+	Value float64 `json:"value"`
+	Lower float64 `json:"lower"`
+	Upper float64 `json:"upper"`
+}
+
+// Result is the evaluation of a predictor against an evaluation measure, over the topics both were computed for.
+//
+// sMARE is the scaled mean absolute rank error of the predictor (lower is better), and AUC is the probability that the
+// predictor scores a hard topic lower than a topic that is not hard (0.5 is no better than random).
+type Result struct {
+	Predictor string      `json:"predictor"`
+	Measure   string      `json:"measure"`
+	Topics    int         `json:"topics"`
+	Pearson   Coefficient `json:"pearson"`
+	Spearman  Coefficient `json:"spearman"`
+	Kendall   Coefficient `json:"kendall"`
+	SMARE     float64     `json:"smare"`
+	AUC       float64     `json:"auc"`
+}
+
+// join pairs the value of a predictor with the score of an evaluation measure for every topic that has both, in the
+// order of the topics.
+func join(predictions, evaluations map[string]map[string]float64, predictor, measure string) ([]float64, []float64) {
+	var topics []string
+	for topic, p := range predictions {
+		if _, ok := p[predictor]; !ok {
+			continue
+		}
+		if e, ok := evaluations[topic]; ok {
+			if _, ok := e[measure]; ok {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	sort.Strings(topics)
+	x, y := make([]float64, len(topics)), make([]float64, len(topics))
+	for i, topic := range topics {
+		x[i] = predictions[topic][predictor]
+		y[i] = evaluations[topic][measure]
+	}
+	return x, y
+}
+
+// names lists the names of the values of every topic.
+func names(values map[string]map[string]float64) []string {
+	seen := make(map[string]bool)
+	var n []string
+	for _, v := range values {
+		for name := range v {
+			if !seen[name] {
+				seen[name] = true
+				n = append(n, name)
+			}
+		}
+	}
+	sort.Strings(n)
+	return n
+}
+
+// Evaluate correlates every predictor with every evaluation measure. Predictions and evaluations map a topic to the
+// values of each predictor or measure for that topic, which is the format of the JSON measurement and evaluation
+// output of a pipeline. Only the topics that have both a prediction and an evaluation score are used. Correlations
+// that are undefined (e.g. for a predictor with the same value for every topic) are zero, and so is the AUC when there
+// are too few topics to separate the hard topics from the rest.
+func Evaluate(predictions, evaluations map[string]map[string]float64, options ...func(o *Options)) ([]Result, error) {
+	o := Options{
+		Samples:    1000,
+		Confidence: 0.95,
+		Hard:       0.25,
+		Seed:       1,
+	}
+	for _, option := range options {
+		option(&o)
+	}
+	if o.Confidence <= 0 || o.Confidence >= 1 {
+		return nil, fmt.Errorf("confidence level must be between 0 and 1, got %f", o.Confidence)
+	}
+
+	var results []Result
+	for _, predictor := range names(predictions) {
+		for _, measure := range names(evaluations) {
+			x, y := join(predictions, evaluations, predictor, measure)
+			if len(x) == 0 {
+				continue
+			}
+			rng := rand.New(rand.NewSource(o.Seed))
+			results = append(results, Result{
+				Predictor: predictor,
+				Measure:   measure,
+				Topics:    len(x),
+				Pearson:   bootstrap(x, y, Pearson, o, rng),
+				Spearman:  bootstrap(x, y, Spearman, o, rng),
+				Kendall:   bootstrap(x, y, Kendall, o, rng),
+				SMARE:     SMARE(x, y),
+				AUC:       defined(AUC(x, y, o.Hard)),
+			})
+		}
+	}
+	return results, nil
+}
+
+// defined replaces an undefined value with zero, so that results can always be serialised.
+func defined(v float64) float64 {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0
+	}
+	return v
+}
+
+// bootstrap computes a correlation coefficient along with its percentile bootstrap confidence interval. The interval
+// is empty (i.e. the value itself) when no samples are taken.
+func bootstrap(x, y []float64, correlation func(x, y []float64) float64, o Options, rng *rand.Rand) Coefficient {
+	c := Coefficient{Value: defined(correlation(x, y))}
+	c.Lower, c.Upper = c.Value, c.Value
+	if o.Samples <= 0 || len(x) < 2 {
+		return c
+	}
+
+	n := len(x)
+	sx, sy := make([]float64, n), make([]float64, n)
+	var samples []float64
+	for i := 0; i < o.Samples; i++ {
+		for j := range sx {
+			k := rng.Intn(n)
+			sx[j], sy[j] = x[k], y[k]
+		}
+		if v := correlation(sx, sy); !math.IsNaN(v) {
+			samples = append(samples, v)
+		}
+	}
+	if len(samples) == 0 {
+		return c
+	}
+	sort.Float64s(samples)
+	alpha := (1 - o.Confidence) / 2
+	c.Lower = stat.Quantile(alpha, stat.Empirical, samples, nil)
+	c.Upper = stat.Quantile(1-alpha, stat.Empirical, samples, nil)
+	return c
+}
+
+// Pearson is the linear correlation of two lists of values.
+func Pearson(x, y []float64) float64 {
+	if len(x) < 2 {
+		return math.NaN()
+	}
+	return stat.Correlation(x, y, nil)
+}
+
+// ranks computes the rank of every value, where tied values share the average of their ranks. Ranks start at one.
+func ranks(x []float64) []float64 {
+	idx := make([]int, len(x))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return x[idx[i]] < x[idx[j]]
+	})
+	r := make([]float64, len(x))
+	for i := 0; i < len(idx); {
+		j := i
+		for j+1 < len(idx) && x[idx[j+1]] == x[idx[i]] {
+			j++
+		}
+		rank := float64(i+j)/2 + 1
+		for k := i; k <= j; k++ {
+			r[idx[k]] = rank
+		}
+		i = j + 1
+	}
+	return r
+}
+
+// Spearman is the rank correlation of two lists of values.
+func Spearman(x, y []float64) float64 {
+	return Pearson(ranks(x), ranks(y))
+}
+
+// Kendall is the tau-b rank correlation of two lists of values, which accounts for ties.
+func Kendall(x, y []float64) float64 {
+	var concordant, discordant, tiesX, tiesY float64
+	for i := 0; i < len(x); i++ {
+		for j := i + 1; j < len(x); j++ {
+			dx, dy := x[i]-x[j], y[i]-y[j]
+			switch {
+			case dx == 0 && dy == 0:
+			case dx == 0:
+				tiesX++
+			case dy == 0:
+				tiesY++
+			case (dx > 0) == (dy > 0):
+				concordant++
+			default:
+				discordant++
+			}
+		}
+	}
+	d := math.Sqrt((concordant + discordant + tiesX) * (concordant + discordant + tiesY))
+	if d == 0 {
+		return math.NaN()
+	}
+	return (concordant - discordant) / d
+}
+
+// SMARE is the scaled mean absolute rank error of a predictor: the mean difference between the rank of each topic by
+// its prediction and by its evaluation score, scaled by the number of topics. It is zero when the predictor ranks the
+// topics perfectly.
+func SMARE(predictions, evaluations []float64) float64 {
+	if len(predictions) == 0 {
+		return 0
+	}
+	rp, re := ranks(predictions), ranks(evaluations)
+	var sum float64
+	for i := range rp {
+		sum += math.Abs(rp[i] - re[i])
+	}
+	n := float64(len(predictions))
+	return sum / (n * n)
+}
+
+// AUC is the area under the ROC curve of a predictor used to classify the hard topics, which are the given proportion
+// of topics with the lowest evaluation scores. A lower prediction is assumed to mean a harder topic.
+func AUC(predictions, evaluations []float64, hard float64) float64 {
+	n := len(evaluations)
+	k := int(math.Round(hard * float64(n)))
+	if k <= 0 || k >= n {
+		return math.NaN()
+	}
+	idx := make([]int, n)
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return evaluations[idx[i]] < evaluations[idx[j]]
+	})
+
+	// The probability that a hard topic is predicted to be harder than a topic that is not, counting ties as half.
+	var correct float64
+	for _, h := range idx[:k] {
+		for _, e := range idx[k:] {
+			switch {
+			case predictions[h] < predictions[e]:
+				correct++
+			case predictions[h] == predictions[e]:
+				correct += 0.5
+			}
+		}
+	}
+	return correct / float64(k*(n-k))
+}
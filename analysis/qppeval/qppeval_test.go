@@ -0,0 +1,46 @@
+package qppeval_test
+
+import (
+	"github.com/hscells/groove/analysis/qppeval"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEvaluate(t *testing.T) {
+	predictions, err := qppeval.ReadCSV(strings.NewReader("Topic,good,bad\n1,0.1,4\n2,0.2,3\n3,0.3,2\n4,0.4,1\n5,0.5,1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	evaluations, err := qppeval.ReadJSON(strings.NewReader(`{"1": {"recall": 0.2}, "2": {"recall": 0.4}, "3": {"recall": 0.6}, "4": {"recall": 0.8}, "6": {"recall": 1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := qppeval.Evaluate(predictions, evaluations, qppeval.BootstrapSamples(200), qppeval.HardTopics(0.5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected a result for each predictor, got %d", len(results))
+	}
+
+	bad, good := results[0], results[1]
+	if good.Predictor != "good" || good.Topics != 4 {
+		t.Fatalf("expected the predictors to be joined on the four common topics, got %+v", good)
+	}
+	for name, c := range map[string]qppeval.Coefficient{"pearson": good.Pearson, "spearman": good.Spearman, "kendall": good.Kendall} {
+		if math.Abs(c.Value-1) > 1e-9 {
+			t.Errorf("expected a perfect %s correlation, got %f", name, c.Value)
+		}
+		if c.Lower > c.Value || c.Upper < c.Value {
+			t.Errorf("expected the %s confidence interval to contain the correlation, got %+v", name, c)
+		}
+	}
+	if good.SMARE != 0 || good.AUC != 1 {
+		t.Errorf("expected a perfect sMARE and AUC, got %f and %f", good.SMARE, good.AUC)
+	}
+	if math.Abs(bad.Kendall.Value+1) > 1e-9 || bad.AUC != 0 {
+		t.Errorf("expected the bad predictor to be inversely correlated, got %+v", bad)
+	}
+}
@@ -0,0 +1,46 @@
+package qppeval
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// ReadJSON reads the values of each topic from the JSON measurement or evaluation output of a pipeline.
+func ReadJSON(r io.Reader) (map[string]map[string]float64, error) {
+	var values map[string]map[string]float64
+	if err := json.NewDecoder(r).Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// ReadCSV reads the values of each topic from the CSV measurement output of a pipeline, where the first column is the
+// topic and the first row is the name of each measurement.
+func ReadCSV(r io.Reader) (map[string]map[string]float64, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string]map[string]float64)
+	if len(records) == 0 {
+		return values, nil
+	}
+	headers := records[0]
+	for _, record := range records[1:] {
+		if len(record) != len(headers) {
+			return nil, fmt.Errorf("expected %d columns for topic %s, got %d", len(headers), record[0], len(record))
+		}
+		values[record[0]] = make(map[string]float64)
+		for i := 1; i < len(record); i++ {
+			v, err := strconv.ParseFloat(record[i], 64)
+			if err != nil {
+				return nil, err
+			}
+			values[record[0]][headers[i]] = v
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,153 @@
+package analysis
+
+import (
+	"fmt"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// VectorMeasurement is a measurement that computes several named values from a single computation (e.g. the average,
+// sum and maximum of the same statistics). Execute should return the first value, so that a vector measurement can
+// still be used where a single value is expected.
+type VectorMeasurement interface {
+	Measurement
+	// Names are the names of each of the values of the measurement. They should not contain any spaces.
+	Names() []string
+	// ExecuteVector computes every value of the measurement, in the order of Names.
+	ExecuteVector(q pipeline.Query, s stats.StatisticsSource) ([]float64, error)
+}
+
+// MeasurementNames are the names of the values computed by measurements. A vector measurement has a name for each of
+// its values, and any other measurement has a single name.
+func MeasurementNames(measurements ...Measurement) []string {
+	var names []string
+	for _, m := range measurements {
+		if v, ok := m.(VectorMeasurement); ok {
+			names = append(names, v.Names()...)
+			continue
+		}
+		names = append(names, m.Name())
+	}
+	return names
+}
+
+// ExecuteMeasurement computes the values of a measurement, which is a single value unless it is a vector measurement.
+func ExecuteMeasurement(m Measurement, q pipeline.Query, s stats.StatisticsSource) ([]float64, error) {
+	if v, ok := m.(VectorMeasurement); ok {
+		values, err := v.ExecuteVector(q, s)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != len(v.Names()) {
+			return nil, fmt.Errorf("%s computed %d values for %d names", m.Name(), len(values), len(v.Names()))
+		}
+		return values, nil
+	}
+	value, err := m.Execute(q, s)
+	if err != nil {
+		return nil, err
+	}
+	return []float64{value}, nil
+}
+
+// ParameterisedName is the name of a measurement along with its explicit parameters, e.g. `ClarityScore[lambda=0.5]`.
+// The parameters are sorted, so that the name is the same however the parameters were specified.
+func ParameterisedName(name string, parameters map[string]string) string {
+	if len(parameters) == 0 {
+		return name
+	}
+	keys := make([]string, 0, len(parameters))
+	for k := range parameters {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	p := make([]string, len(keys))
+	for i, k := range keys {
+		p[i] = k + "=" + parameters[k]
+	}
+	return name + "[" + strings.Join(p, ",") + "]"
+}
+
+// Parameterised is a measurement with explicit parameters. The parameters take precedence over the Parameters of the
+// statistics source the measurement is executed with (e.g. the `lambda` of the clarity score, or the `k` of many
+// post-retrieval predictors), so several instances of a measurement can be computed with different parameters. The
+// parameters are part of the name of the measurement, so that each instance is output and cached separately. The
+// measurement is executed with a wrapper around the statistics source, so it should not depend on the concrete type of
+// the statistics source.
+type Parameterised struct {
+	Measurement Measurement
+	Parameters  map[string]float64
+}
+
+// Parameterise creates a measurement with explicit parameters.
+func Parameterise(m Measurement, parameters map[string]float64) Parameterised {
+	return Parameterised{Measurement: m, Parameters: parameters}
+}
+
+func (p Parameterised) name(name string) string {
+	parameters := make(map[string]string, len(p.Parameters))
+	for k, v := range p.Parameters {
+		parameters[k] = strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return ParameterisedName(name, parameters)
+}
+
+// Name is the name of the measurement, along with its parameters.
+func (p Parameterised) Name() string {
+	return p.name(p.Measurement.Name())
+}
+
+// Names are the names of the values of the measurement, along with its parameters.
+func (p Parameterised) Names() []string {
+	names := MeasurementNames(p.Measurement)
+	for i, name := range names {
+		names[i] = p.name(name)
+	}
+	return names
+}
+
+// Execute computes the measurement with its parameters.
+func (p Parameterised) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	return p.Measurement.Execute(q, p.source(s))
+}
+
+// ExecuteVector computes every value of the measurement with its parameters.
+func (p Parameterised) ExecuteVector(q pipeline.Query, s stats.StatisticsSource) ([]float64, error) {
+	return ExecuteMeasurement(p.Measurement, q, p.source(s))
+}
+
+func (p Parameterised) source(s stats.StatisticsSource) stats.StatisticsSource {
+	parameters := make(map[string]float64)
+	for k, v := range s.Parameters() {
+		parameters[k] = v
+	}
+	for k, v := range p.Parameters {
+		parameters[k] = v
+	}
+	return parameterisedSource{StatisticsSource: s, parameters: parameters}
+}
+
+// parameterisedSource is a statistics source with overridden parameters. The optional interfaces of the underlying
+// statistics source are found by unwrapping it.
+type parameterisedSource struct {
+	stats.StatisticsSource
+	parameters map[string]float64
+}
+
+func (p parameterisedSource) Parameters() map[string]float64 {
+	return p.parameters
+}
+
+// Unwrap is the underlying statistics source.
+func (p parameterisedSource) Unwrap() stats.StatisticsSource {
+	return p.StatisticsSource
+}
+
+// Namespace is the namespace of the underlying statistics source, as parameters do not change the documents it
+// retrieves.
+func (p parameterisedSource) Namespace() string {
+	return stats.Namespace(p.StatisticsSource)
+}
@@ -0,0 +1,62 @@
+package analysis_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"reflect"
+	"testing"
+)
+
+type countingVector struct {
+	calls *int
+}
+
+func (countingVector) Name() string {
+	return "Counting"
+}
+
+func (countingVector) Names() []string {
+	return []string{"CountingA", "CountingB"}
+}
+
+func (c countingVector) Execute(q pipeline.Query, s stats.StatisticsSource) (float64, error) {
+	v, err := c.ExecuteVector(q, s)
+	return v[0], err
+}
+
+func (c countingVector) ExecuteVector(q pipeline.Query, s stats.StatisticsSource) ([]float64, error) {
+	*c.calls++
+	return []float64{1, 2}, nil
+}
+
+func TestVectorMeasurement(t *testing.T) {
+	calls := 0
+	v := countingVector{calls: &calls}
+	measurements := []analysis.Measurement{analysis.BooleanKeywords, v}
+
+	if names := analysis.MeasurementNames(measurements...); !reflect.DeepEqual(names, []string{analysis.BooleanKeywords.Name(), "CountingA", "CountingB"}) {
+		t.Errorf("expected vector measurements to have a name for each value, got %v", names)
+	}
+
+	q := pipeline.NewQuery("1", "1", cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{cqr.NewKeyword("a", "title"), cqr.NewKeyword("b", "title")}))
+	me := analysis.NewMemoryMeasurementExecutor()
+	for i := 0; i < 2; i++ {
+		values, err := me.Execute(q, nil, measurements...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(values, []float64{2, 1, 2}) {
+			t.Errorf("expected the values of vector measurements to be flattened, got %v", values)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("expected the vector measurement to be computed once and then cached, got %d computations", calls)
+	}
+
+	p := analysis.Parameterise(v, map[string]float64{"lambda": 0.5, "k": 10})
+	if names := p.Names(); !reflect.DeepEqual(names, []string{"CountingA[k=10,lambda=0.5]", "CountingB[k=10,lambda=0.5]"}) {
+		t.Errorf("expected parameters to be part of the names of the values, got %v", names)
+	}
+}
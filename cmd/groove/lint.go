@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/analysis/lint"
+	"github.com/hscells/groove/query"
+	"os"
+)
+
+type lintCmd struct {
+	Directory    string   `help:"Path to a directory of query files" arg:"required,positional"`
+	Format       string   `help:"Format of the query files; medline, pubmed or cqr" arg:"-f"`
+	Output       string   `help:"Output format; text or json" arg:"-o"`
+	Backend      string   `help:"Search engine the queries are for; only fields it supports are allowed (pubmed)" arg:"-b"`
+	Fields       []string `help:"Fields that are allowed, in addition to those of the backend" arg:"--fields"`
+	MeSH         string   `help:"Path to the MeSH trees file exploded headings are looked up in" arg:"--mesh"`
+	MeSHYear     int      `help:"Year of the MeSH tree exploded headings are looked up in, downloaded if needed" arg:"--mesh-year"`
+	MinStem      int      `help:"Shortest stem that may be truncated" arg:"--min-stem"`
+	Entrez       string   `help:"Path to a config file with an [entrez] section (email, tool, key) used to find broad exclusions" arg:"-e"`
+	MaxExclusion float64  `help:"Largest proportion of the collection a not clause may exclude" arg:"--max-exclusion"`
+	Ignore       []string `help:"Checks to disable" arg:"--ignore"`
+}
+
+func lintQueries(l *lintCmd) error {
+	var options []func(*lint.Linter)
+
+	var f []string
+	switch l.Backend {
+	case "":
+	case "pubmed":
+		f = append(f, lint.PubMedFields...)
+	default:
+		return fmt.Errorf("unknown backend %s", l.Backend)
+	}
+	f = append(f, l.Fields...)
+	if len(f) > 0 {
+		options = append(options, lint.LintFields(f...))
+	}
+
+	var mesh *analysis.MeSH
+	var err error
+	switch {
+	case len(l.MeSH) > 0:
+		mesh, err = analysis.LoadMeSH(l.MeSH)
+	case l.MeSHYear > 0:
+		var dir string
+		dir, err = cachePath("", "mesh")
+		if err != nil {
+			return err
+		}
+		mesh, err = analysis.LoadMeSHYear(l.MeSHYear, dir)
+	}
+	if err != nil {
+		return err
+	}
+	options = append(options, lint.LintMeSH(mesh))
+
+	if l.MinStem > 0 {
+		options = append(options, lint.LintMinStem(l.MinStem))
+	}
+	if len(l.Entrez) > 0 {
+		ss, err := entrezSource(l.Entrez)
+		if err != nil {
+			return err
+		}
+		options = append(options, lint.LintStatistics(ss))
+	}
+	if l.MaxExclusion > 0 {
+		options = append(options, lint.LintMaxExclusion(l.MaxExclusion))
+	}
+	options = append(options, lint.LintIgnore(l.Ignore...))
+
+	tp, err := transmutePipeline(l.Format)
+	if err != nil {
+		return err
+	}
+	queries, err := query.NewTransmuteQuerySource(tp).Load(l.Directory)
+	if err != nil {
+		return err
+	}
+
+	linter := lint.New(options...)
+	report := make(map[string][]lint.Diagnostic)
+	errors := 0
+	for _, q := range queries {
+		diagnostics, err := linter.Lint(q.Query)
+		if err != nil {
+			return fmt.Errorf("could not lint %s: %v", q.Topic, err)
+		}
+		for _, d := range diagnostics {
+			if d.Severity == lint.Error {
+				errors++
+			}
+		}
+		report[q.Topic] = diagnostics
+	}
+
+	switch l.Output {
+	case "", "text":
+		for _, q := range queries {
+			for _, d := range report[q.Topic] {
+				fmt.Printf("%s: %s\n", q.Topic, d)
+			}
+		}
+	case "json":
+		b, err := json.MarshalIndent(report, "", "    ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(b))
+	default:
+		return fmt.Errorf("unknown output format %s", l.Output)
+	}
+
+	if errors > 0 {
+		return fmt.Errorf("%d errors in %d queries", errors, len(queries))
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"github.com/alexflint/go-arg"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/remote"
+	"github.com/peterbourgon/diskv"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+)
+
+var (
+	name    = "groove"
+	version = "18.Oct.2026"
+	author  = "Harry Scells"
+)
+
+type cacheCmd struct {
+	Action    string        `help:"One of list, inspect, verify, prune, migrate, export or import" arg:"required,positional"`
+	Key       string        `help:"Key of the entry to inspect" arg:"positional"`
+	Path      string        `help:"Path to the file query cache" arg:"-p"`
+	MaxSize   int64         `help:"Maximum size of the cache in bytes" arg:"--max-size"`
+	TTL       time.Duration `help:"How long entries remain in the cache" arg:"--ttl"`
+	Namespace string        `help:"Namespace of the statistics source entries were retrieved from" arg:"-n"`
+	File      string        `help:"File to export to or import from (default stdout/stdin)" arg:"-f"`
+}
+
+type cacheServerCmd struct {
+	Addr           string        `help:"Address to listen on (default 127.0.0.1:8787)" arg:"-a"`
+	Token          string        `help:"Token clients must present to write to the caches (default $GROOVE_CACHE_TOKEN)" arg:"-t"`
+	Path           string        `help:"Path to the file query cache" arg:"-p"`
+	StatisticsPath string        `help:"Path to the measurement cache" arg:"-s"`
+	MaxSize        int64         `help:"Maximum size of each namespace of the query cache in bytes" arg:"--max-size"`
+	TTL            time.Duration `help:"How long entries remain in the query cache" arg:"--ttl"`
+}
+
+type args struct {
+	Cache       *cacheCmd       `arg:"subcommand:cache" help:"Manage the query cache"`
+	CacheServer *cacheServerCmd `arg:"subcommand:cache-server" help:"Serve the query and measurement caches over HTTP"`
+	Tree        *treeCmd        `arg:"subcommand:tree" help:"Output the annotated logical tree of a query"`
+	Lint        *lintCmd        `arg:"subcommand:lint" help:"Check a directory of queries for problems"`
+}
+
+func (args) Version() string {
+	return version
+}
+
+func (args) Description() string {
+	return fmt.Sprintf(`%s
+@ %s
+# %s`, name, author, version)
+}
+
+func main() {
+	var args args
+	p := arg.MustParse(&args)
+
+	switch {
+	case args.Cache != nil:
+		if err := cache(args.Cache); err != nil {
+			log.Fatalln(err)
+		}
+	case args.CacheServer != nil:
+		if err := cacheServer(args.CacheServer); err != nil {
+			log.Fatalln(err)
+		}
+	case args.Tree != nil:
+		if err := tree(args.Tree); err != nil {
+			log.Fatalln(err)
+		}
+	case args.Lint != nil:
+		if err := lintQueries(args.Lint); err != nil {
+			log.Fatalln(err)
+		}
+	default:
+		p.Fail("missing subcommand")
+	}
+}
+
+// cachePath is the path of a cache in the user cache directory, unless one is specified.
+func cachePath(p, name string) (string, error) {
+	if len(p) > 0 {
+		return p, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(dir, "groove", name), nil
+}
+
+func cacheServer(c *cacheServerCmd) error {
+	queryPath, err := cachePath(c.Path, "file_cache")
+	if err != nil {
+		return err
+	}
+	statisticsPath, err := cachePath(c.StatisticsPath, "statistics_cache")
+	if err != nil {
+		return err
+	}
+	if len(c.Addr) == 0 {
+		c.Addr = "127.0.0.1:8787"
+	}
+	if len(c.Token) == 0 {
+		c.Token = os.Getenv(remote.TokenEnv)
+	}
+
+	// Each namespace is stored in its own directory, so that each can be bounded and pruned separately.
+	queries := func(namespace string) combinator.QueryCacher {
+		p := queryPath
+		if len(namespace) > 0 {
+			p = path.Join(queryPath, combinator.CacheKey(nil, namespace))
+		}
+		return combinator.NewFileQueryCache(p,
+			combinator.CacheMaxSize(c.MaxSize),
+			combinator.CacheTTL(c.TTL),
+			combinator.CacheNamespace(namespace))
+	}
+	measurements := diskv.New(diskv.Options{
+		BasePath:     statisticsPath,
+		Transform:    combinator.BlockTransform(8),
+		CacheSizeMax: 4096 * 1024,
+		Compression:  diskv.NewGzipCompression(),
+	})
+
+	if len(c.Token) == 0 {
+		log.Println("no token was provided, so the caches are read-only")
+	}
+	log.Printf("serving %s and %s on %s\n", queryPath, statisticsPath, c.Addr)
+	return http.ListenAndServe(c.Addr, remote.NewServer(queries, measurements, remote.ServerToken(c.Token)))
+}
+
+func cache(c *cacheCmd) error {
+	var err error
+	c.Path, err = cachePath(c.Path, "file_cache")
+	if err != nil {
+		return err
+	}
+
+	qc := combinator.NewFileQueryCache(c.Path,
+		combinator.CacheMaxSize(c.MaxSize),
+		combinator.CacheTTL(c.TTL),
+		combinator.CacheNamespace(c.Namespace)).(combinator.InspectableQueryCacher)
+
+	switch c.Action {
+	case "list":
+		entries, err := qc.Entries()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY\tNAMESPACE\tCOUNT\tSIZE\tCREATED\tACCESSED\tQUERY")
+		for _, e := range entries {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\t%s\t%s\n", e.Key, e.Namespace, e.Count, e.Size,
+				e.Created.Format(time.RFC3339), e.Accessed.Format(time.RFC3339), e.Query)
+		}
+		return w.Flush()
+	case "inspect":
+		if len(c.Key) == 0 {
+			return fmt.Errorf("inspect requires a key")
+		}
+		e, docs, err := qc.Entry(c.Key)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("key:       %s\nquery:     %s\nnamespace: %s\ncreated:   %s\naccessed:  %s\nsize:      %d\ncount:     %d\n",
+			e.Key, e.Query, e.Namespace, e.Created.Format(time.RFC3339), e.Accessed.Format(time.RFC3339), e.Size, e.Count)
+		fmt.Printf("documents: %v\n", docs)
+		return nil
+	case "verify":
+		failed, err := combinator.VerifyCache(qc)
+		if err != nil {
+			return err
+		}
+		for key, err := range failed {
+			fmt.Printf("%s\t%v\n", key, err)
+		}
+		if len(failed) > 0 {
+			return fmt.Errorf("%d entries failed verification", len(failed))
+		}
+		return nil
+	case "prune":
+		n, err := qc.Prune()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("pruned %d entries\n", n)
+		return nil
+	case "migrate":
+		n, err := qc.Migrate()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("migrated %d entries\n", n)
+		return nil
+	case "export":
+		w := os.Stdout
+		if len(c.File) > 0 {
+			f, err := os.Create(c.File)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			w = f
+		}
+		return combinator.ExportCache(w, qc)
+	case "import":
+		r := os.Stdin
+		if len(c.File) > 0 {
+			f, err := os.Open(c.File)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			r = f
+		}
+		n, err := combinator.ImportCache(r, qc)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("imported %d entries\n", n)
+		return nil
+	default:
+		return fmt.Errorf("unknown cache action %s", c.Action)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/BurntSushi/toml"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/query"
+	"github.com/hscells/groove/stats"
+	tpipeline "github.com/hscells/transmute/pipeline"
+	"github.com/hscells/trecresults"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+type treeCmd struct {
+	QueryFile    string `help:"Path to query file" arg:"required,positional"`
+	Format       string `help:"Format of the query file; medline, pubmed or cqr" arg:"-f"`
+	Output       string `help:"Output format; dot, svg or json" arg:"-o"`
+	Topic        string `help:"Topic of the query (default is the name of the query file)" arg:"-t"`
+	QrelsFile    string `help:"Path to qrels file" arg:"-q"`
+	Path         string `help:"Path to the file query cache" arg:"-p"`
+	Entrez       string `help:"Path to a config file with an [entrez] section (email, tool, key) used to retrieve atoms missing from the cache" arg:"-e"`
+	Namespace    string `help:"Namespace of the statistics source atoms were retrieved from (default is the namespace of the Entrez source)" arg:"-n"`
+	ColourCache  bool   `help:"Colour atoms by whether they were cache hits" arg:"--colour-cache"`
+	ColourLosses bool   `help:"Outline clauses that lose relevant documents" arg:"--colour-losses"`
+}
+
+// entrezNamespace is the namespace of the Entrez statistics source created by entrezSource, so that atoms it cached can
+// be read without it.
+const entrezNamespace = "entrez/pubmed"
+
+type entrezConfig struct {
+	Entrez struct {
+		Email string `toml:"email"`
+		Tool  string `toml:"tool"`
+		Key   string `toml:"key"`
+	} `toml:"entrez"`
+}
+
+// entrezSource creates an Entrez statistics source from the [entrez] section of a config file.
+func entrezSource(file string) (stats.StatisticsSource, error) {
+	var c entrezConfig
+	if _, err := toml.DecodeFile(file, &c); err != nil {
+		return nil, err
+	}
+	e, err := stats.NewEntrezStatisticsSource(
+		stats.EntrezTool(c.Entrez.Tool),
+		stats.EntrezAPIKey(c.Entrez.Key),
+		stats.EntrezEmail(c.Entrez.Email),
+		stats.EntrezOptions(stats.SearchOptions{Size: 100000, RunName: name}))
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// transmutePipeline is the pipeline that parses queries of a format.
+func transmutePipeline(format string) (tpipeline.TransmutePipeline, error) {
+	switch format {
+	case "", "medline":
+		return query.MedlineTransmutePipeline, nil
+	case "pubmed":
+		return query.PubMedTransmutePipeline, nil
+	case "cqr":
+		return query.CQRTransmutePipeline, nil
+	}
+	return tpipeline.TransmutePipeline{}, fmt.Errorf("unknown query format %s", format)
+}
+
+func tree(t *treeCmd) error {
+	tp, err := transmutePipeline(t.Format)
+	if err != nil {
+		return err
+	}
+	source, err := ioutil.ReadFile(t.QueryFile)
+	if err != nil {
+		return err
+	}
+	bq, err := tp.Execute(string(source))
+	if err != nil {
+		return err
+	}
+	repr, err := bq.Representation()
+	if err != nil {
+		return err
+	}
+	if len(t.Topic) == 0 {
+		t.Topic = path.Base(t.QueryFile)
+	}
+	q := pipeline.NewQuery(t.Topic, t.Topic, repr.(cqr.CommonQueryRepresentation))
+
+	var qrels trecresults.Qrels
+	if len(t.QrelsFile) > 0 {
+		b, err := ioutil.ReadFile(t.QrelsFile)
+		if err != nil {
+			return err
+		}
+		f, err := trecresults.QrelsFromReader(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		qrels = f.Qrels[t.Topic]
+	}
+
+	p, err := cachePath(t.Path, "file_cache")
+	if err != nil {
+		return err
+	}
+
+	var ss stats.StatisticsSource
+	if len(t.Entrez) > 0 {
+		ss, err = entrezSource(t.Entrez)
+		if err != nil {
+			return err
+		}
+	}
+	if len(t.Namespace) == 0 {
+		t.Namespace = entrezNamespace
+		if ss != nil {
+			t.Namespace = stats.Namespace(ss)
+		}
+	}
+	cache := combinator.NewFileQueryCache(p, combinator.CacheNamespace(t.Namespace))
+
+	hits := output.CacheHits(q.Query, cache)
+	if ss == nil {
+		for atom, hit := range hits {
+			if !hit {
+				return fmt.Errorf("%s is not cached; use --entrez to retrieve it", atom)
+			}
+		}
+	}
+	lt, cache, err := combinator.NewLogicalTree(q, ss, cache)
+	if err != nil {
+		return err
+	}
+	annotated, err := output.AnnotateTree(lt, cache, qrels, hits)
+	if err != nil {
+		return err
+	}
+
+	var options []func(o *output.DOTOptions)
+	if t.ColourCache {
+		options = append(options, output.DOTCacheHits)
+	}
+	if t.ColourLosses {
+		options = append(options, output.DOTLostRelevant)
+	}
+	var s string
+	switch t.Output {
+	case "", "dot":
+		s = output.TreeDOT(annotated, options...)
+	case "svg":
+		s, err = output.TreeSVG(annotated, options...)
+	case "json":
+		s, err = output.TreeJSON(annotated)
+	default:
+		return fmt.Errorf("unknown output format %s", t.Output)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(os.Stdout, s)
+	return err
+}
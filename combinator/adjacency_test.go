@@ -0,0 +1,89 @@
+package combinator_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"github.com/pkg/errors"
+	"strconv"
+	"testing"
+)
+
+// memorySource is a statistics source backed by an in-memory posting list of keywords to documents.
+type memorySource struct {
+	postings map[string][]uint32
+}
+
+func (m memorySource) SearchOptions() stats.SearchOptions { return stats.SearchOptions{} }
+func (m memorySource) Parameters() map[string]float64     { return nil }
+func (m memorySource) TermFrequency(term, field, document string) (float64, error) {
+	return 0, nil
+}
+func (m memorySource) TermVector(document string) (stats.TermVector, error) { return nil, nil }
+func (m memorySource) DocumentFrequency(term, field string) (float64, error) {
+	return float64(len(m.postings[term])), nil
+}
+func (m memorySource) TotalTermFrequency(term, field string) (float64, error) { return 0, nil }
+func (m memorySource) InverseDocumentFrequency(term, field string) (float64, error) {
+	return 0, nil
+}
+func (m memorySource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	return 0, nil
+}
+func (m memorySource) VocabularySize(field string) (float64, error) { return 0, nil }
+func (m memorySource) Execute(query pipeline.Query, options stats.SearchOptions) (trecresults.ResultList, error) {
+	var results trecresults.ResultList
+	if kw, ok := query.Query.(cqr.Keyword); ok {
+		for _, id := range m.postings[kw.QueryString] {
+			results = append(results, &trecresults.Result{Topic: query.Topic, DocId: strconv.Itoa(int(id))})
+		}
+	}
+	return results, nil
+}
+func (m memorySource) CollectionSize() (float64, error) { return 10, nil }
+
+// proximitySource additionally knows which documents contain its keywords close together.
+type proximitySource struct {
+	memorySource
+	near []uint32
+}
+
+func (p proximitySource) ProximityDocumentIDs(query cqr.BooleanQuery) ([]uint32, error) {
+	return p.near, nil
+}
+
+func TestAdjacency(t *testing.T) {
+	postings := map[string][]uint32{
+		"heart":  {1, 2, 3},
+		"attack": {2, 3, 4},
+	}
+	q := pipeline.NewQuery("adj", "1", cqr.NewBooleanQuery("adj2", []cqr.CommonQueryRepresentation{
+		cqr.NewKeyword("heart", "title"),
+		cqr.NewKeyword("attack", "title"),
+	}))
+
+	// Sources without proximity support must not fall back to a conjunction.
+	_, _, err := combinator.NewLogicalTree(q, memorySource{postings}, combinator.NewMapQueryCache())
+	if errors.Cause(err) != stats.ErrProximityUnsupported {
+		t.Fatalf("expected %v, got %v", stats.ErrProximityUnsupported, err)
+	}
+
+	// Otherwise the adjacency clause is resolved (and cached) as a whole.
+	cache := combinator.NewMapQueryCache()
+	tree, _, err := combinator.NewLogicalTree(q, proximitySource{memorySource{postings}, []uint32{3}}, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tree.Root.(combinator.AdjAtom); !ok {
+		t.Fatalf("expected the root to be an adjacency atom, got %T", tree.Root)
+	}
+	docs, err := tree.Documents(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !docs.Equals(combinator.NewDocuments(3)) {
+		t.Errorf("expected [3], got %v", docs)
+	}
+}
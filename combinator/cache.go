@@ -1,19 +1,16 @@
 package combinator
 
 import (
-	"bytes"
-	"encoding/binary"
 	"encoding/gob"
 	"errors"
 	"fmt"
 	"github.com/hashicorp/golang-lru"
 	"github.com/hscells/cqr"
+	"github.com/hscells/groove/stats"
 	"github.com/peterbourgon/diskv"
-	"io/ioutil"
 	"os"
-	"path"
-	"sort"
 	"strconv"
+	"sync"
 )
 
 // ErrCacheMiss indicates that a read did not fail, but the item was not present in the cache.
@@ -34,18 +31,12 @@ func BlockTransform(blockSize int) func(string) []string {
 	}
 }
 
-// docsToBytes encodes a retrieved set of documents  to bytes.
+// docsToBytes encodes a retrieved set of documents to bytes.
 func docsToBytes(docs Documents) ([]byte, error) {
-	if len(docs) == 0 {
+	if docs.Len() == 0 {
 		return []byte{}, nil
 	}
-	var buff bytes.Buffer
-	enc := gob.NewEncoder(&buff)
-	err := enc.Encode(docs)
-	if err != nil {
-		return nil, err
-	}
-	return buff.Bytes(), nil
+	return docs.MarshalBinary()
 }
 
 func constructor() {
@@ -60,14 +51,19 @@ type QueryCacher interface {
 	Set(query cqr.CommonQueryRepresentation, docs Documents) error
 }
 
-// MapQueryCache caches results to memory.
+// MapQueryCache caches results to memory. Results are keyed by the canonical form of the query, so keys never collide.
+// It is safe for concurrent use, so a single cache can be shared by the topics of a pipeline.
 type MapQueryCache struct {
-	m map[uint64]Documents
+	m  map[string]Documents
+	mu *sync.RWMutex
 }
 
 // Get looks up results in a map.
 func (m MapQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, error) {
-	if d, ok := m.m[HashCQR(query)]; ok {
+	key := canonicalQuery(query)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if d, ok := m.m[key]; ok {
 		return d, nil
 	}
 	return Documents{}, ErrCacheMiss
@@ -75,66 +71,95 @@ func (m MapQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, erro
 
 // Set caches results to a map.
 func (m MapQueryCache) Set(query cqr.CommonQueryRepresentation, docs Documents) error {
-	sort.Sort(docs)
-	m.m[HashCQR(query)] = docs
+	key := canonicalQuery(query)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.m[key] = docs
 	return nil
 }
 
 // NewMapQueryCache creates a query cache out of a regular go map.
 func NewMapQueryCache() QueryCacher {
 	constructor()
-	return MapQueryCache{make(map[uint64]Documents)}
+	return MapQueryCache{m: make(map[string]Documents), mu: &sync.RWMutex{}}
 }
 
-// DiskvQueryCache caches results using diskv.
+// DiskvQueryCache caches results using diskv. Metadata is recorded for each entry, so that entries can be listed,
+// expired and evicted according to the CacheOptions of the cache. Entries are keyed by CacheKey in the namespace of the
+// cache.
 type DiskvQueryCache struct {
 	*diskv.Diskv
+	managed managedCache
 }
 
-// Get looks up results from disk.
+// Get looks up results from disk. Results cached before keys were namespaced are moved to their namespaced key.
 func (d DiskvQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, error) {
-	b, err := d.Read(strconv.Itoa(int(HashCQR(query))))
-	if err != nil {
-		return Documents{}, ErrCacheMiss
-	}
-	if len(b) == 0 {
-		return Documents{}, nil
-	}
-	dec := gob.NewDecoder(bytes.NewReader(b))
-	var c Documents
-	err = dec.Decode(&c)
-	if err != nil {
-		return Documents{}, err
+	key, canonical := d.managed.key(query), canonicalQuery(query)
+	docs, err := d.managed.get(key, canonical)
+	if err == ErrCacheMiss {
+		return d.managed.upgrade(strconv.Itoa(int(HashCQR(query))), key, canonical)
 	}
-	return c, nil
+	return docs, err
 }
 
 // Set caches results to disk.
 func (d DiskvQueryCache) Set(query cqr.CommonQueryRepresentation, docs Documents) error {
-	sort.Sort(docs)
-	b, err := docsToBytes(docs)
-	if err != nil {
-		fmt.Println(err)
-		return err
-	}
-	return d.Write(strconv.Itoa(int(HashCQR(query))), b)
+	return d.managed.set(d.managed.key(query), canonicalQuery(query), docs)
+}
+
+// Entries lists the metadata of every entry in the cache.
+func (d DiskvQueryCache) Entries() ([]CacheEntry, error) {
+	return d.managed.entries()
+}
+
+// Entry reads an entry, and the documents cached for it.
+func (d DiskvQueryCache) Entry(key string) (CacheEntry, Documents, error) {
+	return d.managed.entry(key)
+}
+
+// Put writes an entry, keeping its metadata.
+func (d DiskvQueryCache) Put(entry CacheEntry, docs Documents) error {
+	return d.managed.put(entry, docs)
+}
+
+// Remove deletes an entry.
+func (d DiskvQueryCache) Remove(key string) error {
+	return d.managed.remove(key)
+}
+
+// Prune evicts expired and least recently accessed entries.
+func (d DiskvQueryCache) Prune() (int, error) {
+	return d.managed.prune()
+}
+
+// Migrate moves entries to their namespaced keys.
+func (d DiskvQueryCache) Migrate() (int, error) {
+	return d.managed.migrate()
 }
 
 // NewDiskvQueryCache creates a new on-disk cache with the specified diskv parameters.
-func NewDiskvQueryCache(dv *diskv.Diskv) QueryCacher {
+func NewDiskvQueryCache(dv *diskv.Diskv, options ...func(o *CacheOptions)) QueryCacher {
 	constructor()
-	return DiskvQueryCache{dv}
+	m, err := newManagedCache(diskvStore{dv}, options...)
+	if err != nil {
+		panic(err)
+	}
+	return DiskvQueryCache{Diskv: dv, managed: m}
 }
 
 // FileQueryCache caches results in a flat-file format in a single directory. This cacher will be faster than diskv as
-// it does not use gob encoding.
+// it does not use gob encoding. Documents are written in their compressed format, however files written in the older
+// format of little-endian document ids can still be read. Metadata is recorded for each entry, so that entries can be
+// listed, expired and evicted according to the CacheOptions of the cache. Entries are keyed by CacheKey in the
+// namespace of the cache.
 type FileQueryCache struct {
-	path  string
-	cache *lru.Cache
+	path    string
+	cache   *lru.Cache
+	managed managedCache
 }
 
 // NewFileQueryCache creates a new disk-based file query cache.
-func NewFileQueryCache(path string) QueryCacher {
+func NewFileQueryCache(path string, options ...func(o *CacheOptions)) QueryCacher {
 	err := os.MkdirAll(path, 0700)
 	if err != nil {
 		panic(err)
@@ -143,52 +168,110 @@ func NewFileQueryCache(path string) QueryCacher {
 	if err != nil {
 		panic(err)
 	}
+	m, err := newManagedCache(fileStore{path}, options...)
+	if err != nil {
+		panic(err)
+	}
+	m.evicted = c.Purge
 	return FileQueryCache{
-		path:  path,
-		cache: c,
+		path:    path,
+		cache:   c,
+		managed: m,
 	}
 }
 
-// Get looks up results from disk.
+// Get looks up results from disk. Results cached before keys were namespaced are moved to their namespaced key.
 func (f FileQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, error) {
-	h := HashCQR(query)
-	if v, ok := f.cache.Get(h); ok {
+	key, canonical := f.managed.key(query), canonicalQuery(query)
+	if v, ok := f.cache.Get(key); ok {
 		return v.(Documents), nil
 	}
 
-	fn := path.Join(f.path, fmt.Sprintf("%v", h))
-	if _, err := os.Stat(fn); err != nil && os.IsNotExist(err) {
-		return nil, ErrCacheMiss
-	} else if err != nil {
-		return nil, err
+	d, err := f.managed.get(key, canonical)
+	if err == ErrCacheMiss {
+		d, err = f.managed.upgrade(fmt.Sprintf("%v", HashCQR(query)), key, canonical)
 	}
-	b, err := ioutil.ReadFile(fn)
 	if err != nil {
-		return nil, err
-	}
-	d := make(Documents, len(b)/4)
-	for i, j := 0, 0; i < len(b); i += 4 {
-		d[j] = Document(binary.LittleEndian.Uint32(b[i : i+4]))
-		j++
+		return Documents{}, err
 	}
+	f.cache.Add(key, d)
 	return d, nil
 }
 
 // Set caches results to disk.
 func (f FileQueryCache) Set(query cqr.CommonQueryRepresentation, docs Documents) error {
-	sort.Sort(docs)
-	h := HashCQR(query)
-	f.cache.Add(h, docs)
-	b := make([]byte, len(docs)*4)
-	i := 0
-	for _, id := range docs {
-		d := make([]byte, 4)
-		binary.LittleEndian.PutUint32(d, uint32(id))
-		b[i] = d[0]
-		b[i+1] = d[1]
-		b[i+2] = d[2]
-		b[i+3] = d[3]
-		i += 4
-	}
-	return ioutil.WriteFile(path.Join(f.path, fmt.Sprintf("%v", h)), b, 0644)
+	key := f.managed.key(query)
+	f.cache.Add(key, docs)
+	return f.managed.set(key, canonicalQuery(query), docs)
+}
+
+// Entries lists the metadata of every entry in the cache.
+func (f FileQueryCache) Entries() ([]CacheEntry, error) {
+	return f.managed.entries()
+}
+
+// Entry reads an entry, and the documents cached for it.
+func (f FileQueryCache) Entry(key string) (CacheEntry, Documents, error) {
+	return f.managed.entry(key)
+}
+
+// Put writes an entry, keeping its metadata.
+func (f FileQueryCache) Put(entry CacheEntry, docs Documents) error {
+	f.cache.Purge()
+	return f.managed.put(entry, docs)
+}
+
+// Remove deletes an entry.
+func (f FileQueryCache) Remove(key string) error {
+	f.cache.Purge()
+	return f.managed.remove(key)
+}
+
+// Prune evicts expired and least recently accessed entries.
+func (f FileQueryCache) Prune() (int, error) {
+	f.cache.Purge()
+	return f.managed.prune()
+}
+
+// Migrate moves entries to their namespaced keys.
+func (f FileQueryCache) Migrate() (int, error) {
+	f.cache.Purge()
+	return f.managed.migrate()
+}
+
+// FetchQueryCache is a read-through cache, which retrieves documents missing from the underlying cache from a
+// statistics source, and then caches them. It is as safe for concurrent use as the underlying cache.
+type FetchQueryCache struct {
+	cache QueryCacher
+	ss    stats.StatisticsSource
+}
+
+// NewFetchQueryCache creates a read-through cache on top of an existing cache.
+func NewFetchQueryCache(cache QueryCacher, ss stats.StatisticsSource) QueryCacher {
+	if f, ok := cache.(FetchQueryCache); ok {
+		cache = f.cache
+	}
+	return FetchQueryCache{
+		cache: cache,
+		ss:    ss,
+	}
+}
+
+// Get looks up results from the underlying cache, falling back to the statistics source on a miss.
+func (f FetchQueryCache) Get(query cqr.CommonQueryRepresentation) (Documents, error) {
+	docs, err := f.cache.Get(query)
+	if err != ErrCacheMiss {
+		return docs, err
+	}
+
+	docs, err = fetchDocuments(query, f.ss)
+	if err != nil {
+		return Documents{}, err
+	}
+	return docs, f.Set(query, docs)
+}
+
+// Set caches results to the underlying cache.
+func (f FetchQueryCache) Set(query cqr.CommonQueryRepresentation, docs Documents) error {
+	return f.cache.Set(query, docs)
 }
@@ -0,0 +1,88 @@
+package combinator
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// exportedEntry is a single line of an exported cache.
+type exportedEntry struct {
+	Entry     CacheEntry `json:"entry"`
+	Documents []byte     `json:"documents"`
+}
+
+// VerifyCache reads every entry of a cache, and checks that its documents can be decoded and match its metadata. The
+// keys of the entries that fail verification are returned along with the reason they failed.
+func VerifyCache(c InspectableQueryCacher) (map[string]error, error) {
+	entries, err := c.Entries()
+	if err != nil {
+		return nil, err
+	}
+	failed := make(map[string]error)
+	for _, e := range entries {
+		entry, docs, err := c.Entry(e.Key)
+		if err != nil {
+			failed[e.Key] = err
+			continue
+		}
+		if entry.Count != docs.Len() {
+			failed[e.Key] = fmt.Errorf("expected %d documents, read %d", entry.Count, docs.Len())
+		} else if !isCacheKey(entry.Key) {
+			failed[e.Key] = fmt.Errorf("entry has not been migrated to a namespaced key")
+		} else if entry.Key != cacheKey(entry.Query, entry.Namespace) {
+			failed[e.Key] = fmt.Errorf("key does not match query %s in namespace %s", entry.Query, entry.Namespace)
+		}
+	}
+	return failed, nil
+}
+
+// ExportCache writes every entry of a cache, one JSON object per line, so that it can be imported into another cache.
+func ExportCache(w io.Writer, c InspectableQueryCacher) error {
+	entries, err := c.Entries()
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	for _, e := range entries {
+		entry, docs, err := c.Entry(e.Key)
+		if err == ErrCacheMiss {
+			continue
+		} else if err != nil {
+			return err
+		}
+		b, err := docs.MarshalBinary()
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(exportedEntry{Entry: entry, Documents: b}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportCache reads entries written by ExportCache into a cache, keeping their metadata. The number of imported
+// entries is returned.
+func ImportCache(r io.Reader, c InspectableQueryCacher) (int, error) {
+	dec := json.NewDecoder(bufio.NewReader(r))
+	n := 0
+	for {
+		var e exportedEntry
+		err := dec.Decode(&e)
+		if err == io.EOF {
+			return n, nil
+		} else if err != nil {
+			return n, err
+		}
+		var docs Documents
+		if err := docs.UnmarshalBinary(e.Documents); err != nil {
+			return n, err
+		}
+		if err := c.Put(e.Entry, docs); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
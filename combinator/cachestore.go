@@ -0,0 +1,598 @@
+package combinator
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/peterbourgon/diskv"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// metadataSuffix is appended to the key of an entry to store its metadata.
+	metadataSuffix = ".json"
+	// accessResolution is how often the access time of an entry is recorded, so that reading an entry does not
+	// always result in a write.
+	accessResolution = time.Minute
+)
+
+// entryMagic prefixes entries that record the canonical query they were cached for.
+var entryMagic = []byte("GRQ1")
+
+// CacheKey is the key a query is cached under in a namespace. It is the SHA-256 hash of the namespace and the canonical
+// form of the query, so queries retrieved from differently configured statistics sources are cached separately.
+func CacheKey(query cqr.CommonQueryRepresentation, namespace string) string {
+	return cacheKey(canonicalQuery(query), namespace)
+}
+
+func cacheKey(canonical, namespace string) string {
+	h := sha256.New()
+	h.Write([]byte(namespace))
+	h.Write([]byte{0})
+	h.Write([]byte(canonical))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// isCacheKey returns if a key was created by CacheKey, rather than being the key of an entry cached before keys were
+// namespaced.
+func isCacheKey(key string) bool {
+	if len(key) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(key)
+	return err == nil
+}
+
+// canonicalQuery is the string a query is identified by in a cache.
+func canonicalQuery(query cqr.CommonQueryRepresentation) string {
+	if query == nil {
+		return ""
+	}
+	return query.String()
+}
+
+// encodeEntry encodes the documents of an entry, prefixed with the canonical query they were retrieved for.
+func encodeEntry(query string, docs Documents) ([]byte, error) {
+	b, err := docsToBytes(docs)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(make([]byte, 0, len(entryMagic)+binary.MaxVarintLen64+len(query)+len(b)))
+	buf.Write(entryMagic)
+	n := make([]byte, binary.MaxVarintLen64)
+	buf.Write(n[:binary.PutUvarint(n, uint64(len(query)))])
+	buf.WriteString(query)
+	buf.Write(b)
+	return buf.Bytes(), nil
+}
+
+// decodeEntry decodes the canonical query and documents of an entry. Entries written before the query was recorded
+// are decoded with an empty query.
+func decodeEntry(b []byte, legacy func([]byte) ([]uint32, error)) (string, Documents, error) {
+	if !bytes.HasPrefix(b, entryMagic) {
+		docs, err := decodeDocuments(b, legacy)
+		return "", docs, err
+	}
+	b = b[len(entryMagic):]
+	n, i := binary.Uvarint(b)
+	if i <= 0 || uint64(len(b)-i) < n {
+		return "", Documents{}, fmt.Errorf("corrupt cache entry of %d bytes", len(b)+len(entryMagic))
+	}
+	query := string(b[i : i+int(n)])
+	docs, err := decodeDocuments(b[i+int(n):], legacy)
+	return query, docs, err
+}
+
+// CacheEntry is the metadata of an entry in a persistent query cache. Size is the number of bytes the documents of the
+// entry take up on disk. Entries written before metadata was recorded have a count of -1 until they are read.
+type CacheEntry struct {
+	Key       string    `json:"key"`
+	Query     string    `json:"query"`
+	Namespace string    `json:"namespace"`
+	Created   time.Time `json:"created"`
+	Accessed  time.Time `json:"accessed"`
+	Count     int       `json:"count"`
+	Size      int64     `json:"size"`
+}
+
+// CacheOptions bound the size and age of the entries of a persistent query cache, and set the namespace recorded
+// against new entries.
+type CacheOptions struct {
+	MaxSize   int64
+	TTL       time.Duration
+	Namespace string
+}
+
+// CacheMaxSize sets the maximum number of bytes the documents in a cache may take up on disk. The least recently
+// accessed entries are evicted once the cache grows beyond this size, until it is at most 90% of this size.
+func CacheMaxSize(size int64) func(o *CacheOptions) {
+	return func(o *CacheOptions) {
+		o.MaxSize = size
+	}
+}
+
+// CacheTTL sets how long entries remain in a cache after they are created.
+func CacheTTL(ttl time.Duration) func(o *CacheOptions) {
+	return func(o *CacheOptions) {
+		o.TTL = ttl
+	}
+}
+
+// CacheNamespace sets the namespace (typically the statistics source the documents were retrieved from) recorded
+// against new entries.
+func CacheNamespace(namespace string) func(o *CacheOptions) {
+	return func(o *CacheOptions) {
+		o.Namespace = namespace
+	}
+}
+
+// expired returns if an entry has outlived the TTL. Entries without a creation time never expire.
+func (o CacheOptions) expired(e CacheEntry, now time.Time) bool {
+	return o.TTL > 0 && !e.Created.IsZero() && now.Sub(e.Created) > o.TTL
+}
+
+// InspectableQueryCacher is a persistent query cache whose entries can be listed and managed.
+type InspectableQueryCacher interface {
+	QueryCacher
+	// Entries lists the metadata of every entry in the cache.
+	Entries() ([]CacheEntry, error)
+	// Entry reads an entry, and the documents cached for it.
+	Entry(key string) (CacheEntry, Documents, error)
+	// Put writes an entry, keeping its metadata.
+	Put(entry CacheEntry, docs Documents) error
+	// Remove deletes an entry.
+	Remove(key string) error
+	// Prune evicts expired entries, and then the least recently accessed entries until the cache is within its
+	// maximum size. The number of evicted entries is returned.
+	Prune() (int, error)
+	// Migrate moves entries cached before keys were namespaced to their namespaced keys. Entries that do not record
+	// the query they were cached for are instead moved the next time they are read. The number of moved entries is
+	// returned.
+	Migrate() (int, error)
+}
+
+// cacheStore is where a persistent query cache stores entries.
+type cacheStore interface {
+	read(key string) ([]byte, error)
+	write(key string, b []byte) error
+	erase(key string) error
+	keys() ([]string, error)
+	stat(key string) (int64, time.Time, error)
+	// legacy decodes entries written before documents were compressed.
+	legacy(b []byte) ([]uint32, error)
+}
+
+// fileStore stores each entry as a file in a single directory.
+type fileStore struct {
+	path string
+}
+
+func (f fileStore) read(key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path.Join(f.path, key))
+	if os.IsNotExist(err) {
+		return nil, ErrCacheMiss
+	}
+	return b, err
+}
+
+func (f fileStore) write(key string, b []byte) error {
+	return ioutil.WriteFile(path.Join(f.path, key), b, 0644)
+}
+
+func (f fileStore) erase(key string) error {
+	err := os.Remove(path.Join(f.path, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+func (f fileStore) keys() ([]string, error) {
+	files, err := ioutil.ReadDir(f.path)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]string, 0, len(files))
+	for _, file := range files {
+		if !file.IsDir() {
+			keys = append(keys, file.Name())
+		}
+	}
+	return keys, nil
+}
+
+func (f fileStore) stat(key string) (int64, time.Time, error) {
+	info, err := os.Stat(path.Join(f.path, key))
+	if os.IsNotExist(err) {
+		return 0, time.Time{}, ErrCacheMiss
+	} else if err != nil {
+		return 0, time.Time{}, err
+	}
+	return info.Size(), info.ModTime(), nil
+}
+
+func (f fileStore) legacy(b []byte) ([]uint32, error) {
+	return flatFileIDs(b)
+}
+
+// diskvStore stores entries using diskv.
+type diskvStore struct {
+	*diskv.Diskv
+}
+
+func (d diskvStore) read(key string) ([]byte, error) {
+	b, err := d.Read(key)
+	if err != nil && !d.Has(key) {
+		return nil, ErrCacheMiss
+	}
+	return b, err
+}
+
+func (d diskvStore) write(key string, b []byte) error {
+	return d.Write(key, b)
+}
+
+func (d diskvStore) erase(key string) error {
+	if !d.Has(key) {
+		return nil
+	}
+	return d.Erase(key)
+}
+
+func (d diskvStore) keys() ([]string, error) {
+	var keys []string
+	for key := range d.Keys(nil) {
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// stat reads the entry to find its size, as diskv does not expose the files it writes to.
+func (d diskvStore) stat(key string) (int64, time.Time, error) {
+	b, err := d.read(key)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return int64(len(b)), time.Time{}, nil
+}
+
+func (d diskvStore) legacy(b []byte) ([]uint32, error) {
+	return gobIDs(b)
+}
+
+// managedCache records metadata for, and evicts, the entries of a cache store.
+type managedCache struct {
+	store   cacheStore
+	options CacheOptions
+	mu      *sync.Mutex
+	size    *int64
+	// evicted is called after entries are evicted, so that copies of them held in memory can be discarded.
+	evicted func()
+}
+
+func newManagedCache(store cacheStore, options ...func(o *CacheOptions)) (managedCache, error) {
+	m := managedCache{
+		store: store,
+		mu:    &sync.Mutex{},
+		size:  new(int64),
+	}
+	for _, option := range options {
+		option(&m.options)
+	}
+	if m.options.MaxSize > 0 {
+		entries, err := m.entries()
+		if err != nil {
+			return managedCache{}, err
+		}
+		for _, e := range entries {
+			*m.size += e.Size
+		}
+	}
+	return m, nil
+}
+
+// metadata reads the metadata of an entry. Entries without metadata are described as well as possible from the store.
+func (m managedCache) metadata(key string) (CacheEntry, error) {
+	b, err := m.store.read(key + metadataSuffix)
+	if err == nil {
+		var e CacheEntry
+		err = json.Unmarshal(b, &e)
+		return e, err
+	} else if err != ErrCacheMiss {
+		return CacheEntry{}, err
+	}
+
+	size, modified, err := m.store.stat(key)
+	if err != nil {
+		return CacheEntry{}, err
+	}
+	return CacheEntry{
+		Key:      key,
+		Created:  modified,
+		Accessed: modified,
+		Count:    -1,
+		Size:     size,
+	}, nil
+}
+
+func (m managedCache) writeMetadata(e CacheEntry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return m.store.write(e.Key+metadataSuffix, b)
+}
+
+// key is the key of a query in the namespace of the cache.
+func (m managedCache) key(query cqr.CommonQueryRepresentation) string {
+	return CacheKey(query, m.options.Namespace)
+}
+
+// get reads the documents of an entry, evicting it if it has expired. An entry cached for a different query than the
+// one it is read for (i.e. the keys of the queries collide) is a miss.
+func (m managedCache) get(key, query string) (Documents, error) {
+	b, err := m.store.read(key)
+	if err != nil {
+		return Documents{}, err
+	}
+	cached, docs, err := decodeEntry(b, m.store.legacy)
+	if err != nil {
+		return Documents{}, err
+	}
+	if len(cached) > 0 && cached != query {
+		return Documents{}, ErrCacheMiss
+	}
+
+	// Metadata is only needed to expire and evict entries.
+	if m.options.TTL > 0 || m.options.MaxSize > 0 {
+		e, err := m.metadata(key)
+		if err != nil {
+			return Documents{}, err
+		}
+		now := time.Now()
+		if m.options.expired(e, now) {
+			if err := m.remove(key); err != nil {
+				return Documents{}, err
+			}
+			return Documents{}, ErrCacheMiss
+		}
+		if now.Sub(e.Accessed) > accessResolution {
+			e.Accessed = now
+			if err := m.writeMetadata(e); err != nil {
+				return Documents{}, err
+			}
+		}
+	}
+	return docs, nil
+}
+
+// set writes the documents of an entry, along with new metadata.
+func (m managedCache) set(key, query string, docs Documents) error {
+	now := time.Now()
+	return m.put(CacheEntry{
+		Key:       key,
+		Query:     query,
+		Namespace: m.options.Namespace,
+		Created:   now,
+		Accessed:  now,
+		Count:     docs.Len(),
+	}, docs)
+}
+
+// sizeOf is the number of bytes an existing entry takes up on disk, or zero if the entry does not exist.
+func (m managedCache) sizeOf(key string) (int64, error) {
+	e, err := m.metadata(key)
+	if err == ErrCacheMiss {
+		return 0, nil
+	}
+	return e.Size, err
+}
+
+// put writes the documents and metadata of an entry, and then evicts entries if the cache is too large. An existing
+// entry with the same key is replaced, so only the difference in size is added to the size of the cache.
+func (m managedCache) put(e CacheEntry, docs Documents) error {
+	b, err := encodeEntry(e.Query, docs)
+	if err != nil {
+		return err
+	}
+	e.Count = docs.Len()
+	e.Size = int64(len(b))
+	var old int64
+	if m.options.MaxSize > 0 {
+		old, err = m.sizeOf(e.Key)
+		if err != nil {
+			return err
+		}
+	}
+	if err := m.store.write(e.Key, b); err != nil {
+		return err
+	}
+	if err := m.writeMetadata(e); err != nil {
+		return err
+	}
+	if m.options.MaxSize > 0 && atomic.AddInt64(m.size, e.Size-old) > m.options.MaxSize {
+		_, err = m.prune()
+	}
+	return err
+}
+
+// remove deletes the documents and metadata of an entry. Removing an entry that does not exist is not an error.
+func (m managedCache) remove(key string) error {
+	var size int64
+	if m.options.MaxSize > 0 {
+		var err error
+		size, err = m.sizeOf(key)
+		if err != nil {
+			return err
+		}
+	}
+	if err := m.store.erase(key); err != nil {
+		return err
+	}
+	atomic.AddInt64(m.size, -size)
+	return m.store.erase(key + metadataSuffix)
+}
+
+func (m managedCache) entries() ([]CacheEntry, error) {
+	keys, err := m.store.keys()
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]CacheEntry, 0, len(keys))
+	for _, key := range keys {
+		if strings.HasSuffix(key, metadataSuffix) {
+			continue
+		}
+		e, err := m.metadata(key)
+		if err == ErrCacheMiss {
+			// The entry was removed while listing.
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Key < entries[j].Key
+	})
+	return entries, nil
+}
+
+func (m managedCache) entry(key string) (CacheEntry, Documents, error) {
+	b, err := m.store.read(key)
+	if err != nil {
+		return CacheEntry{}, Documents{}, err
+	}
+	query, docs, err := decodeEntry(b, m.store.legacy)
+	if err != nil {
+		return CacheEntry{}, Documents{}, err
+	}
+	e, err := m.metadata(key)
+	if err != nil {
+		return CacheEntry{}, Documents{}, err
+	}
+	if len(e.Query) == 0 {
+		e.Query = query
+	}
+	if e.Count < 0 {
+		e.Count = docs.Len()
+	}
+	return e, docs, nil
+}
+
+// prune evicts expired entries, and then the least recently accessed entries until the cache is at most 90% of its
+// maximum size. Evicting below the maximum size leaves room for new entries, so that the cache is not listed on every
+// write once it is full.
+func (m managedCache) prune() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entries, err := m.entries()
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		n    int
+		size int64
+		kept []CacheEntry
+		now  = time.Now()
+	)
+	defer func() {
+		if n > 0 && m.evicted != nil {
+			m.evicted()
+		}
+	}()
+	for _, e := range entries {
+		if m.options.expired(e, now) {
+			if err := m.remove(e.Key); err != nil {
+				return n, err
+			}
+			n++
+			continue
+		}
+		kept = append(kept, e)
+		size += e.Size
+	}
+
+	if m.options.MaxSize > 0 && size > m.options.MaxSize {
+		sort.Slice(kept, func(i, j int) bool {
+			return kept[i].Accessed.Before(kept[j].Accessed)
+		})
+		watermark := m.options.MaxSize - m.options.MaxSize/10
+		for _, e := range kept {
+			if size <= watermark {
+				break
+			}
+			if err := m.remove(e.Key); err != nil {
+				return n, err
+			}
+			size -= e.Size
+			n++
+		}
+	}
+	atomic.StoreInt64(m.size, size)
+	return n, nil
+}
+
+// upgrade moves an entry cached before keys were namespaced to its namespaced key when it is read. The entry is a miss
+// if it records a different query.
+func (m managedCache) upgrade(old, key, query string) (Documents, error) {
+	e, docs, err := m.entry(old)
+	if err != nil {
+		return Documents{}, err
+	}
+	if len(e.Query) > 0 && e.Query != query {
+		return Documents{}, ErrCacheMiss
+	}
+	e.Key = key
+	e.Query = query
+	e.Namespace = m.options.Namespace
+	if err := m.put(e, docs); err != nil {
+		return Documents{}, err
+	}
+	return docs, m.remove(old)
+}
+
+func (m managedCache) migrate() (int, error) {
+	entries, err := m.entries()
+	if err != nil {
+		return 0, err
+	}
+	var n int
+	for _, e := range entries {
+		if isCacheKey(e.Key) || len(e.Query) == 0 {
+			continue
+		}
+		_, docs, err := m.entry(e.Key)
+		if err == ErrCacheMiss {
+			continue
+		} else if err != nil {
+			return n, err
+		}
+		old := e.Key
+		if len(e.Namespace) == 0 {
+			e.Namespace = m.options.Namespace
+		}
+		e.Key = cacheKey(e.Query, e.Namespace)
+		if err := m.put(e, docs); err != nil {
+			return n, err
+		}
+		if err := m.remove(old); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
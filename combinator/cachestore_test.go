@@ -0,0 +1,164 @@
+package combinator_test
+
+import (
+	"bytes"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/combinator"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPruneCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "groove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	cache := combinator.NewFileQueryCache(dir).(combinator.InspectableQueryCacher)
+	// q0 has expired, and q1 is the least recently accessed entry.
+	for i, key := range []string{"q0", "q1", "q2", "q3"} {
+		created := now
+		if i == 0 {
+			created = now.Add(-48 * time.Hour)
+		}
+		err := cache.Put(combinator.CacheEntry{
+			Key:      key,
+			Query:    key,
+			Created:  created,
+			Accessed: now.Add(time.Duration(i) * time.Minute),
+		}, combinator.NewDocuments(1, 2, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := cache.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 4 {
+		t.Fatalf("expected 4 entries, got %d", len(entries))
+	}
+	for _, e := range entries {
+		if e.Count != 3 {
+			t.Errorf("expected entry %s to contain 3 documents, got %d", e.Key, e.Count)
+		}
+	}
+
+	// The expired entry is evicted first, followed by the least recently accessed entry. Two entries fit within 90% of
+	// the maximum size.
+	cache = combinator.NewFileQueryCache(dir,
+		combinator.CacheTTL(24*time.Hour),
+		combinator.CacheMaxSize(entries[0].Size*5/2)).(combinator.InspectableQueryCacher)
+	n, err := cache.Prune()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 entries to be pruned, got %d", n)
+	}
+	entries, err = cache.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Key != "q2" || entries[1].Key != "q3" {
+		t.Errorf("expected entries q2 and q3 to remain, got %v", entries)
+	}
+
+	// Entries can be moved between caches.
+	var buf bytes.Buffer
+	if err := combinator.ExportCache(&buf, cache); err != nil {
+		t.Fatal(err)
+	}
+	other, err := ioutil.TempDir("", "groove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+	imported := combinator.NewFileQueryCache(other).(combinator.InspectableQueryCacher)
+	n, err = combinator.ImportCache(&buf, imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 entries to be imported, got %d", n)
+	}
+
+	// The entries were not cached under namespaced keys, so they must be migrated before they verify.
+	failed, err := combinator.VerifyCache(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 2 {
+		t.Errorf("expected 2 entries to fail verification, got %v", failed)
+	}
+	n, err = imported.Migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("expected 2 entries to be migrated, got %d", n)
+	}
+	failed, err = combinator.VerifyCache(imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) > 0 {
+		t.Errorf("expected imported entries to verify, got %v", failed)
+	}
+}
+
+func TestPruneCacheOnSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "groove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	kw := func(term string) cqr.CommonQueryRepresentation {
+		return cqr.NewKeyword(term, "title")
+	}
+	unbounded := combinator.NewFileQueryCache(dir).(combinator.InspectableQueryCacher)
+	if err := unbounded.Set(kw("a"), combinator.NewDocuments(1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := unbounded.Entries()
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := entries[0].Size
+
+	// Replacing an entry does not count towards the size of the cache twice.
+	cache := combinator.NewFileQueryCache(dir, combinator.CacheMaxSize(size*2))
+	for i := 0; i < 3; i++ {
+		if err := cache.Set(kw("a"), combinator.NewDocuments(1, 2, 3)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cache.Set(kw("b"), combinator.NewDocuments(1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	for _, term := range []string{"a", "b"} {
+		if _, err := cache.Get(kw(term)); err != nil {
+			t.Errorf("expected %s to be cached, got %v", term, err)
+		}
+	}
+
+	// Entries evicted when the cache grows too large are no longer read from memory. The cache is pruned to 90% of its
+	// maximum size, so only the newest entry remains.
+	if err := cache.Set(kw("c"), combinator.NewDocuments(1, 2, 3)); err != nil {
+		t.Fatal(err)
+	}
+	for _, term := range []string{"a", "b"} {
+		if _, err := cache.Get(kw(term)); err != combinator.ErrCacheMiss {
+			t.Errorf("expected %s to be evicted, got %v", term, err)
+		}
+	}
+	if _, err := cache.Get(kw("c")); err != nil {
+		t.Errorf("expected c to be cached, got %v", err)
+	}
+}
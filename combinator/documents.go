@@ -0,0 +1,226 @@
+package combinator
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"github.com/RoaringBitmap/roaring"
+	"github.com/hscells/groove/eval"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"strconv"
+)
+
+// documentsMagic prefixes the compressed on-disk encoding of documents, distinguishing it from the older flat-file
+// and gob encodings.
+var documentsMagic = []byte("GRB1")
+
+// Document is a document that has been retrieved.
+type Document uint32
+
+// String returns the string representation of the documents.
+func (d Document) String() string {
+	return fmt.Sprintf("%d", d)
+}
+
+// Documents are a group of retrieved documents. They are stored as a compressed (roaring) bitmap, so that the
+// intersection, union and difference of large sets of documents is cheap. The zero value is an empty set of
+// documents. Documents should be treated as immutable once they have been created, as they are shared by caches.
+type Documents struct {
+	bitmap *roaring.Bitmap
+}
+
+// NewDocuments creates a set of documents from document ids.
+func NewDocuments(ids ...Document) Documents {
+	b := roaring.NewBitmap()
+	for _, id := range ids {
+		b.Add(uint32(id))
+	}
+	return Documents{bitmap: b}
+}
+
+// NewDocumentsFromIDs creates a set of documents from the ids returned by a statistics source.
+func NewDocumentsFromIDs(ids []uint32) Documents {
+	b := roaring.BitmapOf(ids...)
+	b.RunOptimize()
+	return Documents{bitmap: b}
+}
+
+// RelevantDocuments creates the set of documents that are relevant for a topic, i.e. those with a grade greater than
+// eval.RelevanceGrade. Documents whose ids are not numeric are ignored.
+func RelevantDocuments(qrels trecresults.Qrels) Documents {
+	var ids []Document
+	for docID, qrel := range qrels {
+		if qrel.Score <= eval.RelevanceGrade {
+			continue
+		}
+		id, err := strconv.ParseUint(docID, 10, 32)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, Document(id))
+	}
+	return NewDocuments(ids...)
+}
+
+// b returns the underlying bitmap of the documents, which is never nil.
+func (d Documents) b() *roaring.Bitmap {
+	if d.bitmap == nil {
+		return roaring.NewBitmap()
+	}
+	return d.bitmap
+}
+
+// Len is the number of documents.
+func (d Documents) Len() int {
+	if d.bitmap == nil {
+		return 0
+	}
+	return int(d.bitmap.GetCardinality())
+}
+
+// Contains returns if the document is in the set of documents.
+func (d Documents) Contains(doc Document) bool {
+	if d.bitmap == nil {
+		return false
+	}
+	return d.bitmap.Contains(uint32(doc))
+}
+
+// IDs returns the ids of the documents in ascending order.
+func (d Documents) IDs() []uint32 {
+	if d.bitmap == nil {
+		return []uint32{}
+	}
+	return d.bitmap.ToArray()
+}
+
+// Slice returns the documents in ascending order.
+func (d Documents) Slice() []Document {
+	docs := make([]Document, 0, d.Len())
+	if d.bitmap == nil {
+		return docs
+	}
+	it := d.bitmap.Iterator()
+	for it.HasNext() {
+		docs = append(docs, Document(it.Next()))
+	}
+	return docs
+}
+
+// Intersect returns the documents that appear in both sets of documents.
+func (d Documents) Intersect(other Documents) Documents {
+	return Documents{bitmap: roaring.And(d.b(), other.b())}
+}
+
+// Union returns the documents that appear in either set of documents.
+func (d Documents) Union(other Documents) Documents {
+	return Documents{bitmap: roaring.Or(d.b(), other.b())}
+}
+
+// Difference returns the documents that do not appear in the other set of documents.
+func (d Documents) Difference(other Documents) Documents {
+	return Documents{bitmap: roaring.AndNot(d.b(), other.b())}
+}
+
+// Equals returns if both sets contain the same documents.
+func (d Documents) Equals(other Documents) bool {
+	return d.b().Equals(other.b())
+}
+
+// String returns the documents in the form `[1 2 3]`.
+func (d Documents) String() string {
+	return fmt.Sprint(d.Slice())
+}
+
+// Results converts the documents from the resulting logical operator tree into eval-compatible trec results.
+func (d Documents) Results(query pipeline.Query, run string) trecresults.ResultList {
+	r := make(trecresults.ResultList, 0, d.Len())
+	if d.bitmap == nil {
+		return r
+	}
+	it := d.bitmap.Iterator()
+	for i := 0; it.HasNext(); i++ {
+		r = append(r, &trecresults.Result{
+			Topic:     query.Topic,
+			Iteration: "Q0",
+			DocId:     strconv.Itoa(int(it.Next())),
+			Rank:      int64(i),
+			Score:     0,
+			RunName:   run,
+		})
+	}
+	return r
+}
+
+// Set creates a map from the documents.
+func (d Documents) Set() map[Document]struct{} {
+	m := make(map[Document]struct{}, d.Len())
+	for _, doc := range d.Slice() {
+		m[doc] = struct{}{}
+	}
+	return m
+}
+
+// MarshalBinary encodes the documents into their compressed on-disk format.
+func (d Documents) MarshalBinary() ([]byte, error) {
+	var buff bytes.Buffer
+	buff.Write(documentsMagic)
+	b := d.b().Clone()
+	b.RunOptimize()
+	if _, err := b.WriteTo(&buff); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// UnmarshalBinary decodes documents from their compressed on-disk format.
+func (d *Documents) UnmarshalBinary(data []byte) error {
+	if !bytes.HasPrefix(data, documentsMagic) {
+		return fmt.Errorf("documents are not in the compressed format")
+	}
+	b := roaring.NewBitmap()
+	if err := b.UnmarshalBinary(data[len(documentsMagic):]); err != nil {
+		return err
+	}
+	d.bitmap = b
+	return nil
+}
+
+// decodeDocuments decodes documents from any of the formats documents have been cached in: the compressed format,
+// the flat-file format of little-endian document ids, or the gob encoding of a slice of document ids.
+func decodeDocuments(data []byte, legacy func([]byte) ([]uint32, error)) (Documents, error) {
+	if len(data) == 0 {
+		return Documents{}, nil
+	}
+	if bytes.HasPrefix(data, documentsMagic) {
+		var d Documents
+		err := d.UnmarshalBinary(data)
+		return d, err
+	}
+	ids, err := legacy(data)
+	if err != nil {
+		return Documents{}, err
+	}
+	return NewDocumentsFromIDs(ids), nil
+}
+
+// flatFileIDs decodes the document ids of the flat-file format, where each id is a little-endian uint32.
+func flatFileIDs(b []byte) ([]uint32, error) {
+	if len(b)%4 != 0 {
+		return nil, fmt.Errorf("corrupt document file of %d bytes", len(b))
+	}
+	ids := make([]uint32, len(b)/4)
+	for i := range ids {
+		ids[i] = binary.LittleEndian.Uint32(b[i*4 : i*4+4])
+	}
+	return ids, nil
+}
+
+// gobIDs decodes the document ids of the gob format, where the ids were encoded as a slice.
+func gobIDs(b []byte) ([]uint32, error) {
+	var ids []uint32
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&ids)
+	return ids, err
+}
@@ -0,0 +1,207 @@
+package combinator_test
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/trecresults"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+)
+
+// leaves caches each set of documents as a keyword, and returns the atoms for them.
+func leaves(cache combinator.QueryCacher, sets ...combinator.Documents) []combinator.LogicalTreeNode {
+	nodes := make([]combinator.LogicalTreeNode, len(sets))
+	for i, docs := range sets {
+		kw := cqr.NewKeyword(fmt.Sprintf("term%d", i), "title")
+		if err := cache.Set(kw, docs); err != nil {
+			panic(err)
+		}
+		nodes[i] = combinator.NewAtom(kw)
+	}
+	return nodes
+}
+
+func TestOperators(t *testing.T) {
+	cache := combinator.NewMapQueryCache()
+	nodes := leaves(cache,
+		combinator.NewDocuments(1, 2, 3, 4),
+		combinator.NewDocuments(3, 4, 5),
+		combinator.NewDocuments(4, 6))
+
+	tests := []struct {
+		operator combinator.Operator
+		expected combinator.Documents
+	}{
+		{combinator.AndOperator, combinator.NewDocuments(4)},
+		{combinator.OrOperator, combinator.NewDocuments(1, 2, 3, 4, 5, 6)},
+		{combinator.NotOperator, combinator.NewDocuments(1, 2)},
+	}
+	for _, test := range tests {
+		docs, err := test.operator.Combine(nodes, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !docs.Equals(test.expected) {
+			t.Errorf("%s: expected %v, got %v", test.operator, test.expected, docs)
+		}
+	}
+
+	// Combining must not modify the cached documents.
+	if docs, _ := cache.Get(nodes[0].Query()); !docs.Equals(combinator.NewDocuments(1, 2, 3, 4)) {
+		t.Errorf("cached documents were modified: %v", docs)
+	}
+}
+
+func TestMissingAtoms(t *testing.T) {
+	q := pipeline.NewQuery("missing", "1", cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+		cqr.NewKeyword("heart", "title"),
+		cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+			cqr.NewKeyword("attack", "title"),
+			cqr.NewKeyword("failure", "title"),
+		}),
+	}))
+	ss := memorySource{map[string][]uint32{
+		"heart":   {1, 2, 3},
+		"attack":  {2, 3, 4},
+		"failure": {3},
+	}}
+	tree, _, err := combinator.NewLogicalTree(q, ss, combinator.NewMapQueryCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Evaluating the nodes of a tree with a cache that is missing atoms fails, with the path to the missing atom.
+	_, err = tree.Root.Documents(combinator.NewMapQueryCache())
+	e, ok := err.(*combinator.NodeError)
+	if !ok {
+		t.Fatalf("expected a node error, got %v", err)
+	}
+	if errors.Cause(err) != combinator.ErrCacheMiss {
+		t.Errorf("expected a cache miss, got %v", errors.Cause(err))
+	}
+	if len(e.Path) != 1 || e.Path[0] != 0 {
+		t.Errorf("expected the path [0], got %v", e.Path)
+	}
+
+	// Whereas evaluating the tree retrieves the missing atoms from the statistics source.
+	docs, err := tree.Documents(combinator.NewMapQueryCache())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !docs.Equals(combinator.NewDocuments(2, 3)) {
+		t.Errorf("expected [2 3], got %v", docs)
+	}
+}
+
+func TestFileQueryCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "groove")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	docs := combinator.NewDocuments(10, 20, 30, 1<<20)
+	kw := cqr.NewKeyword("heart", "title")
+	if err := combinator.NewFileQueryCache(dir).Set(kw, docs); err != nil {
+		t.Fatal(err)
+	}
+	got, err := combinator.NewFileQueryCache(dir).Get(kw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(docs) {
+		t.Errorf("expected %v, got %v", docs, got)
+	}
+
+	// Files written in the flat format of little-endian ids can still be read.
+	legacy := cqr.NewKeyword("attack", "title")
+	b := make([]byte, 12)
+	for i, id := range []uint32{5, 6, 7} {
+		binary.LittleEndian.PutUint32(b[i*4:], id)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, fmt.Sprintf("%v", combinator.HashCQR(legacy))), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = combinator.NewFileQueryCache(dir).Get(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(combinator.NewDocuments(5, 6, 7)) {
+		t.Errorf("expected [5 6 7], got %v", got)
+	}
+
+	// Results are not shared between namespaces.
+	_, err = combinator.NewFileQueryCache(dir, combinator.CacheNamespace("other")).Get(kw)
+	if err != combinator.ErrCacheMiss {
+		t.Errorf("expected a cache miss, got %v", err)
+	}
+}
+
+// syntheticDocuments creates n sets of documents, each containing size random ids from a collection of around 30
+// million documents (roughly the size of PubMed).
+func syntheticDocuments(n, size int) []combinator.Documents {
+	r := rand.New(rand.NewSource(1))
+	sets := make([]combinator.Documents, n)
+	for i := range sets {
+		ids := make([]uint32, size)
+		for j := range ids {
+			ids[j] = uint32(r.Intn(30000000))
+		}
+		sets[i] = combinator.NewDocumentsFromIDs(ids)
+	}
+	return sets
+}
+
+func benchmarkOperator(b *testing.B, operator combinator.Operator, n, size int) {
+	cache := combinator.NewMapQueryCache()
+	nodes := leaves(cache, syntheticDocuments(n, size)...)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		operator.Combine(nodes, cache)
+	}
+}
+
+func BenchmarkOr(b *testing.B)  { benchmarkOperator(b, combinator.OrOperator, 20, 1000000) }
+func BenchmarkAnd(b *testing.B) { benchmarkOperator(b, combinator.AndOperator, 20, 1000000) }
+func BenchmarkNot(b *testing.B) { benchmarkOperator(b, combinator.NotOperator, 20, 1000000) }
+
+func BenchmarkFileQueryCache(b *testing.B) {
+	dir, err := ioutil.TempDir("", "groove")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	docs := syntheticDocuments(1, 1000000)[0]
+	kw := cqr.NewKeyword("heart", "title")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// A new cache is created each time so that documents are read from disk rather than memory.
+		cache := combinator.NewFileQueryCache(dir)
+		if err := cache.Set(kw, docs); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := combinator.NewFileQueryCache(dir).Get(kw); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRelevantDocuments(t *testing.T) {
+	qrels := trecresults.Qrels{
+		"1":   &trecresults.Qrel{Topic: "1", DocId: "1", Score: 2},
+		"2":   &trecresults.Qrel{Topic: "1", DocId: "2", Score: 1},
+		"3":   &trecresults.Qrel{Topic: "1", DocId: "3", Score: 0},
+		"abc": &trecresults.Qrel{Topic: "1", DocId: "abc", Score: 2},
+	}
+	if rel := combinator.RelevantDocuments(qrels); !rel.Equals(combinator.NewDocuments(1)) {
+		t.Errorf("expected only document 1 to be relevant, got %v", rel)
+	}
+}
@@ -3,15 +3,12 @@ package combinator
 
 import (
 	"fmt"
+	"github.com/RoaringBitmap/roaring"
 	"github.com/hscells/cqr"
 	"github.com/hscells/groove/pipeline"
 	"github.com/hscells/groove/stats"
-	"github.com/hscells/trecresults"
 	"github.com/pkg/errors"
-	"github.com/xtgo/set"
 	"hash/crc64"
-	"math"
-	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -30,19 +27,21 @@ var (
 
 // Operator can combine different nodes of a tree together.
 type Operator interface {
-	Combine(clauses []LogicalTreeNode, cache QueryCacher) Documents
+	Combine(clauses []LogicalTreeNode, cache QueryCacher) (Documents, error)
 	String() string
 }
 
 // LogicalTree can compute the number of documents retrieved for atomic components.
 type LogicalTree struct {
 	Root LogicalTreeNode
+
+	source stats.StatisticsSource
 }
 
 // LogicalTreeNode is a node in a logical tree.
 type LogicalTreeNode interface {
 	Query() cqr.CommonQueryRepresentation
-	Documents(cache QueryCacher) Documents
+	Documents(cache QueryCacher) (Documents, error)
 	String() string
 }
 
@@ -69,24 +68,6 @@ type AdjAtom struct {
 	Clause
 }
 
-// Document is a document that has been retrieved.
-type Document uint32
-
-// Documents are a group of retrieved documents.
-type Documents []Document
-
-func (d Documents) Len() int {
-	return len(d)
-}
-
-func (d Documents) Less(i, j int) bool {
-	return d[i] < d[j]
-}
-
-func (d Documents) Swap(i, j int) {
-	d[i], d[j] = d[j], d[i]
-}
-
 // andOperator is the intersection of documents.
 type andOperator struct {
 }
@@ -99,171 +80,151 @@ type orOperator struct {
 type notOperator struct {
 }
 
-// Results converts the documents from the resulting logical operator tree into eval-compatible trec results.
-func (d Documents) Results(query pipeline.Query, run string) trecresults.ResultList {
-	r := make(trecresults.ResultList, len(d))
-	for i, doc := range d {
-		r[i] = &trecresults.Result{
-			Topic:     query.Topic,
-			Iteration: "Q0",
-			DocId:     strconv.Itoa(int(doc)),
-			Rank:      int64(i),
-			Score:     0,
-			RunName:   run,
-		}
-	}
-	return r
+// NodeError is an error that occurred while evaluating a node of a logical tree. The path is the position of the
+// failing node in the tree, as the index of each child descended into from the root.
+type NodeError struct {
+	Path  []int
+	Query cqr.CommonQueryRepresentation
+	Err   error
 }
 
-// Set creates a map from a slice of documents.
-func (d Documents) Set() map[Document]struct{} {
-	m := make(map[Document]struct{}, len(d))
-	for _, doc := range d {
-		m[doc] = struct{}{}
+// Error describes the failing node and its path.
+func (e *NodeError) Error() string {
+	path := make([]string, len(e.Path))
+	for i, p := range e.Path {
+		path[i] = strconv.Itoa(p)
 	}
-	return m
+	return fmt.Sprintf("evaluating node [%s] %s: %v", strings.Join(path, " "), e.Query, e.Err)
 }
 
-func (andOperator) Combine(nodes []LogicalTreeNode, cache QueryCacher) Documents {
-	if len(nodes) == 0 {
-		return Documents{}
+// Cause is the underlying error of the failing node.
+func (e *NodeError) Cause() error {
+	return e.Err
+}
+
+// childError prefixes the path of an error with the index of the child it occurred in.
+func childError(i int, node LogicalTreeNode, err error) error {
+	if e, ok := err.(*NodeError); ok {
+		return &NodeError{
+			Path:  append([]int{i}, e.Path...),
+			Query: e.Query,
+			Err:   e.Err,
+		}
 	}
-	if len(nodes) == 1 {
-		return nodes[0].Documents(cache)
+	var query cqr.CommonQueryRepresentation
+	if node != nil {
+		query = node.Query()
 	}
+	return &NodeError{Path: []int{i}, Query: query, Err: err}
+}
 
-	var wg sync.WaitGroup
-	docIDs := make([]Documents, len(nodes))
+// childBitmaps concurrently retrieves the documents of each of the nodes using the DefaultScheduler. If any of the
+// nodes fail, the error of the first of them is returned.
+func childBitmaps(nodes []LogicalTreeNode, cache QueryCacher) ([]*roaring.Bitmap, error) {
+	bitmaps := make([]*roaring.Bitmap, len(nodes))
+	errs := make([]error, len(nodes))
+	tasks := make([]func(), len(nodes))
 	for i, node := range nodes {
-		wg.Add(1)
-		go func(n LogicalTreeNode, j int) {
-			defer wg.Done()
-			docIDs[j] = n.Documents(cache)
-			if !sort.IsSorted(docIDs[j]) {
-				sort.Sort(docIDs[j])
+		i, node := i, node
+		tasks[i] = func() {
+			docs, err := node.Documents(cache)
+			if err != nil {
+				errs[i] = childError(i, node, err)
+				return
 			}
-		}(node, i)
+			bitmaps[i] = docs.b()
+		}
 	}
-	wg.Wait()
+	DefaultScheduler.Go(tasks...)
 
-	// initial set of nodes
-	docs := docIDs[0]
-	for i := 1; i < len(docIDs); i++ {
-		pivot := len(docs)
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return bitmaps, nil
+}
 
-		docs = append(docs, /* next */ docIDs[i]...)
+func (andOperator) Combine(nodes []LogicalTreeNode, cache QueryCacher) (Documents, error) {
+	if len(nodes) == 0 {
+		return Documents{}, nil
+	}
+	if len(nodes) == 1 {
+		docs, err := nodes[0].Documents(cache)
+		if err != nil {
+			return Documents{}, childError(0, nodes[0], err)
+		}
+		return docs, nil
+	}
 
-		size := set.Inter(docs, pivot)
-		docs = docs[:size]
+	bitmaps, err := childBitmaps(nodes, cache)
+	if err != nil {
+		return Documents{}, err
 	}
-	return docs
+	var docs Documents
+	DefaultScheduler.Compute(func() {
+		docs = Documents{bitmap: roaring.FastAnd(bitmaps...)}
+	})
+	return docs, nil
 }
 
 func (andOperator) String() string {
 	return "and"
 }
 
-func (orOperator) Combine(nodes []LogicalTreeNode, cache QueryCacher) Documents {
+func (orOperator) Combine(nodes []LogicalTreeNode, cache QueryCacher) (Documents, error) {
 	if len(nodes) == 0 {
-		return Documents{}
+		return Documents{}, nil
 	}
 	if len(nodes) == 1 {
 		if nodes[0] == nil {
-			return Documents{}
+			return Documents{}, nil
 		}
-		return nodes[0].Documents(cache)
-	}
-
-	totalDocs := 0
-	var docIds []Documents
-	var wg sync.WaitGroup
-	var mu2 sync.Mutex
-	for i, node := range nodes {
-		wg.Add(1)
-		go func(n LogicalTreeNode, j int) {
-			defer wg.Done()
-			d := n.Documents(cache)
-			if len(d) > 0 {
-				mu2.Lock()
-				docIds = append(docIds, d)
-				totalDocs += len(d)
-				mu2.Unlock()
-			}
-		}(node, i)
-	}
-	wg.Wait()
-
-	idx := make([]int, len(docIds))
-	docs := make(Documents, totalDocs)
-	k := 0
-	//var docs Documents
-	for len(docIds) > 0 {
-		j := -1
-		minDoc := Document(math.MaxUint32)
-		for i := 0; i < len(docIds); i++ {
-			ptr := idx[i]
-			d := docIds[i]
-			if d[ptr] < minDoc {
-				minDoc = d[ptr]
-				j = i
-			}
-		}
-		docs[k] = minDoc
-		k++
-		//docs = append(docs, minDoc)
-		idx[j]++
-
-		if idx[j] >= len(docIds[j]) {
-			// Delete the slice.
-			docIds = append(docIds[:j], docIds[j+1:]...)
-			idx = append(idx[:j], idx[j+1:]...)
+		docs, err := nodes[0].Documents(cache)
+		if err != nil {
+			return Documents{}, childError(0, nodes[0], err)
 		}
+		return docs, nil
 	}
 
-	//sort.Sort(docs)
-	size := set.Uniq(docs)
-	docs = docs[:size]
-	return docs
+	bitmaps, err := childBitmaps(nodes, cache)
+	if err != nil {
+		return Documents{}, err
+	}
+	var docs Documents
+	DefaultScheduler.Compute(func() {
+		docs = Documents{bitmap: roaring.FastOr(bitmaps...)}
+	})
+	return docs, nil
 }
 
 func (orOperator) String() string {
 	return "or"
 }
 
-func (notOperator) Combine(nodes []LogicalTreeNode, cache QueryCacher) Documents {
+func (notOperator) Combine(nodes []LogicalTreeNode, cache QueryCacher) (Documents, error) {
 	if len(nodes) == 0 {
-		return Documents{}
+		return Documents{}, nil
 	}
 	if len(nodes) == 1 {
-		return nodes[0].Documents(cache)
-	}
-
-	var a Documents
-	b := make([]map[Document]struct{}, len(nodes))
-
-	a = append(a, nodes[0].Documents(cache)...)
-
-	for i := 1; i < len(nodes); i++ {
-		b[i] = nodes[i].Documents(cache).Set()
-	}
-
-	// Now make b prime, comprising the docs not in a.
-	bP := make(map[Document]bool)
-	for i := 0; i < len(b); i++ {
-		for k := range b[i] {
-			bP[k] = true
+		docs, err := nodes[0].Documents(cache)
+		if err != nil {
+			return Documents{}, childError(0, nodes[0], err)
 		}
+		return docs, nil
 	}
 
-	// Relative compliment.
-	var docs Documents
-	for _, doc := range a {
-		if !bP[doc] {
-			docs = append(docs, doc)
-		}
+	bitmaps, err := childBitmaps(nodes, cache)
+	if err != nil {
+		return Documents{}, err
 	}
 
-	return docs
+	// Relative compliment of the first clause and the union of the rest.
+	var docs Documents
+	DefaultScheduler.Compute(func() {
+		docs = Documents{bitmap: roaring.AndNot(bitmaps[0], roaring.FastOr(bitmaps[1:]...))}
+	})
+	return docs, nil
 }
 
 func (notOperator) String() string {
@@ -276,7 +237,7 @@ func (c Combinator) Query() cqr.CommonQueryRepresentation {
 }
 
 // Documents returns the documents retrieved by the combinator.
-func (c Combinator) Documents(cache QueryCacher) Documents {
+func (c Combinator) Documents(cache QueryCacher) (Documents, error) {
 	return c.Combine(c.Clauses, cache)
 }
 
@@ -290,16 +251,15 @@ func (a Atom) Query() cqr.CommonQueryRepresentation {
 	return a.Clause.Query
 }
 
-// Documents returns the documents retrieved by the atom.
-func (a Atom) Documents(cache QueryCacher) Documents {
+// Documents returns the documents retrieved by the atom. The documents must be present in the cache, otherwise
+// an error wrapping ErrCacheMiss is returned; use NewFetchQueryCache to retrieve missing documents from a statistics
+// source instead.
+func (a Atom) Documents(cache QueryCacher) (Documents, error) {
 	docs, err := cache.Get(a.Clause.Query)
-	if err == ErrCacheMiss {
-		return Documents{}
-	}
 	if err != nil {
-		panic(err)
+		return Documents{}, &NodeError{Query: a.Clause.Query, Err: err}
 	}
-	return docs
+	return docs, nil
 }
 
 // String returns the query string.
@@ -312,16 +272,15 @@ func (a AdjAtom) Query() cqr.CommonQueryRepresentation {
 	return a.Clause.Query
 }
 
-// Documents returns the documents retrieved by the adjacency operator.
-func (a AdjAtom) Documents(cache QueryCacher) Documents {
+// Documents returns the documents retrieved by the adjacency operator. The documents must be present in the cache, otherwise
+// an error wrapping ErrCacheMiss is returned; use NewFetchQueryCache to retrieve missing documents from a statistics
+// source instead.
+func (a AdjAtom) Documents(cache QueryCacher) (Documents, error) {
 	docs, err := cache.Get(a.Clause.Query)
-	if err == ErrCacheMiss {
-		return Documents{}
-	}
 	if err != nil {
-		panic(err)
+		return Documents{}, &NodeError{Query: a.Clause.Query, Err: err}
 	}
-	return docs
+	return docs, nil
 }
 
 // String returns the query string.
@@ -329,11 +288,6 @@ func (a AdjAtom) String() string {
 	return a.Query().String()
 }
 
-// String returns the string representation of the documents.
-func (d Document) String() string {
-	return fmt.Sprintf("%d", d)
-}
-
 // NewAtom creates a new atom.
 func NewAtom(keyword cqr.Keyword) Atom {
 	return Atom{
@@ -378,7 +332,8 @@ func HashCQR(representation cqr.CommonQueryRepresentation) uint64 {
 }
 
 // constructTree creates a logical tree recursively by descending top down. If the operator of the query is unknown
-// (i.e. it is not one of `or`, `and`, `not`, or an `adj` operator) the default operator will be `or`.
+// (i.e. it is not one of `or`, `and`, `not`, or an `adj` operator) the default operator will be `or`. Adjacency
+// operators are resolved as atoms by the statistics source, which must implement stats.ProximityStatisticsSource.
 //
 // Note that once one tree has been constructed, the returned map can be used to save processing.
 func constructTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryCacher) (LogicalTreeNode, QueryCacher, error) {
@@ -391,12 +346,10 @@ func constructTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryCa
 	switch q := query.Query.(type) {
 	case cqr.Keyword:
 		// Return a seen clause.
-		var docs Documents
-
 		{
 			mu.Lock()
-			docs, err := seen.Get(q)
-			if err == nil && docs != nil {
+			_, err := seen.Get(q)
+			if err == nil {
 				mu.Unlock()
 				return NewAtom(q), seen, nil
 			} else if err != nil && err != ErrCacheMiss {
@@ -406,15 +359,15 @@ func constructTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryCa
 			mu.Unlock()
 		}
 
-		ids, err := stats.GetDocumentIDs(query, ss)
+		var ids []uint32
+		err := DefaultScheduler.Fetch(ss, func() (err error) {
+			ids, err = stats.GetDocumentIDs(query, ss)
+			return
+		})
 		if err != nil {
 			return nil, nil, err
 		}
-
-		docs = make(Documents, len(ids))
-		for i, id := range ids {
-			docs[i] = Document(id)
-		}
+		docs := NewDocumentsFromIDs(ids)
 
 		{
 			mu.Lock()
@@ -440,33 +393,10 @@ func constructTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryCa
 			operator = OrOperator
 		}
 
-		// We need to create a special case for adjacent clauses.
-		if strings.Contains(strings.ToLower(q.Operator), "adj") {
-			operator = AndOperator
-			//// Return a seen clause.
-			//docs, err := seen.Get(q)
-			//if err == nil && docs != nil {
-			//	return NewAdjAtom(q), seen, nil
-			//} else if err != nil && err != ErrCacheMiss {
-			//	return nil, nil, err
-			//}
-			//
-			//ids, err := stats.GetDocumentIDs(query, ss)
-			//if err != nil {
-			//	return nil, nil, err
-			//}
-			//
-			//docs = make(Documents, len(ids))
-			//for i, id := range ids {
-			//	docs[i] = Document(id)
-			//}
-			//
-			//a := NewAdjAtom(q)
-			//err = seen.Set(a.Query(), docs)
-			//if err != nil {
-			//	return nil, nil, err
-			//}
-			//return a, seen, nil
+		// Adjacent clauses cannot be computed from the documents of their keywords, so they are resolved as a whole
+		// by the statistics source.
+		if stats.IsProximityOperator(q.Operator) {
+			return constructAdjAtom(q, ss, seen)
 		}
 
 		// Otherwise, we can just perform the operation with a typical operator.
@@ -484,8 +414,75 @@ func constructTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryCa
 	return nil, nil, errors.New(fmt.Sprintf("supplied query is not supported: %s", query.Query))
 }
 
+// constructAdjAtom resolves an adjacency clause as a single atom using the native proximity support of the statistics
+// source. Statistics sources which cannot evaluate proximity result in an error wrapping stats.ErrProximityUnsupported,
+// rather than silently approximating the clause with a conjunction.
+func constructAdjAtom(q cqr.BooleanQuery, ss stats.StatisticsSource, seen QueryCacher) (LogicalTreeNode, QueryCacher, error) {
+	// Return a seen clause.
+	{
+		mu.Lock()
+		_, err := seen.Get(q)
+		if err == nil {
+			mu.Unlock()
+			return NewAdjAtom(q), seen, nil
+		} else if err != nil && err != ErrCacheMiss {
+			mu.Unlock()
+			return nil, nil, err
+		}
+		mu.Unlock()
+	}
+
+	docs, err := fetchDocuments(q, ss)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	a := NewAdjAtom(q)
+	err = seen.Set(a.Query(), docs)
+	if err != nil {
+		return nil, nil, err
+	}
+	return a, seen, nil
+}
+
+// fetchDocuments retrieves the documents of an atom from the statistics source. Keywords are executed as regular
+// queries, and adjacency clauses are resolved using the native proximity support of the statistics source. Requests
+// to the statistics source are limited by the DefaultScheduler.
+func fetchDocuments(query cqr.CommonQueryRepresentation, ss stats.StatisticsSource) (Documents, error) {
+	var ids []uint32
+	switch q := query.(type) {
+	case cqr.Keyword:
+		err := DefaultScheduler.Fetch(ss, func() (err error) {
+			ids, err = stats.GetDocumentIDs(pipeline.NewQuery("", "", q), ss)
+			return
+		})
+		if err != nil {
+			return Documents{}, err
+		}
+		return NewDocumentsFromIDs(ids), nil
+	case cqr.BooleanQuery:
+		ps, ok := stats.ProximitySource(ss)
+		if !ok {
+			return Documents{}, errors.Wrapf(stats.ErrProximityUnsupported, "cannot resolve adjacency clause %s", q)
+		}
+		err := DefaultScheduler.Fetch(ss, func() (err error) {
+			ids, err = ps.ProximityDocumentIDs(q)
+			return
+		})
+		if err != nil {
+			return Documents{}, err
+		}
+		return NewDocumentsFromIDs(ids), nil
+	}
+	return Documents{}, errors.Errorf("supplied query is not supported: %s", query)
+}
+
 // NewLogicalTree creates a new logical tree.  If the operator of the query is unknown
-// (i.e. it is not one of `or`, `and`, `not`, or an `adj` operator) the default operator will be `or`.
+// (i.e. it is not one of `or`, `and`, `not`, or an `adj` operator) the default operator will be `or`. Adjacency
+// operators are resolved as atoms by the statistics source, and an error wrapping stats.ErrProximityUnsupported is
+// returned if the statistics source cannot evaluate them.
 //
 // Note that once one tree has been constructed, the returned map can be used to save processing.
 func NewLogicalTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryCacher) (LogicalTree, QueryCacher, error) {
@@ -497,15 +494,76 @@ func NewLogicalTree(query pipeline.Query, ss stats.StatisticsSource, seen QueryC
 		return LogicalTree{}, nil, err
 	}
 	return LogicalTree{
-		Root: root,
+		Root:   root,
+		source: ss,
 	}, seen, nil
 }
 
-// Documents returns the documents that the tree (query) would return if executed.
-func (root LogicalTree) Documents(cache QueryCacher) Documents {
+// Documents returns the documents that the tree (query) would return if executed. Atoms missing from the cache are
+// retrieved from the statistics source the tree was created with.
+func (root LogicalTree) Documents(cache QueryCacher) (Documents, error) {
+	if root.source != nil {
+		cache = NewFetchQueryCache(cache, root.source)
+	}
 	return root.Root.Documents(cache)
 }
 
+// EvaluatedNode is a node of a logical tree annotated with the documents it retrieves, so that operators can combine
+// the documents of clauses without evaluating them again. Children are the evaluated clauses of a combinator, and
+// Parent is the combinator the node is a clause of (if any).
+type EvaluatedNode struct {
+	LogicalTreeNode
+	Docs     Documents
+	Children []*EvaluatedNode
+	Parent   *EvaluatedNode
+}
+
+// NewEvaluatedNode annotates a node with the documents it has already retrieved.
+func NewEvaluatedNode(node LogicalTreeNode, docs Documents) *EvaluatedNode {
+	return &EvaluatedNode{LogicalTreeNode: node, Docs: docs}
+}
+
+// Documents returns the documents already retrieved for the node.
+func (e *EvaluatedNode) Documents(cache QueryCacher) (Documents, error) {
+	return e.Docs, nil
+}
+
+// Evaluate retrieves the documents of every node of a tree, once. The documents of atoms are read from the cache; use
+// NewFetchQueryCache to retrieve atoms that are missing from it.
+func Evaluate(node LogicalTreeNode, cache QueryCacher) (*EvaluatedNode, error) {
+	return evaluateNode(node, nil, cache)
+}
+
+func evaluateNode(node LogicalTreeNode, parent *EvaluatedNode, cache QueryCacher) (*EvaluatedNode, error) {
+	e := &EvaluatedNode{LogicalTreeNode: node, Parent: parent}
+	c, ok := node.(Combinator)
+	if !ok {
+		docs, err := node.Documents(cache)
+		if err != nil {
+			return nil, err
+		}
+		e.Docs = docs
+		return e, nil
+	}
+
+	e.Children = make([]*EvaluatedNode, len(c.Clauses))
+	clauses := make([]LogicalTreeNode, len(c.Clauses))
+	for i, clause := range c.Clauses {
+		child, err := evaluateNode(clause, e, cache)
+		if err != nil {
+			return nil, childError(i, clause, err)
+		}
+		e.Children[i] = child
+		clauses[i] = child
+	}
+	docs, err := c.Combine(clauses, cache)
+	if err != nil {
+		return nil, err
+	}
+	e.Docs = docs
+	return e, nil
+}
+
 // ToCQR creates a query backwards from a logical tree.
 func (root LogicalTree) ToCQR() cqr.CommonQueryRepresentation {
 	switch c := root.Root.(type) {
@@ -181,5 +181,9 @@ func TestLogicalTree(t *testing.T) {
 	fmt.Println("retrieval size:", s)
 	//fmt.Println("r:", len(r))
 	fmt.Println("combining tree nodes")
-	fmt.Println("tree:", len(tree.Documents(cache)))
+	docs, err := tree.Documents(cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fmt.Println("tree:", docs.Len())
 }
@@ -0,0 +1,306 @@
+package combinator
+
+import (
+	"github.com/RoaringBitmap/roaring"
+	"github.com/hashicorp/golang-lru"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/stats"
+	"math"
+	"sort"
+)
+
+// Planner optimises logical trees before they are evaluated. Nested clauses with the same operator are flattened,
+// duplicate clauses are removed, and the clauses of `and` operators are ordered from most to least selective, so that
+// evaluation can stop as soon as an intersection is empty. The documents retrieved by combinators are memoised, so
+// that subtrees shared by the queries of different topics are only evaluated once. Optimising a tree never changes
+// the documents it retrieves.
+//
+// Memoised documents are identified only by the canonical query of the subtree, so a planner should not be shared
+// between trees that retrieve documents from different collections.
+type Planner struct {
+	ss   stats.StatisticsSource
+	memo *lru.Cache
+}
+
+// NewPlanner creates a new planner which memoises the documents of up to size subtrees. The statistics source is used
+// to estimate the selectivity of clauses which are not already in the cache, and may be nil.
+func NewPlanner(ss stats.StatisticsSource, size int) *Planner {
+	c, err := lru.New(size)
+	if err != nil {
+		panic(err)
+	}
+	return &Planner{
+		ss:   ss,
+		memo: c,
+	}
+}
+
+// Optimise rewrites a logical tree into an equivalent tree that is cheaper to evaluate.
+func (p *Planner) Optimise(tree LogicalTree, cache QueryCacher) (LogicalTree, error) {
+	root, err := p.optimise(tree.Root, cache)
+	if err != nil {
+		return LogicalTree{}, err
+	}
+	return LogicalTree{
+		Root:   root,
+		source: tree.source,
+	}, nil
+}
+
+// optimise flattens, de-duplicates, and orders the clauses of a node.
+func (p *Planner) optimise(node LogicalTreeNode, cache QueryCacher) (LogicalTreeNode, error) {
+	c, ok := node.(Combinator)
+	if !ok {
+		return node, nil
+	}
+
+	var clauses []LogicalTreeNode
+	seen := make(map[string]bool)
+	for i, clause := range c.Clauses {
+		if clause == nil {
+			clauses = append(clauses, clause)
+			continue
+		}
+		o, err := p.optimise(clause, cache)
+		if err != nil {
+			return nil, childError(i, clause, err)
+		}
+
+		// Clauses nested inside the same (associative) operator can be lifted into this one.
+		candidates := []LogicalTreeNode{o}
+		if oc, ok := o.(Combinator); ok && oc.Operator == c.Operator && c.Operator != NotOperator {
+			candidates = oc.Clauses
+		}
+
+		for _, candidate := range candidates {
+			// The first clause of a `not` is what the other clauses are removed from, so it cannot be a duplicate.
+			if c.Operator == NotOperator && len(clauses) == 0 {
+				clauses = append(clauses, candidate)
+				continue
+			}
+			key := canonicalQuery(candidate.Query())
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			clauses = append(clauses, candidate)
+		}
+	}
+
+	if c.Operator == AndOperator {
+		var err error
+		clauses, err = p.order(clauses, cache)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	query := c.Clause.Query
+	if q, ok := query.(cqr.BooleanQuery); ok {
+		q.Children = make([]cqr.CommonQueryRepresentation, len(clauses))
+		for i, clause := range clauses {
+			if clause != nil {
+				q.Children[i] = clause.Query()
+			}
+		}
+		query = q
+	}
+	return Combinator{
+		Operator: c.Operator,
+		Clause: Clause{
+			Hash:  HashCQR(query),
+			Query: query,
+		},
+		Clauses: clauses,
+	}, nil
+}
+
+// order sorts clauses by their estimated number of retrieved documents, smallest first. The number of documents is
+// known exactly for atoms in the cache and memoised subtrees. Other combinators are estimated from the documents of the
+// atoms and subtrees nested in them, and only the atoms that are not cached are estimated using the statistics source.
+// Clauses that cannot be estimated are placed last, in their original order.
+func (p *Planner) order(clauses []LogicalTreeNode, cache QueryCacher) ([]LogicalTreeNode, error) {
+	estimates := make([]float64, len(clauses))
+	var (
+		unknown []int
+		queries []cqr.CommonQueryRepresentation
+	)
+	for i, clause := range clauses {
+		estimates[i] = p.estimate(clause, cache)
+		if _, ok := clause.(Combinator); !ok && clause != nil && math.IsInf(estimates[i], 1) {
+			unknown = append(unknown, i)
+			queries = append(queries, clause.Query())
+		}
+	}
+
+	if p.ss != nil && len(queries) > 0 {
+		sizes, err := stats.RetrievalSizes(p.ss, queries)
+		if err != nil {
+			return nil, err
+		}
+		for j, i := range unknown {
+			estimates[i] = sizes[j]
+		}
+	}
+
+	idx := make([]int, len(clauses))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.SliceStable(idx, func(i, j int) bool {
+		return estimates[idx[i]] < estimates[idx[j]]
+	})
+	ordered := make([]LogicalTreeNode, len(clauses))
+	for i, j := range idx {
+		ordered[i] = clauses[j]
+	}
+	return ordered, nil
+}
+
+// estimate is an upper bound on the number of documents a clause retrieves, computed without making any requests: an
+// `and` retrieves at most as many documents as its smallest clause, a `not` at most as many as its first clause, and
+// an `or` at most the sum of its clauses. Clauses that cannot be estimated (i.e. atoms that are not cached) are
+// infinite.
+func (p *Planner) estimate(node LogicalTreeNode, cache QueryCacher) float64 {
+	if node == nil {
+		return math.Inf(1)
+	}
+	c, ok := node.(Combinator)
+	if !ok {
+		if cache != nil {
+			if d, err := cache.Get(node.Query()); err == nil {
+				return float64(d.Len())
+			}
+		}
+		return math.Inf(1)
+	}
+	if d, ok := p.memo.Get(canonicalQuery(c.Query())); ok {
+		return float64(d.(Documents).Len())
+	}
+	if len(c.Clauses) == 0 {
+		return 0
+	}
+
+	switch c.Operator {
+	case AndOperator:
+		n := math.Inf(1)
+		for _, clause := range c.Clauses {
+			n = math.Min(n, p.estimate(clause, cache))
+		}
+		return n
+	case NotOperator:
+		return p.estimate(c.Clauses[0], cache)
+	default:
+		var n float64
+		for _, clause := range c.Clauses {
+			n += p.estimate(clause, cache)
+		}
+		return n
+	}
+}
+
+// Documents evaluates a (typically optimised) tree. Atoms missing from the cache are retrieved from the statistics
+// source the tree was created with.
+func (p *Planner) Documents(tree LogicalTree, cache QueryCacher) (Documents, error) {
+	if tree.source != nil {
+		cache = NewFetchQueryCache(cache, tree.source)
+	}
+	return p.documents(tree.Root, cache)
+}
+
+// documents evaluates a node, stopping early when the result is known to be empty.
+func (p *Planner) documents(node LogicalTreeNode, cache QueryCacher) (Documents, error) {
+	c, ok := node.(Combinator)
+	if !ok {
+		return node.Documents(cache)
+	}
+	key := canonicalQuery(c.Query())
+	if d, ok := p.memo.Get(key); ok {
+		return d.(Documents), nil
+	}
+
+	var docs Documents
+	switch {
+	case c.Operator == AndOperator && len(c.Clauses) > 1:
+		// Clauses are intersected one at a time, in order, so that the remaining clauses need not be evaluated once
+		// the intersection is empty.
+		var b *roaring.Bitmap
+		for i, clause := range c.Clauses {
+			d, err := p.documents(clause, cache)
+			if err != nil {
+				return Documents{}, childError(i, clause, err)
+			}
+			if b == nil {
+				b = d.b()
+			} else {
+				DefaultScheduler.Compute(func() {
+					b = roaring.And(b, d.b())
+				})
+			}
+			if b.IsEmpty() {
+				break
+			}
+		}
+		docs = Documents{bitmap: b}
+	case c.Operator == NotOperator && len(c.Clauses) > 1:
+		// Nothing can be removed from an empty set of documents.
+		first, err := p.documents(c.Clauses[0], cache)
+		if err != nil {
+			return Documents{}, childError(0, c.Clauses[0], err)
+		}
+		if first.Len() == 0 {
+			docs = Documents{}
+			break
+		}
+		rest, err := p.evaluate(c.Clauses[1:], 1, cache)
+		if err != nil {
+			return Documents{}, err
+		}
+		docs, err = c.Combine(append([]LogicalTreeNode{NewEvaluatedNode(c.Clauses[0], first)}, rest...), cache)
+		if err != nil {
+			return Documents{}, err
+		}
+	default:
+		clauses, err := p.evaluate(c.Clauses, 0, cache)
+		if err != nil {
+			return Documents{}, err
+		}
+		docs, err = c.Combine(clauses, cache)
+		if err != nil {
+			return Documents{}, err
+		}
+	}
+
+	p.memo.Add(key, docs)
+	return docs, nil
+}
+
+// evaluate concurrently evaluates each of the clauses using the DefaultScheduler. The clauses start at the offset of
+// the children of their parent.
+func (p *Planner) evaluate(clauses []LogicalTreeNode, offset int, cache QueryCacher) ([]LogicalTreeNode, error) {
+	nodes := make([]LogicalTreeNode, len(clauses))
+	errs := make([]error, len(clauses))
+	var tasks []func()
+	for i, clause := range clauses {
+		if clause == nil {
+			continue
+		}
+		i, clause := i, clause
+		tasks = append(tasks, func() {
+			d, err := p.documents(clause, cache)
+			if err != nil {
+				errs[i] = childError(i+offset, clause, err)
+				return
+			}
+			nodes[i] = NewEvaluatedNode(clause, d)
+		})
+	}
+	DefaultScheduler.Go(tasks...)
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return nodes, nil
+}
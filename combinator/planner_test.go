@@ -0,0 +1,101 @@
+package combinator_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/pipeline"
+	"testing"
+)
+
+func TestPlanner(t *testing.T) {
+	ss := memorySource{map[string][]uint32{
+		"a": {1, 2, 3, 4, 5, 6},
+		"b": {2, 3, 4},
+		"c": {3, 4, 7},
+		"d": {4},
+		"e": {8, 9},
+	}}
+	kw := func(term string) cqr.CommonQueryRepresentation {
+		return cqr.NewKeyword(term, "title")
+	}
+	queries := []cqr.CommonQueryRepresentation{
+		// a AND (b AND c) AND a
+		cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+			kw("a"),
+			cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{kw("b"), kw("c")}),
+			kw("a"),
+		}),
+		// (a OR (d OR e)) NOT (b OR b)
+		cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{
+			cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{
+				kw("a"),
+				cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{kw("d"), kw("e")}),
+			}),
+			cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{kw("b"), kw("b")}),
+		}),
+		// e AND d AND (a OR b)
+		cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{
+			kw("e"),
+			kw("d"),
+			cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{kw("a"), kw("b")}),
+		}),
+	}
+
+	planner := combinator.NewPlanner(ss, 100)
+	for i, q := range queries {
+		cache := combinator.NewMapQueryCache()
+		tree, _, err := combinator.NewLogicalTree(pipeline.NewQuery("plan", "1", q), ss, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := tree.Documents(cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		optimised, err := planner.Optimise(tree, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		docs, err := planner.Documents(optimised, cache)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !docs.Equals(expected) {
+			t.Errorf("query %d: expected %v, got %v", i, expected, docs)
+		}
+	}
+
+	// The nested conjunction is flattened, the duplicate removed, and the clauses ordered by selectivity.
+	cache := combinator.NewMapQueryCache()
+	tree, _, err := combinator.NewLogicalTree(pipeline.NewQuery("plan", "1", queries[0]), ss, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimised, err := planner.Optimise(tree, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := optimised.Root.(combinator.Combinator)
+	if len(root.Clauses) != 3 {
+		t.Fatalf("expected 3 clauses, got %d", len(root.Clauses))
+	}
+	if root.Clauses[2].Query().(cqr.Keyword).QueryString != "a" {
+		t.Errorf("expected the least selective clause last, got %v", root.Clauses[2].Query())
+	}
+
+	// Nested clauses are ordered by estimating them from the cached atoms they contain, rather than by asking the
+	// statistics source (which would estimate them to retrieve nothing).
+	tree, _, err = combinator.NewLogicalTree(pipeline.NewQuery("plan", "1", queries[2]), ss, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	optimised, err = planner.Optimise(tree, cache)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root = optimised.Root.(combinator.Combinator)
+	if _, ok := root.Clauses[2].(combinator.Combinator); !ok {
+		t.Errorf("expected the disjunction last, got %v", root.Clauses[2].Query())
+	}
+}
@@ -0,0 +1,135 @@
+package combinator
+
+import (
+	"fmt"
+	"github.com/hscells/groove/stats"
+	"runtime"
+	"sync"
+)
+
+// DefaultScheduler is the scheduler shared by every logical tree when evaluating clauses, fetching documents from a
+// statistics source and combining them with set operations.
+var DefaultScheduler = NewScheduler()
+
+// Scheduler bounds the work performed when evaluating logical trees. The number of goroutines used to evaluate
+// clauses concurrently, the number of concurrent requests made to each backend, and the number of concurrent
+// (CPU-bound) set operations are all limited separately.
+//
+// When every worker is busy, clauses are evaluated by the goroutine that requested them rather than waiting for a
+// worker, so nested clauses can never deadlock waiting on their parents.
+type Scheduler struct {
+	workers  chan struct{}
+	compute  chan struct{}
+	fetches  int
+	backends map[string]int
+	limits   map[string]chan struct{}
+	mu       sync.Mutex
+}
+
+// atLeast bounds a limit of a scheduler from below, so that a limit of zero cannot block forever.
+func atLeast(n, min int) int {
+	if n < min {
+		return min
+	}
+	return n
+}
+
+// SchedulerWorkers sets the number of goroutines used to evaluate clauses concurrently. With no workers, every clause
+// is evaluated by the goroutine that requested it.
+func SchedulerWorkers(n int) func(s *Scheduler) {
+	return func(s *Scheduler) {
+		s.workers = make(chan struct{}, atLeast(n, 0))
+	}
+}
+
+// SchedulerComputations sets the number of set operations that can be performed concurrently (at least one).
+func SchedulerComputations(n int) func(s *Scheduler) {
+	return func(s *Scheduler) {
+		s.compute = make(chan struct{}, atLeast(n, 1))
+	}
+}
+
+// SchedulerFetches sets the number of concurrent requests that can be made to a backend (at least one), unless it has
+// been configured with SchedulerBackendFetches.
+func SchedulerFetches(n int) func(s *Scheduler) {
+	return func(s *Scheduler) {
+		s.fetches = atLeast(n, 1)
+	}
+}
+
+// SchedulerBackendFetches sets the number of concurrent requests that can be made to the backend of a statistics
+// source (at least one). The limit applies to every statistics source of the same type.
+func SchedulerBackendFetches(ss stats.StatisticsSource, n int) func(s *Scheduler) {
+	return func(s *Scheduler) {
+		s.backends[backend(ss)] = atLeast(n, 1)
+	}
+}
+
+// NewScheduler creates a new scheduler. By default, there are four workers and one concurrent request to each
+// backend per CPU, and one concurrent set operation per CPU.
+func NewScheduler(options ...func(s *Scheduler)) *Scheduler {
+	s := &Scheduler{
+		workers:  make(chan struct{}, runtime.NumCPU()*4),
+		compute:  make(chan struct{}, runtime.NumCPU()),
+		fetches:  runtime.NumCPU(),
+		backends: make(map[string]int),
+		limits:   make(map[string]chan struct{}),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s
+}
+
+// backend identifies the backend of a statistics source by its type, or the type of the source it wraps.
+func backend(ss stats.StatisticsSource) string {
+	return fmt.Sprintf("%T", stats.Underlying(ss))
+}
+
+// Go runs the tasks concurrently, and waits for all of them to complete.
+func (s *Scheduler) Go(tasks ...func()) {
+	var wg sync.WaitGroup
+	for _, task := range tasks {
+		select {
+		case s.workers <- struct{}{}:
+			wg.Add(1)
+			go func(t func()) {
+				defer func() {
+					<-s.workers
+					wg.Done()
+				}()
+				t()
+			}(task)
+		default:
+			task()
+		}
+	}
+	wg.Wait()
+}
+
+// Compute performs a set operation once one is allowed to run.
+func (s *Scheduler) Compute(f func()) {
+	s.compute <- struct{}{}
+	defer func() { <-s.compute }()
+	f()
+}
+
+// Fetch makes a request to the backend of a statistics source once one is allowed to be made.
+func (s *Scheduler) Fetch(ss stats.StatisticsSource, f func() error) error {
+	b := backend(ss)
+	s.mu.Lock()
+	limit, ok := s.limits[b]
+	if !ok {
+		n, ok := s.backends[b]
+		if !ok {
+			n = s.fetches
+		}
+		limit = make(chan struct{}, n)
+		s.limits[b] = limit
+	}
+	s.mu.Unlock()
+
+	limit <- struct{}{}
+	defer func() { <-limit }()
+	return f()
+}
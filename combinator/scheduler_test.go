@@ -0,0 +1,86 @@
+package combinator_test
+
+import (
+	"github.com/hscells/groove/combinator"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestScheduler(t *testing.T) {
+	ss := memorySource{}
+	s := combinator.NewScheduler(
+		combinator.SchedulerWorkers(2),
+		combinator.SchedulerFetches(4),
+		combinator.SchedulerBackendFetches(ss, 1))
+
+	var running, max int32
+	fetch := func() error {
+		n := atomic.AddInt32(&running, 1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		atomic.AddInt32(&running, -1)
+		return nil
+	}
+
+	// Nested tasks must not deadlock when every worker is busy.
+	var mu sync.Mutex
+	done := 0
+	var tasks []func()
+	for i := 0; i < 8; i++ {
+		tasks = append(tasks, func() {
+			var nested []func()
+			for j := 0; j < 4; j++ {
+				nested = append(nested, func() {
+					if err := s.Fetch(ss, fetch); err != nil {
+						t.Error(err)
+					}
+					mu.Lock()
+					done++
+					mu.Unlock()
+				})
+			}
+			s.Go(nested...)
+		})
+	}
+	s.Go(tasks...)
+
+	if done != 32 {
+		t.Errorf("expected 32 tasks to complete, got %d", done)
+	}
+	if max != 1 {
+		t.Errorf("expected at most 1 concurrent fetch, got %d", max)
+	}
+}
+
+func TestSchedulerZeroLimits(t *testing.T) {
+	ss := memorySource{}
+	s := combinator.NewScheduler(
+		combinator.SchedulerWorkers(-1),
+		combinator.SchedulerComputations(0),
+		combinator.SchedulerFetches(0),
+		combinator.SchedulerBackendFetches(ss, -1))
+
+	done := make(chan struct{})
+	go func() {
+		s.Go(func() {
+			s.Compute(func() {})
+			if err := s.Fetch(ss, func() error { return nil }); err != nil {
+				t.Error(err)
+			}
+		})
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected a scheduler with zero limits not to block")
+	}
+}
@@ -4,6 +4,7 @@ package formulation
 import (
 	"fmt"
 	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
 	"github.com/hscells/groove/pipeline"
 	"github.com/hscells/groove/stats"
 	"github.com/hscells/trecresults"
@@ -61,6 +62,7 @@ type ObjectiveFormulator struct {
 	splitter       Splitter
 	analyser       TermAnalyser
 	postProcessing []PostProcess
+	mesh           *analysis.MeSH
 }
 
 type ObjectiveOption func(o *ObjectiveFormulator)
@@ -85,6 +87,13 @@ func ObjectiveAnalyser(analyser TermAnalyser) ObjectiveOption {
 	}
 }
 
+// ObjectiveMeSH sets the MeSH tree used to categorise MeSH headings (the default MeSH tree is used otherwise).
+func ObjectiveMeSH(mesh *analysis.MeSH) ObjectiveOption {
+	return func(o *ObjectiveFormulator) {
+		o.mesh = mesh
+	}
+}
+
 func ObjectivePostProcessing(processes ...PostProcess) ObjectiveOption {
 	return func(o *ObjectiveFormulator) {
 		o.postProcessing = processes
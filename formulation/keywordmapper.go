@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"github.com/hscells/cqr"
 	"github.com/hscells/cui2vec"
+	"github.com/hscells/groove/analysis"
 	"github.com/hscells/guru"
-	"github.com/hscells/meshexp"
 	"github.com/hscells/metawrap"
 	"github.com/hscells/transmute/fields"
 	"strings"
@@ -67,15 +67,16 @@ func Alias(mapping cui2vec.AliasMapping) MetaMapMapper {
 	}
 }
 
-// MeSHMapper uses the output of another MetaMap mapper to assign MeSH terms.
-func MeSHMapper(mapper MetaMapMapper) MetaMapMapper {
+// MeSHMapper uses the output of another MetaMap mapper to assign MeSH terms that are in the MeSH tree (or the default
+// MeSH tree if it is nil).
+func MeSHMapper(mapper MetaMapMapper, mesh *analysis.MeSH) MetaMapMapper {
 	return func(keyword cqr.Keyword) (representations []cqr.CommonQueryRepresentation, e error) {
-		mt, err := meshexp.Default()
-		if e != nil {
+		mt, err := analysis.MeSHOrDefault(mesh)
+		if err != nil {
 			return nil, err
 		}
 		keywords, err := mapper(keyword)
-		if e != nil {
+		if err != nil {
 			return nil, err
 		}
 		for i := 0; i < len(keywords); i++ {
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
 	"github.com/hscells/ghost"
 	"github.com/hscells/groove/combinator"
 	"github.com/hscells/groove/eval"
@@ -12,7 +13,6 @@ import (
 	"github.com/hscells/groove/preprocess"
 	"github.com/hscells/groove/stats"
 	"github.com/hscells/guru"
-	"github.com/hscells/meshexp"
 	"github.com/hscells/metawrap"
 	"github.com/hscells/transmute/fields"
 	"github.com/hscells/trecresults"
@@ -493,8 +493,12 @@ func FilterQueryTerms(conditions, treatments, studyTypes []string, field string,
 			if err != nil {
 				return nil, nil, nil, err
 			}
+			docs, err := tree.Documents(fileCache)
+			if err != nil {
+				return nil, nil, nil, err
+			}
 			var relret []string
-			for _, result := range tree.Documents(fileCache).Results(pq, "0") {
+			for _, result := range docs.Results(pq, "0") {
 				if _, ok := development[result.DocId]; ok {
 					relret = append(relret, result.DocId)
 				}
@@ -688,7 +692,7 @@ func MakeQrels(docs []guru.MedlineDocument) trecresults.Qrels {
 	return qrels
 }
 
-func addMeSHTerms(conditions, treatments, studyTypes []cqr.Keyword, dev []guru.MedlineDocument, e stats.EntrezStatisticsSource, topic string, k int, folder string) ([]cqr.Keyword, []cqr.Keyword, []cqr.Keyword, error) {
+func addMeSHTerms(conditions, treatments, studyTypes []cqr.Keyword, dev []guru.MedlineDocument, e stats.EntrezStatisticsSource, mesh *analysis.MeSH, topic string, k int, folder string) ([]cqr.Keyword, []cqr.Keyword, []cqr.Keyword, error) {
 	subheadingsFreq := make(map[string]int)
 	for _, doc := range dev {
 		for _, mh := range doc.MH {
@@ -741,7 +745,7 @@ func addMeSHTerms(conditions, treatments, studyTypes []cqr.Keyword, dev []guru.M
 		panic(err)
 	}
 
-	tree, err := meshexp.Default()
+	tree, err := analysis.MeSHOrDefault(mesh)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -786,32 +790,16 @@ func addMeSHTerms(conditions, treatments, studyTypes []cqr.Keyword, dev []guru.M
 	return conditions, treatments, studyTypes, nil
 }
 
-func classifyMeSHCategory(mh string, tree *meshexp.MeSHTree) []queryCategory {
-	references := tree.Reference(mh)
-	fmt.Println(mh)
-	seen := make(map[uint8]bool)
+func classifyMeSHCategory(mh string, tree *analysis.MeSH) []queryCategory {
 	var cats []queryCategory
-	for _, ref := range references {
-		c := ref.TreeLocation[0][0]
-		fmt.Println("->", string(c), ref.MedicalSubjectHeading)
+	for _, c := range tree.Categories(mh) {
 		switch c {
-		case 'A', 'B', 'C', 'F', 'G', 'H', 'M':
-			if _, ok := seen[c]; !ok {
-				seen[c] = true
-				cats = append(cats, condition)
-			}
-		case 'D', 'E':
-			if _, ok := seen[c]; !ok {
-				seen[c] = true
-				cats = append(cats, treatment)
-			}
-		case 'L', 'V', 'Z':
-			if _, ok := seen[c]; !ok {
-				seen[c] = true
-				cats = append(cats, studyType)
-			}
-		case 'I', 'J', 'K', 'N':
-			continue
+		case analysis.ConditionMeSH:
+			cats = append(cats, condition)
+		case analysis.TreatmentMeSH:
+			cats = append(cats, treatment)
+		case analysis.StudyTypeMeSH:
+			cats = append(cats, studyType)
 		}
 	}
 	return cats
@@ -864,7 +852,11 @@ func evaluate(query cqr.CommonQueryRepresentation, e stats.EntrezStatisticsSourc
 	if err != nil {
 		panic(err)
 	}
-	results := tree.Documents(filecache).Results(pq, "0")
+	docs, err := tree.Documents(filecache)
+	if err != nil {
+		return evaluation{}, err
+	}
+	results := docs.Results(pq, "0")
 	eval.RelevanceGrade = 0
 	ev := []eval.Evaluator{eval.NumRel, eval.NumRet, eval.NumRelRet, eval.Recall, eval.Precision, eval.F1Measure, eval.F05Measure, eval.F3Measure, eval.NNR}
 	devEval := eval.Evaluate(ev, &results, trecresults.QrelsFile{Qrels: map[string]trecresults.Qrels{"0": MakeQrels(dev)}}, "0")
@@ -991,7 +983,7 @@ func (o ObjectiveFormulator) derive(devDF TermStatistics, dev, val []guru.Medlin
 	bestEval = 0.0
 	for _, k := range o.MeSHK {
 		fmt.Println(k)
-		conditionsKeywordsWithMeSH, treatmentsKeywordsWithMeSH, studyTypesKeywordsWithMeSH, err := addMeSHTerms(bestConditions, bestTreatments, bestStudyTypes, dev, o.s, o.query.Topic, k, o.Folder)
+		conditionsKeywordsWithMeSH, treatmentsKeywordsWithMeSH, studyTypesKeywordsWithMeSH, err := addMeSHTerms(bestConditions, bestTreatments, bestStudyTypes, dev, o.s, o.mesh, o.query.Topic, k, o.Folder)
 		if err != nil {
 			return nil, nil, err
 		}
@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"fmt"
 	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/analysis/diff"
 	"github.com/hscells/groove/combinator"
 	"github.com/hscells/groove/eval"
 	"github.com/hscells/groove/pipeline"
@@ -100,7 +101,11 @@ func (qc *QueryChain) Generate() error {
 			if err != nil {
 				return err
 			}
-			r := tree.Documents(qc.QueryCacher).Results(gq, "features")
+			docs, err := tree.Documents(qc.QueryCacher)
+			if err != nil {
+				return err
+			}
+			r := docs.Results(gq, "features")
 
 			evaluation := eval.Evaluate(qc.Evaluators, &r, qc.QrelsFile, gq.Topic)
 
@@ -242,11 +247,13 @@ func (qc *QueryChain) Execute(q pipeline.Query) (CandidateQuery, error) {
 
 		d++
 
+		prev := cq
 		cq, sel, err = sel.Select(cq, candidates)
 		if err != nil && err != combinator.ErrCacheMiss {
 			return CandidateQuery{}, err
 		}
 		log.Println(transmute.CompileCqr2PubMed(cq.Query))
+		log.Printf("changes:\n%s", diff.Compare(prev.Query, cq.Query))
 		log.Printf("topic: %s, depth: %d, stoping: %t", q.Topic, d, sel.StoppingCriteria())
 		log.Println("candidates:", len(candidates))
 		log.Println("chain length:", len(cq.Chain))
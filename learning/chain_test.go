@@ -131,8 +131,8 @@ func TestOracleQueryChainSelector_Select(t *testing.T) {
 			preqpp.RetrievalSize,
 		},
 		learning.NewLogicalOperatorTransformer(),
-		learning.NewMeSHExplosionTransformer(),
-		learning.NewMeshParentTransformer(),
+		learning.NewMeSHExplosionTransformer(nil),
+		learning.NewMeshParentTransformer(nil),
 		learning.NewFieldRestrictionsTransformer(),
 		learning.Newcui2vecExpansionTransformer(p, m, quicheCache),
 		learning.NewClauseRemovalTransformer(),
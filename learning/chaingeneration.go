@@ -190,7 +190,11 @@ func BalancedEvaluationSamplingCriteria(measure eval.Evaluator, scores map[strin
 		if err != nil {
 			panic(err)
 		}
-		results := t.Documents(chain.QueryCacher).Results(pq, "")
+		docs, err := t.Documents(chain.QueryCacher)
+		if err != nil {
+			panic(err)
+		}
+		results := docs.Results(pq, "")
 		v := measure.Score(&results, chain.QrelsFile.Qrels[query.Topic])
 		return v >= scores[query.Topic][measure.Name()]
 	}
@@ -205,7 +209,11 @@ func PositiveBiasedEvaluationSamplingCriteria(measure eval.Evaluator, scores map
 		if err != nil {
 			panic(err)
 		}
-		results := t.Documents(chain.QueryCacher).Results(pq, "")
+		docs, err := t.Documents(chain.QueryCacher)
+		if err != nil {
+			panic(err)
+		}
+		results := docs.Results(pq, "")
 		v := measure.Score(&results, chain.QrelsFile.Qrels[query.Topic])
 		return v >= scores[query.Topic][measure.Name()]
 	}
@@ -220,7 +228,11 @@ func NegativeBiasedEvaluationSamplingCriteria(measure eval.Evaluator, scores map
 		if err != nil {
 			panic(err)
 		}
-		results := t.Documents(chain.QueryCacher).Results(pq, "")
+		docs, err := t.Documents(chain.QueryCacher)
+		if err != nil {
+			panic(err)
+		}
+		results := docs.Results(pq, "")
 		v := measure.Score(&results, chain.QrelsFile.Qrels[query.Topic])
 		return v < scores[query.Topic][measure.Name()]
 	}
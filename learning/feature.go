@@ -6,6 +6,7 @@ import (
 	"github.com/go-errors/errors"
 	"github.com/hscells/cqr"
 	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/analysis/diff"
 	"github.com/hscells/groove/analysis/preqpp"
 	"github.com/hscells/groove/pipeline"
 	"github.com/hscells/groove/stats"
@@ -67,7 +68,8 @@ const (
 	chainFeatures
 )
 
-// MeasurementFeatureKeys contains a mapping of applicable measurement to a feature.
+// MeasurementFeatureKeys contains a mapping of applicable measurement to a feature. The values of vector measurements
+// (see analysis.MeasurementNames) are mapped to features individually.
 var MeasurementFeatureKeys = map[string]int{
 	analysis.BooleanFields.Name():           measurementFeatures,
 	analysis.BooleanClauses.Name():          measurementFeatures + 1,
@@ -89,6 +91,21 @@ var MeasurementFeatureKeys = map[string]int{
 	analysis.BooleanFieldsMeSH.Name():       measurementFeatures + 17,
 	analysis.BooleanFieldsOther.Name():      measurementFeatures + 18,
 	analysis.TermCount.Name():               measurementFeatures + 19,
+	diff.DistanceFromOriginalName:           measurementFeatures + 20,
+}
+
+// MeasurementFeatureKey is the feature of a measurement in MeasurementFeatureKeys. Measurements that identify their
+// configuration in their name after an @ (e.g. diff.NewDistanceFromOriginal) are mapped to the feature of the name
+// before it.
+func MeasurementFeatureKey(name string) (int, bool) {
+	if v, ok := MeasurementFeatureKeys[name]; ok {
+		return v, true
+	}
+	if i := strings.LastIndex(name, "@"); i > 0 {
+		v, ok := MeasurementFeatureKeys[name[:i]]
+		return v, ok
+	}
+	return 0, false
 }
 
 // Chain of transformations !!THIS MUST BE THE LAST FEATURE IN THE LIST!!
@@ -343,11 +360,12 @@ func deltas(query cqr.CommonQueryRepresentation, ss stats.StatisticsSource, meas
 	if err != nil {
 		return nil, err
 	}
-	for i, measurement := range measurements {
-		if v, ok := MeasurementFeatureKeys[measurement.Name()]; ok {
+	// Vector measurements have a feature for each of their values.
+	for i, name := range analysis.MeasurementNames(measurements...) {
+		if v, ok := MeasurementFeatureKey(name); ok {
 			deltas[v] = m[i]
 		} else {
-			return nil, errors.New(fmt.Sprintf("%s is not registered as a feature in MeasurementFeatureKeys", measurement.Name()))
+			return nil, errors.New(fmt.Sprintf("%s is not registered as a feature in MeasurementFeatureKeys", name))
 		}
 	}
 
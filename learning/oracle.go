@@ -98,7 +98,11 @@ func (oc OracleQueryChainCandidateSelector) Select(query CandidateQuery, candida
 		if err != nil {
 			return query, oc, err
 		}
-		results := tree.Documents(oc.seen).Results(pq, query.Topic)
+		docs, err := tree.Documents(oc.seen)
+		if err != nil {
+			return query, oc, err
+		}
+		results := docs.Results(pq, query.Topic)
 		oc.minResults = float64(len(results))
 		evaluation := eval.Evaluate([]eval.Evaluator{eval.Recall, eval.Precision, eval.NumRet, eval.NumRel, eval.NumRelRet}, &results, oc.qrels, query.Topic)
 		if err != nil {
@@ -138,7 +142,11 @@ func (oc OracleQueryChainCandidateSelector) Select(query CandidateQuery, candida
 		}
 
 		// Now we can transform the results of the logical tree into results to be evaluated.
-		results := tree.Documents(oc.seen).Results(nq, nq.Name)
+		docs, err := tree.Documents(oc.seen)
+		if err != nil {
+			return query, oc, err
+		}
+		results := docs.Results(nq, nq.Name)
 
 		// Evaluate the results using qrels.
 		evaluation := eval.Evaluate([]eval.Evaluator{eval.Recall, eval.Precision, eval.NumRet, eval.NumRel, eval.NumRelRet}, &results, oc.qrels, query.Topic)
@@ -33,7 +33,11 @@ func (r RankOracleCandidateSelector) Select(query CandidateQuery, transformation
 		if err != nil {
 			return CandidateQuery{}, nil, err
 		}
-		results := tree.Documents(r.cache).Results(pq, pq.Topic)
+		docs, err := tree.Documents(r.cache)
+		if err != nil {
+			return CandidateQuery{}, nil, err
+		}
+		results := docs.Results(pq, pq.Topic)
 		qrels := r.qrels.Qrels[query.Topic]
 		score := r.measure.Score(&results, qrels)
 		ranked[i] = oracleQuery{score, candidate}
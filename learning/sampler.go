@@ -416,8 +416,16 @@ func (s EvaluationSampler) Sample(candidates []CandidateQuery) ([]CandidateQuery
 					errOnce.Do(func() {
 						errConc = err
 					})
+					return
 				}
-				results = t.Documents(s.chain.QueryCacher).Results(pq, "")
+				docs, err := t.Documents(s.chain.QueryCacher)
+				if err != nil {
+					errOnce.Do(func() {
+						errConc = err
+					})
+					return
+				}
+				results = docs.Results(pq, "")
 			}
 			v := s.measure.Score(&results, s.chain.QrelsFile.Qrels[query.Topic])
 			samples <- ScoredCandidateQuery{
@@ -545,8 +553,16 @@ func (s GreedySampler) Sample(candidates []CandidateQuery) ([]CandidateQuery, er
 					errOnce.Do(func() {
 						errConc = err
 					})
+					return
 				}
-				results = t.Documents(s.chain.QueryCacher).Results(pq, "")
+				docs, err := t.Documents(s.chain.QueryCacher)
+				if err != nil {
+					errOnce.Do(func() {
+						errConc = err
+					})
+					return
+				}
+				results = docs.Results(pq, "")
 			}
 			v := s.measure.Score(&results, s.chain.QrelsFile.Qrels[query.Topic])
 			samples <- GreedyCandidateQuery{
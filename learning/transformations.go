@@ -59,6 +59,7 @@ type adjacencyRange struct {
 }
 type meshExplosion struct {
 	meshDepth float64
+	mesh      *analysis.MeSH
 }
 type fieldRestrictions struct {
 	restrictionType float64
@@ -73,7 +74,9 @@ type cui2vecExpansion struct {
 	cache   quickumlsrest.Cache
 }
 
-type meshParent struct{}
+type meshParent struct {
+	mesh *analysis.MeSH
+}
 
 // NewLogicalOperatorTransformer creates a logical operator transformation.
 func NewLogicalOperatorTransformer() Transformation {
@@ -86,9 +89,10 @@ func NewAdjacencyRangeTransformer() Transformation {
 	return Transformation{ID: AdjacencyRangeTransformation, Transformer: a, BooleanTransformer: a}
 }
 
-// NewMeSHExplosionTransformer creates a mesh explosion transformer.
-func NewMeSHExplosionTransformer() Transformation {
-	return Transformation{ID: MeshExplosionTransformation, Transformer: meshExplosion{}}
+// NewMeSHExplosionTransformer creates a mesh explosion transformer. The depth of headings is looked up in the MeSH
+// tree, or the default MeSH tree if it is nil.
+func NewMeSHExplosionTransformer(mesh *analysis.MeSH) Transformation {
+	return Transformation{ID: MeshExplosionTransformation, Transformer: meshExplosion{mesh: mesh}}
 }
 
 // NewFieldRestrictionsTransformer creates a field restrictions transformer.
@@ -118,8 +122,10 @@ func Newcui2vecExpansionTransformer(vector cui2vec.Embeddings, mapping cui2vec.M
 	}
 }
 
-func NewMeshParentTransformer() Transformation {
-	return Transformation{ID: MeshParentTransformation, Transformer: meshParent{}}
+// NewMeshParentTransformer creates a transformer that replaces MeSH headings with their parents in the MeSH tree, or
+// the default MeSH tree if it is nil.
+func NewMeshParentTransformer(mesh *analysis.MeSH) Transformation {
+	return Transformation{ID: MeshParentTransformation, Transformer: meshParent{mesh: mesh}}
 }
 
 // variations creates the variations of an input candidate query in the transformation chain using the specified
@@ -453,7 +459,11 @@ func (r meshExplosion) Apply(query cqr.CommonQueryRepresentation) (queries []cqr
 					} else {
 						nq.SetOption("exploded", true)
 					}
-					r.meshDepth = float64(analysis.MeSHTree.Depth(q.QueryString))
+					mesh, err := analysis.MeSHOrDefault(r.mesh)
+					if err != nil {
+						return nil, err
+					}
+					r.meshDepth = float64(mesh.Depth(q.QueryString))
 					return []cqr.CommonQueryRepresentation{nq}, nil
 				}
 				return candidates, nil
@@ -672,11 +682,15 @@ func (cui2vecExpansion) Name() string {
 	return "cui2vecExpansion"
 }
 
-func (meshParent) Apply(query cqr.CommonQueryRepresentation) (queries []cqr.CommonQueryRepresentation, err error) {
+func (m meshParent) Apply(query cqr.CommonQueryRepresentation) (queries []cqr.CommonQueryRepresentation, err error) {
 	switch q := query.(type) {
 	case cqr.Keyword:
 		if analysis.ContainsMeshField(q) {
-			parents := set.Strings(analysis.MeSHTree.Parents(q.QueryString))
+			mesh, err := analysis.MeSHOrDefault(m.mesh)
+			if err != nil {
+				return nil, err
+			}
+			parents := set.Strings(mesh.Parents(q.QueryString))
 			for _, parent := range parents {
 				queries = append(queries, cqr.NewKeyword(parent, fields.MeshHeadings).SetOption(cqr.ExplodedString, false))
 			}
@@ -689,10 +703,13 @@ func (meshParent) BooleanApplicable() bool {
 	return false
 }
 
-func (meshParent) Features(query cqr.CommonQueryRepresentation, context TransformationContext) (features Features) {
+func (m meshParent) Features(query cqr.CommonQueryRepresentation, context TransformationContext) (features Features) {
 	switch q := query.(type) {
 	case cqr.Keyword:
-		features = append(features, NewFeature(MeshDepthFeature, float64(analysis.MeSHTree.Depth(q.QueryString))))
+		// A failure to load the MeSH tree is reported by Apply.
+		if mesh, err := analysis.MeSHOrDefault(m.mesh); err == nil {
+			features = append(features, NewFeature(MeshDepthFeature, float64(mesh.Depth(q.QueryString))))
+		}
 	}
 	features = append(features, NewFeature(MeshParentFeature, 1))
 	return
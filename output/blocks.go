@@ -0,0 +1,52 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/groove/analysis/blocks"
+	"sort"
+	"strings"
+)
+
+// BlockFormatter is used in a groove pipeline to output the analysis of the concept blocks of a query.
+type BlockFormatter func(analysis blocks.Analysis) (string, error)
+
+// JsonBlockFormatter outputs the analysis of concept blocks in a JSON format.
+func JsonBlockFormatter(analysis blocks.Analysis) (string, error) {
+	v, err := json.MarshalIndent(analysis, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// TextBlockFormatter outputs the analysis of concept blocks as a report, one line per block followed by its
+// measurements.
+func TextBlockFormatter(analysis blocks.Analysis) (string, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "topic %s: retrieved %d, relevant %d/%d, recall %.4f\n",
+		analysis.Topic, analysis.Retrieved, analysis.RelRet, analysis.Relevant, analysis.Recall)
+	for _, block := range analysis.Blocks {
+		label := string(block.Label)
+		if block.Excluded {
+			label = "not " + label
+		}
+		path := make([]string, len(block.Path))
+		for i, v := range block.Path {
+			path[i] = fmt.Sprintf("%d", v)
+		}
+		fmt.Fprintf(&b, "  /%s %s ret=%d rel=%d recall=%.4f Δretrieved=%+d Δrecall=%+.4f\n",
+			strings.Join(path, "/"), label, block.Retrieved, block.RelRet, block.Recall, block.DeltaRetrieved, block.DeltaRecall)
+		fmt.Fprintf(&b, "    %s\n", strings.Replace(block.Clause, "\n", " ", -1))
+		names := make([]string, 0, len(block.Measurements))
+		for name := range block.Measurements {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(&b, "    %s=%f\n", name, block.Measurements[name])
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,45 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/groove/analysis/contribution"
+	"strings"
+)
+
+// CoverageFormatter is used to output the coverage of the relevant documents of topics by the atoms of their queries.
+type CoverageFormatter func(analyses []contribution.CoverageAnalysis) (string, error)
+
+// JsonCoverageFormatter outputs the coverage analyses in a JSON format.
+func JsonCoverageFormatter(analyses []contribution.CoverageAnalysis) (string, error) {
+	v, err := json.MarshalIndent(analyses, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// TextCoverageFormatter outputs the coverage analyses as a report, one line per atom, followed by the relevant
+// documents that no atom retrieves. Excluded atoms are prefixed with `not`.
+func TextCoverageFormatter(analyses []contribution.CoverageAnalysis) (string, error) {
+	var b bytes.Buffer
+	for i, a := range analyses {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "topic %s: relevant %d, reached %d, unreached %d\n", a.Topic, a.Relevant, a.Reached, len(a.Unreached))
+		for _, k := range a.Keywords {
+			query := strings.Replace(k.Query, "\n", " ", -1)
+			if k.Excluded {
+				query = "not " + query
+			}
+			fmt.Fprintf(&b, "  %v %s ret=%d rel=%d unique=%d recall=%.4f precision=%.4f\n",
+				k.Paths, query, k.Retrieved, k.Relevant, len(k.Unique), k.Recall, k.Precision)
+		}
+		if len(a.Unreached) > 0 {
+			fmt.Fprintf(&b, "  unreached: %s\n", strings.Join(a.Unreached, " "))
+		}
+	}
+	return b.String(), nil
+}
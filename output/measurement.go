@@ -10,6 +10,8 @@ import (
 
 // MeasurementFormatter is used in the a groove pipeline to output measurements in various formats. These methods should not be
 // used directly since there are some assumptions made about the inputs; for instance, the length of each argument.
+// Each value of a vector measurement has its own header (see analysis.MeasurementNames), so data[i] holds the values of
+// headers[i] for every topic.
 type MeasurementFormatter func(topics, headers []string, data [][]float64) (string, error)
 
 // JsonMeasurementFormatter outputs results in a JSON format.
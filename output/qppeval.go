@@ -0,0 +1,45 @@
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"github.com/hscells/groove/analysis/qppeval"
+	"strconv"
+)
+
+// QPPEvaluationFormatter is used in a groove pipeline to output how well query performance predictors correlate with
+// evaluation measures.
+type QPPEvaluationFormatter func(results []qppeval.Result) (string, error)
+
+// JsonQPPEvaluationFormatter outputs the evaluation of predictors in a JSON format.
+func JsonQPPEvaluationFormatter(results []qppeval.Result) (string, error) {
+	v, err := json.MarshalIndent(results, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// CsvQPPEvaluationFormatter outputs the evaluation of predictors in CSV format, one row per predictor and measure.
+func CsvQPPEvaluationFormatter(results []qppeval.Result) (string, error) {
+	b := bytes.NewBufferString("")
+	w := csv.NewWriter(b)
+	w.Write([]string{"Predictor", "Measure", "Topics",
+		"Pearson", "PearsonLower", "PearsonUpper",
+		"Spearman", "SpearmanLower", "SpearmanUpper",
+		"Kendall", "KendallLower", "KendallUpper",
+		"sMARE", "AUC"})
+	f := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	for _, r := range results {
+		w.Write([]string{r.Predictor, r.Measure, strconv.Itoa(r.Topics),
+			f(r.Pearson.Value), f(r.Pearson.Lower), f(r.Pearson.Upper),
+			f(r.Spearman.Value), f(r.Spearman.Lower), f(r.Spearman.Upper),
+			f(r.Kendall.Value), f(r.Kendall.Lower), f(r.Kendall.Upper),
+			f(r.SMARE), f(r.AUC)})
+	}
+	w.Flush()
+	return b.String(), w.Error()
+}
@@ -0,0 +1,247 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"os/exec"
+	"strings"
+)
+
+// TreeNode is a node of a logical tree annotated with the documents it retrieves. Cached is whether the documents of an
+// atom were already cached before the tree was constructed, if known. LostRelevant is the number of relevant documents
+// retrieved by the children of a clause that the clause itself does not retrieve (i.e. they are lost to an `and` or
+// `not` operator).
+type TreeNode struct {
+	Operator     string     `json:"operator,omitempty"`
+	Query        string     `json:"query"`
+	Fields       []string   `json:"fields,omitempty"`
+	Retrieved    int        `json:"retrieved"`
+	Relevant     int        `json:"relevant"`
+	LostRelevant int        `json:"lost_relevant"`
+	Cached       *bool      `json:"cached,omitempty"`
+	Children     []TreeNode `json:"children,omitempty"`
+}
+
+// TreeFormatter is used in a groove pipeline to output annotated logical trees.
+type TreeFormatter func(TreeNode) (string, error)
+
+// atoms lists the atoms of a query, i.e. its keywords and adjacency clauses.
+func atoms(query cqr.CommonQueryRepresentation) []cqr.CommonQueryRepresentation {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		return []cqr.CommonQueryRepresentation{q}
+	case cqr.BooleanQuery:
+		if stats.IsProximityOperator(q.Operator) {
+			return []cqr.CommonQueryRepresentation{q}
+		}
+		var a []cqr.CommonQueryRepresentation
+		for _, child := range q.Children {
+			a = append(a, atoms(child)...)
+		}
+		return a
+	}
+	return nil
+}
+
+// CacheHits records which atoms of a query are already cached. It should be called before the logical tree of the
+// query is constructed (which caches every atom), so the tree can be annotated with the atoms that were cache hits.
+func CacheHits(query cqr.CommonQueryRepresentation, cache combinator.QueryCacher) map[string]bool {
+	hits := make(map[string]bool)
+	for _, atom := range atoms(query) {
+		_, err := cache.Get(atom)
+		hits[atom.String()] = err == nil
+	}
+	return hits
+}
+
+// fields lists the fields of the keywords of an atom.
+func fields(query cqr.CommonQueryRepresentation) []string {
+	seen := make(map[string]bool)
+	var f []string
+	for _, kw := range keywords(query) {
+		for _, field := range kw.Fields {
+			if !seen[field] {
+				seen[field] = true
+				f = append(f, field)
+			}
+		}
+	}
+	return f
+}
+
+func keywords(query cqr.CommonQueryRepresentation) []cqr.Keyword {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		return []cqr.Keyword{q}
+	case cqr.BooleanQuery:
+		var k []cqr.Keyword
+		for _, child := range q.Children {
+			k = append(k, keywords(child)...)
+		}
+		return k
+	}
+	return nil
+}
+
+func annotate(e *combinator.EvaluatedNode, rel combinator.Documents, hits map[string]bool) TreeNode {
+	t := TreeNode{
+		Retrieved: e.Docs.Len(),
+		Relevant:  e.Docs.Intersect(rel).Len(),
+	}
+	if e.Query() != nil {
+		t.Query = e.Query().String()
+	}
+
+	c, ok := e.LogicalTreeNode.(combinator.Combinator)
+	if !ok {
+		if q, ok := e.Query().(cqr.BooleanQuery); ok {
+			t.Operator = strings.ToLower(q.Operator)
+		}
+		t.Fields = fields(e.Query())
+		if hit, ok := hits[t.Query]; ok {
+			t.Cached = &hit
+		}
+		return t
+	}
+
+	t.Operator = c.Operator.String()
+	var relevant combinator.Documents
+	for i, child := range e.Children {
+		t.Children = append(t.Children, annotate(child, rel, hits))
+		// Only the relevant documents of the first clause of a `not` query can be lost; the others are excluded.
+		if i == 0 || t.Operator != "not" {
+			relevant = relevant.Union(child.Docs.Intersect(rel))
+		}
+	}
+	t.LostRelevant = relevant.Difference(e.Docs).Len()
+	return t
+}
+
+// AnnotateTree annotates every node of a logical tree with the number of documents and relevant documents it
+// retrieves. The documents of the atoms of the tree are read from the cache. Atoms are annotated with whether they
+// were cache hits if hits (see CacheHits) is not nil.
+func AnnotateTree(tree combinator.LogicalTree, cache combinator.QueryCacher, qrels trecresults.Qrels, hits map[string]bool) (TreeNode, error) {
+	root, err := combinator.Evaluate(tree.Root, cache)
+	if err != nil {
+		return TreeNode{}, err
+	}
+	return annotate(root, combinator.RelevantDocuments(qrels), hits), nil
+}
+
+// DOTOptions configure how trees are coloured when rendered with Graphviz.
+type DOTOptions struct {
+	CacheHits    bool
+	LostRelevant bool
+}
+
+// DOTCacheHits colours atoms that were cache hits green, and cache misses orange.
+func DOTCacheHits(o *DOTOptions) {
+	o.CacheHits = true
+}
+
+// DOTLostRelevant outlines clauses that lose relevant documents in red.
+func DOTLostRelevant(o *DOTOptions) {
+	o.LostRelevant = true
+}
+
+// dotEscape escapes a string so it can be used in a quoted DOT label.
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func writeDOT(b *bytes.Buffer, t TreeNode, id *int, o DOTOptions) int {
+	n := *id
+	*id++
+
+	label := t.Operator
+	if len(t.Children) == 0 {
+		label = t.Query
+		if len(t.Operator) > 0 {
+			label = fmt.Sprintf("%s\n%s", t.Operator, t.Query)
+		}
+		if len(t.Fields) > 0 {
+			label = fmt.Sprintf("%s\n[%s]", label, strings.Join(t.Fields, ", "))
+		}
+	}
+	label = fmt.Sprintf("%s\nret=%d rel=%d", label, t.Retrieved, t.Relevant)
+
+	attrs := []string{fmt.Sprintf(`label="%s"`, dotEscape(label))}
+	if o.CacheHits && t.Cached != nil {
+		colour := "orange"
+		if *t.Cached {
+			colour = "palegreen"
+		}
+		attrs = append(attrs, fmt.Sprintf(`fillcolor="%s"`, colour))
+	}
+	if o.LostRelevant && t.LostRelevant > 0 {
+		attrs = append(attrs, `color="red"`, `penwidth=2`)
+	}
+	fmt.Fprintf(b, "  n%d [%s];\n", n, strings.Join(attrs, ", "))
+
+	for _, child := range t.Children {
+		c := writeDOT(b, child, id, o)
+		fmt.Fprintf(b, "  n%d -> n%d;\n", n, c)
+	}
+	return n
+}
+
+// TreeDOT renders an annotated logical tree in the Graphviz DOT language.
+func TreeDOT(t TreeNode, options ...func(o *DOTOptions)) string {
+	var o DOTOptions
+	for _, option := range options {
+		option(&o)
+	}
+	var b bytes.Buffer
+	b.WriteString("digraph tree {\n")
+	b.WriteString("  node [shape=box, style=filled, fillcolor=white];\n")
+	id := 0
+	writeDOT(&b, t, &id, o)
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// TreeSVG renders an annotated logical tree as an SVG image. The Graphviz `dot` command must be installed.
+func TreeSVG(t TreeNode, options ...func(o *DOTOptions)) (string, error) {
+	cmd := exec.Command("dot", "-Tsvg")
+	cmd.Stdin = strings.NewReader(TreeDOT(t, options...))
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("dot: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+// TreeJSON renders an annotated logical tree in a JSON format.
+func TreeJSON(t TreeNode) (string, error) {
+	v, err := json.MarshalIndent(t, "", "    ")
+	if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// DOTTreeFormatter outputs trees in the Graphviz DOT language.
+func DOTTreeFormatter(options ...func(o *DOTOptions)) TreeFormatter {
+	return func(t TreeNode) (string, error) {
+		return TreeDOT(t, options...), nil
+	}
+}
+
+// SVGTreeFormatter outputs trees as SVG images.
+func SVGTreeFormatter(options ...func(o *DOTOptions)) TreeFormatter {
+	return func(t TreeNode) (string, error) {
+		return TreeSVG(t, options...)
+	}
+}
+
+// JSONTreeFormatter outputs trees in a JSON format.
+func JSONTreeFormatter(t TreeNode) (string, error) {
+	return TreeJSON(t)
+}
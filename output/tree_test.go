@@ -0,0 +1,127 @@
+package output_test
+
+import (
+	"encoding/json"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/output"
+	"github.com/hscells/trecresults"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAnnotateTree(t *testing.T) {
+	cache := combinator.NewMapQueryCache()
+	atom := func(term string, docs ...combinator.Document) combinator.Atom {
+		kw := cqr.NewKeyword(term, "title")
+		if err := cache.Set(kw, combinator.NewDocuments(docs...)); err != nil {
+			t.Fatal(err)
+		}
+		return combinator.NewAtom(kw)
+	}
+
+	// (a AND b) OR (c NOT d)
+	a, b, c, d := atom("a", 1, 2, 3), atom("b", 2, 3, 4), atom("c", 5, 6), atom("d", 6, 7)
+	and := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.AND, []cqr.CommonQueryRepresentation{a.Query(), b.Query()}), combinator.AndOperator, a, b)
+	not := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{c.Query(), d.Query()}), combinator.NotOperator, c, d)
+	or := combinator.NewCombinator(cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{and.Query(), not.Query()}), combinator.OrOperator, and, not)
+
+	qrels := trecresults.Qrels{}
+	for _, id := range []string{"1", "2", "6", "7"} {
+		qrels[id] = &trecresults.Qrel{Score: 2}
+	}
+	hits := map[string]bool{a.Query().String(): true, c.Query().String(): false}
+
+	root, err := output.AnnotateTree(combinator.LogicalTree{Root: or}, cache, qrels, hits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root.Retrieved != 3 || root.Relevant != 1 || root.LostRelevant != 0 {
+		t.Errorf("unexpected annotation of the root %+v", root)
+	}
+
+	// The and loses document 1, which a retrieves.
+	if n := root.Children[0]; n.Operator != "and" || n.Retrieved != 2 || n.Relevant != 1 || n.LostRelevant != 1 {
+		t.Errorf("unexpected annotation of the and clause %+v", n)
+	}
+	// The not loses document 6, which c retrieves. Document 7 is only retrieved by the excluded clause, so it is not
+	// lost.
+	if n := root.Children[1]; n.Operator != "not" || n.Retrieved != 1 || n.Relevant != 0 || n.LostRelevant != 1 {
+		t.Errorf("unexpected annotation of the not clause %+v", n)
+	}
+
+	// Only atoms that were recorded as hits or misses are annotated with whether they were cached.
+	atoms := []output.TreeNode{root.Children[0].Children[0], root.Children[0].Children[1], root.Children[1].Children[0], root.Children[1].Children[1]}
+	for i, expected := range []*bool{boolean(true), nil, boolean(false), nil} {
+		if !reflect.DeepEqual(atoms[i].Cached, expected) {
+			t.Errorf("unexpected cache annotation of %s", atoms[i].Query)
+		}
+		if len(atoms[i].Fields) != 1 || atoms[i].Fields[0] != "title" {
+			t.Errorf("expected %s to be annotated with the title field, got %v", atoms[i].Query, atoms[i].Fields)
+		}
+	}
+	for _, n := range []output.TreeNode{root, root.Children[0], root.Children[1]} {
+		if n.Cached != nil {
+			t.Errorf("expected the %s clause not to be annotated with whether it was cached", n.Operator)
+		}
+	}
+
+	// Trees are unchanged when they are rendered in JSON and read back.
+	s, err := output.TreeJSON(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded output.TreeNode
+	if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, root) {
+		t.Errorf("expected the tree to round-trip through JSON, got %+v", decoded)
+	}
+}
+
+func TestCacheHits(t *testing.T) {
+	a, b := cqr.NewKeyword("a", "title"), cqr.NewKeyword("b", "title")
+	cache := combinator.NewMapQueryCache()
+	if err := cache.Set(a, combinator.NewDocuments(1)); err != nil {
+		t.Fatal(err)
+	}
+	hits := output.CacheHits(cqr.NewBooleanQuery(cqr.OR, []cqr.CommonQueryRepresentation{a, b}), cache)
+	if len(hits) != 2 || !hits[a.String()] || hits[b.String()] {
+		t.Errorf("expected only a to be a cache hit, got %v", hits)
+	}
+}
+
+func TestTreeDOT(t *testing.T) {
+	root := output.TreeNode{
+		Operator:  "or",
+		Retrieved: 2,
+		Relevant:  1,
+		Children: []output.TreeNode{
+			{Query: "\"heart\nattack\"", Retrieved: 1, Cached: boolean(true)},
+			{Query: `a\b`, Retrieved: 1, Relevant: 1, LostRelevant: 1},
+		},
+	}
+	dot := output.TreeDOT(root, output.DOTCacheHits, output.DOTLostRelevant)
+
+	// Quotes, backslashes and newlines in labels are escaped, so every statement is on a single line.
+	for _, expected := range []string{
+		`n0 [label="or\nret=2 rel=1"];`,
+		`n1 [label="\"heart\nattack\"\nret=1 rel=0", fillcolor="palegreen"];`,
+		`n2 [label="a\\b\nret=1 rel=1", color="red", penwidth=2];`,
+		`n0 -> n1;`,
+		`n0 -> n2;`,
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("expected the DOT output to contain %s, got:\n%s", expected, dot)
+		}
+	}
+	if n := strings.Count(dot, "\n"); n != 8 {
+		t.Errorf("expected 8 lines of DOT output, got %d:\n%s", n, dot)
+	}
+}
+
+func boolean(b bool) *bool {
+	return &b
+}
@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/analysis/blocks"
+	"github.com/hscells/groove/analysis/qppeval"
 	"github.com/hscells/groove/combinator"
 	"github.com/hscells/groove/eval"
 	"github.com/hscells/groove/formulation"
@@ -14,6 +16,7 @@ import (
 	"github.com/hscells/groove/pipeline"
 	"github.com/hscells/groove/preprocess"
 	"github.com/hscells/groove/query"
+	"github.com/hscells/groove/remote"
 	"github.com/hscells/groove/stats"
 	"github.com/hscells/transmute"
 	"github.com/hscells/trecresults"
@@ -25,6 +28,7 @@ import (
 	"runtime"
 	"sort"
 	"strconv"
+	"sync"
 )
 
 // Pipeline contains all the information for executing a pipeline for query analysis.
@@ -39,13 +43,26 @@ type Pipeline struct {
 	MeasurementExecutor   analysis.MeasurementExecutor
 	Evaluations           []eval.Evaluator
 	EvaluationFormatters  EvaluationOutputFormat
+	TreeFormatters        []output.TreeFormatter
+	QPPEvaluation         QPPEvaluationOutputFormat
+	Blocks                BlockOutputFormat
 	OutputTrec            output.TrecResults
 	QueryCache            combinator.QueryCacher
 	Model                 learning.Model
 	ModelConfiguration    ModelConfiguration
 	QueryFormulator       formulation.Formulator
+	// CacheServer is the address of a remote cache server shared by the query and measurement caches.
+	CacheServer string
+	// Scheduler replaces combinator.DefaultScheduler while the pipeline is executed.
+	Scheduler *combinator.Scheduler
+	// Planner optimises and evaluates the logical tree of every topic, so that subtrees shared by topics are only
+	// evaluated once. A planner is created for each execution if it is not set.
+	Planner *combinator.Planner
 }
 
+// plannerSize is the number of subtrees memoised by the planner created when a pipeline is executed.
+const plannerSize = 1024
+
 // ModelConfiguration specifies what actions of a model should be taken by the pipeline.
 type ModelConfiguration struct {
 	Generate bool
@@ -114,6 +131,78 @@ func EvaluationOutput(qrels string, formatters ...output.EvaluationFormatter) fu
 	}
 }
 
+// QPPEvaluationOutputFormat specifies how the evaluation of query performance predictors should be formatted.
+type QPPEvaluationOutputFormat struct {
+	QPPEvaluationFormatters []output.QPPEvaluationFormatter
+	Options                 []func(o *qppeval.Options)
+}
+
+// QPPEvaluationOutput correlates the measurements of each query with its evaluation, when both measurements and
+// evaluations are configured.
+func QPPEvaluationOutput(formatters []output.QPPEvaluationFormatter, options ...func(o *qppeval.Options)) func() interface{} {
+	return func() interface{} {
+		return QPPEvaluationOutputFormat{
+			QPPEvaluationFormatters: formatters,
+			Options:                 options,
+		}
+	}
+}
+
+// TreeOutput renders the annotated logical tree of each query. Trees are annotated with relevant documents when an
+// evaluation output is also configured.
+func TreeOutput(formatters ...output.TreeFormatter) func() interface{} {
+	return func() interface{} {
+		return formatters
+	}
+}
+
+// BlockOutputFormat specifies how the concept blocks of queries are identified, and how their analysis should be
+// formatted.
+type BlockOutputFormat struct {
+	Segmenter       blocks.Segmenter
+	BlockFormatters []output.BlockFormatter
+}
+
+// BlockOutput analyses each concept block of each query, using the measurements of the pipeline. The recall of blocks
+// is computed when an evaluation output is also configured.
+func BlockOutput(segmenter blocks.Segmenter, formatters ...output.BlockFormatter) func() interface{} {
+	return func() interface{} {
+		return BlockOutputFormat{
+			Segmenter:       segmenter,
+			BlockFormatters: formatters,
+		}
+	}
+}
+
+// cacheServer is the address of a remote cache server.
+type cacheServer string
+
+// CacheServer configures the pipeline to share its query and measurement caches with a remote cache server (see
+// `groove cache-server`). The local caches are still used, and read through to the server. Results are only written to
+// the server when the token of the server is set in the GROOVE_CACHE_TOKEN environment variable.
+func CacheServer(addr string) func() interface{} {
+	return func() interface{} {
+		return cacheServer(addr)
+	}
+}
+
+// Scheduler configures the pipeline to bound the work performed when evaluating logical trees using a scheduler, rather
+// than the default one (e.g. to limit the concurrent requests made to a backend with
+// combinator.SchedulerBackendFetches).
+func Scheduler(scheduler *combinator.Scheduler) func() interface{} {
+	return func() interface{} {
+		return scheduler
+	}
+}
+
+// Planner configures the pipeline to evaluate logical trees using a planner, rather than one created for each
+// execution (e.g. to share memoised subtrees between pipelines that use the same collection).
+func Planner(planner *combinator.Planner) func() interface{} {
+	return func() interface{} {
+		return planner
+	}
+}
+
 // NewGroovePipeline creates a new groove pipeline. The query source and statistics source are required. Additional
 // components are provided via the optional functional arguments.
 func NewGroovePipeline(qs query.QueriesSource, ss stats.StatisticsSource, components ...func() interface{}) Pipeline {
@@ -133,6 +222,18 @@ func NewGroovePipeline(qs query.QueriesSource, ss stats.StatisticsSource, compon
 			gp.MeasurementFormatters = v
 		case preprocess.QueryTransformations:
 			gp.Transformations = v
+		case []output.TreeFormatter:
+			gp.TreeFormatters = v
+		case QPPEvaluationOutputFormat:
+			gp.QPPEvaluation = v
+		case BlockOutputFormat:
+			gp.Blocks = v
+		case cacheServer:
+			gp.CacheServer = string(v)
+		case *combinator.Scheduler:
+			gp.Scheduler = v
+		case *combinator.Planner:
+			gp.Planner = v
 		}
 	}
 
@@ -155,6 +256,15 @@ func (p Pipeline) Execute(c chan pipeline.Result) {
 		return
 	}
 
+	// The scheduler is only replaced while the pipeline is executed, so it does not affect later pipelines.
+	if p.Scheduler != nil {
+		previous := combinator.DefaultScheduler
+		combinator.DefaultScheduler = p.Scheduler
+		defer func() {
+			combinator.DefaultScheduler = previous
+		}()
+	}
+
 	// Configure caches.
 	statisticsCache := diskv.New(diskv.Options{
 		BasePath:     path.Join(cacheDir, "groove", "statistics_cache"),
@@ -164,10 +274,20 @@ func (p Pipeline) Execute(c chan pipeline.Result) {
 	})
 
 	if p.QueryCache == nil {
-		p.QueryCache = combinator.NewFileQueryCache(path.Join(cacheDir, "groove", "file_cache"))
+		p.QueryCache = combinator.NewFileQueryCache(path.Join(cacheDir, "groove", "file_cache"),
+			combinator.CacheNamespace(stats.Namespace(p.StatisticsSource)))
 	}
 
-	p.MeasurementExecutor = analysis.NewDiskMeasurementExecutor(statisticsCache)
+	if len(p.CacheServer) > 0 {
+		client := remote.NewClient(p.CacheServer,
+			remote.ClientNamespace(stats.Namespace(p.StatisticsSource)),
+			remote.ClientQueryCache(p.QueryCache),
+			remote.ClientMeasurementCache(statisticsCache))
+		p.QueryCache = client
+		p.MeasurementExecutor = analysis.NewMeasurementExecutor(client)
+	} else {
+		p.MeasurementExecutor = analysis.NewDiskMeasurementExecutor(statisticsCache)
+	}
 
 	// Only perform this section if there are some queries.
 	if len(p.QueryPath) > 0 {
@@ -230,20 +350,23 @@ func (p Pipeline) Execute(c chan pipeline.Result) {
 			measurementQueries[i] = q
 		}
 
-		// Compute measurements for each of the queries.
-		// The measurements are computed in parallel.
-		N := len(p.Measurements)
-		headers := make([]string, N)
-		data := make([][]float64, N)
+		// Compute measurements for each of the queries. Vector measurements have a header for each of their values.
+		headers := analysis.MeasurementNames(p.Measurements...)
+		data := make([][]float64, len(headers))
+
+		// Predictors are evaluated when there are both measurements and evaluations.
+		evaluateQPP := len(p.QPPEvaluation.QPPEvaluationFormatters) > 0 && len(p.Measurements) > 0 && len(p.Evaluations) > 0
 
 		// Only perform the measurements if there are some measurement formatters to output them to.
-		if len(p.MeasurementFormatters) > 0 {
+		if len(p.MeasurementFormatters) > 0 || evaluateQPP {
 			// data[measurement][queryN]
-			for i, m := range p.Measurements {
-				headers[i] = m.Name()
+			for i := range headers {
 				data[i] = make([]float64, len(queries))
-				for qi, measurementQuery := range measurementQueries {
-					data[i][qi], err = m.Execute(measurementQuery, p.StatisticsSource)
+			}
+			for qi, measurementQuery := range measurementQueries {
+				i := 0
+				for _, m := range p.Measurements {
+					values, err := analysis.ExecuteMeasurement(m, measurementQuery, p.StatisticsSource)
 					if err != nil {
 						c <- pipeline.Result{
 							Error: err,
@@ -251,6 +374,10 @@ func (p Pipeline) Execute(c chan pipeline.Result) {
 						}
 						return
 					}
+					for _, v := range values {
+						data[i][qi] = v
+						i++
+					}
 				}
 			}
 
@@ -272,23 +399,33 @@ func (p Pipeline) Execute(c chan pipeline.Result) {
 					return
 				}
 			}
-			c <- pipeline.Result{
-				Measurements: outputs,
-				Type:         pipeline.Measurement,
+			if len(outputs) > 0 {
+				c <- pipeline.Result{
+					Measurements: outputs,
+					Type:         pipeline.Measurement,
+				}
 			}
 		}
 
 		// This section is run concurrently, since the results can sometimes get quite large and we don't want to eat ram.
-		if len(p.OutputTrec.Path) > 0 || len(p.EvaluationFormatters.EvaluationFormatters) > 0 {
+		if len(p.OutputTrec.Path) > 0 || len(p.EvaluationFormatters.EvaluationFormatters) > 0 || len(p.TreeFormatters) > 0 || len(p.Blocks.BlockFormatters) > 0 || evaluateQPP {
 			// Store the measurements to be output later.
 			measurements := make(map[string]map[string]float64)
+			var mu sync.Mutex
 
-			// Set the limit to how many goroutines can be run.
+			// Set the limit to how many goroutines can be run. The requests made to the statistics source and the set
+			// operations performed when evaluating each topic are further bounded by the shared combinator.DefaultScheduler.
 			// http://jmoiron.net/blog/limiting-concurrency-in-go/
 			concurrency := runtime.NumCPU()
 
 			log.Printf("starting to execute queries with %d goroutines\n", concurrency)
 
+			// Topics share a planner, so that subtrees common to their queries are only evaluated once.
+			planner := p.Planner
+			if planner == nil {
+				planner = combinator.NewPlanner(p.StatisticsSource, plannerSize)
+			}
+
 			sem := make(chan bool, concurrency)
 			for i, q := range measurementQueries {
 				sem <- true
@@ -296,6 +433,12 @@ func (p Pipeline) Execute(c chan pipeline.Result) {
 					defer func() { <-sem }()
 					log.Printf("starting topic %v\n", query.Topic)
 
+					// Atoms are cached as the tree is constructed, so cache hits must be recorded beforehand.
+					var hits map[string]bool
+					if len(p.TreeFormatters) > 0 {
+						hits = output.CacheHits(query.Query, p.QueryCache)
+					}
+
 					tree, cache, err := combinator.NewLogicalTree(query, p.StatisticsSource, p.QueryCache)
 					if err != nil {
 						c <- pipeline.Result{
@@ -305,7 +448,16 @@ func (p Pipeline) Execute(c chan pipeline.Result) {
 						}
 						return
 					}
-					docIds := tree.Documents(cache)
+					optimised, err := planner.Optimise(tree, cache)
+					if err != nil {
+						c <- pipeline.Result{
+							Topic: query.Topic,
+							Error: err,
+							Type:  pipeline.Error,
+						}
+						return
+					}
+					docIds, err := planner.Documents(optimised, cache)
 					if err != nil {
 						c <- pipeline.Result{
 							Topic: query.Topic,
@@ -322,7 +474,10 @@ func (p Pipeline) Execute(c chan pipeline.Result) {
 
 					// Set the evaluation results.
 					if len(p.Evaluations) > 0 {
-						measurements[query.Topic] = eval.Evaluate(p.Evaluations, &trecResults, p.EvaluationFormatters.EvaluationQrels, query.Topic)
+						e := eval.Evaluate(p.Evaluations, &trecResults, p.EvaluationFormatters.EvaluationQrels, query.Topic)
+						mu.Lock()
+						measurements[query.Topic] = e
+						mu.Unlock()
 					}
 
 					// MeasurementOutput the trec results.
@@ -334,6 +489,83 @@ func (p Pipeline) Execute(c chan pipeline.Result) {
 						}
 					}
 
+					// Render the annotated tree.
+					if len(p.TreeFormatters) > 0 {
+						var qrels trecresults.Qrels
+						if p.EvaluationFormatters.EvaluationQrels.Qrels != nil {
+							qrels = p.EvaluationFormatters.EvaluationQrels.Qrels[query.Topic]
+						}
+						annotated, err := output.AnnotateTree(tree, cache, qrels, hits)
+						if err != nil {
+							c <- pipeline.Result{
+								Topic: query.Topic,
+								Error: err,
+								Type:  pipeline.Error,
+							}
+							return
+						}
+						trees := make([]string, len(p.TreeFormatters))
+						for i, f := range p.TreeFormatters {
+							trees[i], err = f(annotated)
+							if err != nil {
+								c <- pipeline.Result{
+									Topic: query.Topic,
+									Error: err,
+									Type:  pipeline.Error,
+								}
+								return
+							}
+						}
+						c <- pipeline.Result{
+							Topic: query.Topic,
+							Trees: trees,
+							Type:  pipeline.Tree,
+						}
+					}
+
+					// Analyse each concept block of the query.
+					if len(p.Blocks.BlockFormatters) > 0 {
+						var qrels trecresults.Qrels
+						if p.EvaluationFormatters.EvaluationQrels.Qrels != nil {
+							qrels = p.EvaluationFormatters.EvaluationQrels.Qrels[query.Topic]
+						}
+						segments, err := p.Blocks.Segmenter.Segment(query.Query)
+						if err != nil {
+							c <- pipeline.Result{
+								Topic: query.Topic,
+								Error: err,
+								Type:  pipeline.Error,
+							}
+							return
+						}
+						a, err := blocks.Analyse(query, segments, p.StatisticsSource, cache, qrels, p.Measurements...)
+						if err != nil {
+							c <- pipeline.Result{
+								Topic: query.Topic,
+								Error: err,
+								Type:  pipeline.Error,
+							}
+							return
+						}
+						analyses := make([]string, len(p.Blocks.BlockFormatters))
+						for i, f := range p.Blocks.BlockFormatters {
+							analyses[i], err = f(a)
+							if err != nil {
+								c <- pipeline.Result{
+									Topic: query.Topic,
+									Error: err,
+									Type:  pipeline.Error,
+								}
+								return
+							}
+						}
+						c <- pipeline.Result{
+							Topic:  query.Topic,
+							Blocks: analyses,
+							Type:   pipeline.Block,
+						}
+					}
+
 					// Send the transformation through the channel.
 					c <- pipeline.Result{
 						Transformation: pipeline.QueryResult{Name: query.Name, Topic: query.Topic, Transformation: query.Query},
@@ -369,6 +601,40 @@ func (p Pipeline) Execute(c chan pipeline.Result) {
 				Evaluations: evaluations,
 				Type:        pipeline.Evaluation,
 			}
+
+			// Correlate the measurements of each topic with its evaluation.
+			if evaluateQPP {
+				predictions := make(map[string]map[string]float64)
+				for qi, q := range measurementQueries {
+					predictions[q.Topic] = make(map[string]float64)
+					for i, header := range headers {
+						predictions[q.Topic][header] = data[i][qi]
+					}
+				}
+				results, err := qppeval.Evaluate(predictions, measurements, p.QPPEvaluation.Options...)
+				if err != nil {
+					c <- pipeline.Result{
+						Error: err,
+						Type:  pipeline.Error,
+					}
+					return
+				}
+				outputs := make([]string, len(p.QPPEvaluation.QPPEvaluationFormatters))
+				for i, f := range p.QPPEvaluation.QPPEvaluationFormatters {
+					outputs[i], err = f(results)
+					if err != nil {
+						c <- pipeline.Result{
+							Error: err,
+							Type:  pipeline.Error,
+						}
+						return
+					}
+				}
+				c <- pipeline.Result{
+					QPPEvaluations: outputs,
+					Type:           pipeline.QPPEvaluation,
+				}
+			}
 		}
 	}
 
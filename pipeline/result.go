@@ -28,6 +28,12 @@ const (
 	Error
 	// Done indicates the pipeline has completed.
 	Done
+	// Tree is a rendering of the annotated logical tree of a query.
+	Tree
+	// QPPEvaluation is the correlation of query performance predictors with evaluation measures.
+	QPPEvaluation
+	// Block is the analysis of the concept blocks of a query.
+	Block
 )
 
 // Result is the output of a groove pipeline.
@@ -37,6 +43,9 @@ type Result struct {
 	Evaluations    []string
 	Transformation QueryResult
 	TrecResults    *trecresults.ResultList
+	Trees          []string
+	QPPEvaluations []string
+	Blocks         []string
 	Type           ResultType
 	Error          error
 }
@@ -0,0 +1,190 @@
+package preprocess
+
+import (
+	"github.com/hscells/cqr"
+)
+
+// satisfiability is a propositional satisfiability problem, encoded in conjunctive normal form. Variables are numbered
+// from one, and a negative literal is the negation of its variable.
+type satisfiability struct {
+	atoms   map[string]int
+	n       int
+	clauses [][]int
+}
+
+func newSatisfiability() *satisfiability {
+	return &satisfiability{atoms: make(map[string]int)}
+}
+
+func (s *satisfiability) variable() int {
+	s.n++
+	return s.n
+}
+
+// encode adds the clauses of a formula using the Tseitin transformation, which introduces a variable for each operator
+// so that the number of clauses grows linearly with the size of the formula. The literal that is true if and only if
+// the formula is true is returned.
+func (s *satisfiability) encode(f formula) int {
+	switch f.op {
+	case "":
+		v, ok := s.atoms[f.key]
+		if !ok {
+			v = s.variable()
+			s.atoms[f.key] = v
+		}
+		return v
+	case cqr.NOT:
+		return -s.encode(f.children[0])
+	}
+
+	literals := make([]int, len(f.children))
+	for i, child := range f.children {
+		literals[i] = s.encode(child)
+	}
+	v := s.variable()
+	all := make([]int, 0, len(literals)+1)
+	if f.op == cqr.AND {
+		// v implies every child, and every child together implies v.
+		all = append(all, v)
+		for _, l := range literals {
+			s.clauses = append(s.clauses, []int{-v, l})
+			all = append(all, -l)
+		}
+	} else {
+		// v implies some child, and every child implies v.
+		all = append(all, -v)
+		for _, l := range literals {
+			s.clauses = append(s.clauses, []int{v, -l})
+			all = append(all, l)
+		}
+	}
+	s.clauses = append(s.clauses, all)
+	return v
+}
+
+// assert adds a clause requiring a literal to be true.
+func (s *satisfiability) assert(l int) {
+	s.clauses = append(s.clauses, []int{l})
+}
+
+// solve decides if the clauses are satisfiable using the DPLL algorithm.
+func (s *satisfiability) solve() bool {
+	assignment := make([]int8, s.n+1)
+	return s.dpll(assignment)
+}
+
+// value is the value of a literal in an assignment; 1 for true, -1 for false and 0 if it is unassigned.
+func value(assignment []int8, l int) int8 {
+	if l < 0 {
+		return -assignment[-l]
+	}
+	return assignment[l]
+}
+
+func (s *satisfiability) dpll(assignment []int8) bool {
+	var trail []int
+	undo := func() {
+		for _, v := range trail {
+			assignment[v] = 0
+		}
+	}
+
+	// Unit propagation.
+	for propagated := true; propagated; {
+		propagated = false
+		for _, clause := range s.clauses {
+			var (
+				unassigned int
+				last       int
+				satisfied  bool
+			)
+			for _, l := range clause {
+				switch value(assignment, l) {
+				case 1:
+					satisfied = true
+				case 0:
+					unassigned++
+					last = l
+				}
+				if satisfied {
+					break
+				}
+			}
+			if satisfied {
+				continue
+			}
+			switch unassigned {
+			case 0:
+				undo()
+				return false
+			case 1:
+				v, val := last, int8(1)
+				if last < 0 {
+					v, val = -last, -1
+				}
+				assignment[v] = val
+				trail = append(trail, v)
+				propagated = true
+			}
+		}
+	}
+
+	// Branch on a variable of the first clause that is not yet satisfied.
+	branch := 0
+	for _, clause := range s.clauses {
+		satisfied, candidate := false, 0
+		for _, l := range clause {
+			switch value(assignment, l) {
+			case 1:
+				satisfied = true
+			case 0:
+				candidate = l
+			}
+		}
+		if !satisfied && candidate != 0 {
+			branch = candidate
+			break
+		}
+	}
+	if branch == 0 {
+		return true
+	}
+
+	v := branch
+	if v < 0 {
+		v = -v
+	}
+	// Try making the literal true, and then false.
+	for _, val := range []int8{1, -1} {
+		if branch < 0 {
+			val = -val
+		}
+		assignment[v] = val
+		if s.dpll(assignment) {
+			return true
+		}
+	}
+	assignment[v] = 0
+	undo()
+	return false
+}
+
+// implies decides if every assignment of atoms that satisfies a also satisfies b.
+func implies(a, b cqr.CommonQueryRepresentation) bool {
+	s := newSatisfiability()
+	s.assert(s.encode(toFormula(a)))
+	s.assert(-s.encode(toFormula(b)))
+	return !s.solve()
+}
+
+// Equivalent decides if two queries are logically equivalent when keywords (and adjacency queries) are treated as
+// propositional atoms, i.e. they retrieve the same documents from any collection. No statistics source is used.
+func Equivalent(a, b cqr.CommonQueryRepresentation) bool {
+	return implies(a, b) && implies(b, a)
+}
+
+// Subsumes decides if query a subsumes query b when keywords (and adjacency queries) are treated as propositional atoms,
+// i.e. a retrieves every document that b retrieves from any collection. No statistics source is used.
+func Subsumes(a, b cqr.CommonQueryRepresentation) bool {
+	return implies(b, a)
+}
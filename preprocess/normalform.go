@@ -0,0 +1,392 @@
+package preprocess
+
+import (
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/stats"
+	"sort"
+	"strings"
+)
+
+// Normal forms treat keywords, and adjacency queries (which cannot be decomposed into their keywords) as propositional
+// atoms. Queries are interpreted as they are when they are executed: unknown operators are treated as `or`, `a not b c`
+// is interpreted as `a and not (b or c)`, and a `not` query with a single child retrieves the documents of its child.
+//
+// Negation in a normal form can only be applied to an atom. Where negated atoms are conjoined with other clauses, they
+// are excluded from the conjunction of those clauses with a `not` query, which is executable. Anywhere else (e.g. in a
+// disjunction), negation requires every document in the collection, which cannot be expressed by a query. There, a
+// negated atom is a `not` query with a single child, and the normal form is propositional only: it must not be
+// executed, as the negation would be ignored. The empty `and` query is true, and the empty `or` query is false.
+
+// formula is a propositional formula over the atoms of a query.
+type formula struct {
+	// op is one of cqr.AND, cqr.OR or cqr.NOT (a unary negation, which only queries with more than one clause can
+	// introduce), or empty for an atom.
+	op       string
+	atom     cqr.CommonQueryRepresentation
+	key      string
+	children []formula
+}
+
+// literal is a possibly negated atom.
+type literal struct {
+	atom    cqr.CommonQueryRepresentation
+	key     string
+	negated bool
+}
+
+// toFormula creates the formula for a query.
+func toFormula(query cqr.CommonQueryRepresentation) formula {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		k := canonicalKeyword(q)
+		return formula{atom: k, key: queryKey(k)}
+	case cqr.BooleanQuery:
+		if stats.IsProximityOperator(q.Operator) {
+			c := Canonical(q)
+			return formula{atom: c, key: queryKey(c)}
+		}
+		children := make([]formula, len(q.Children))
+		for i, child := range q.Children {
+			children[i] = toFormula(child)
+		}
+		switch strings.ToLower(q.Operator) {
+		case cqr.AND:
+			return formula{op: cqr.AND, children: children}
+		case cqr.NOT:
+			if len(children) == 1 {
+				return children[0]
+			}
+			if len(children) == 0 {
+				return formula{op: cqr.OR}
+			}
+			return formula{op: cqr.AND, children: []formula{
+				children[0],
+				{op: cqr.NOT, children: []formula{{op: cqr.OR, children: children[1:]}}},
+			}}
+		default:
+			return formula{op: cqr.OR, children: children}
+		}
+	}
+	return formula{op: cqr.OR}
+}
+
+// nnf pushes the negations of a formula to its atoms, flattening nested conjunctions and disjunctions.
+func (f formula) nnf(negated bool) formula {
+	switch f.op {
+	case "":
+		if negated {
+			return formula{op: cqr.NOT, children: []formula{f}}
+		}
+		return f
+	case cqr.NOT:
+		return f.children[0].nnf(!negated)
+	}
+
+	// De Morgan's laws.
+	op := f.op
+	if negated {
+		if op == cqr.AND {
+			op = cqr.OR
+		} else {
+			op = cqr.AND
+		}
+	}
+	g := formula{op: op}
+	for _, child := range f.children {
+		c := child.nnf(negated)
+		if c.op == op {
+			g.children = append(g.children, c.children...)
+		} else {
+			g.children = append(g.children, c)
+		}
+	}
+	return g
+}
+
+// toLiteral creates the literal of a formula in negation normal form that is an atom or a negated atom.
+func (f formula) toLiteral() literal {
+	if f.op == cqr.NOT {
+		return literal{atom: f.children[0].atom, key: f.children[0].key, negated: true}
+	}
+	return literal{atom: f.atom, key: f.key}
+}
+
+// clauses expands a formula in negation normal form into a conjunction of disjunctions (when op is cqr.AND), or a
+// disjunction of conjunctions (when op is cqr.OR). Clauses are sorted and free of duplicate literals, and clauses
+// containing an atom and its negation (which are always true in a disjunction, and false in a conjunction) are removed.
+func (f formula) clauses(op string) [][]literal {
+	switch f.op {
+	case "", cqr.NOT:
+		return [][]literal{{f.toLiteral()}}
+	case op:
+		var clauses [][]literal
+		for _, child := range f.children {
+			clauses = append(clauses, child.clauses(op)...)
+		}
+		return dedupeClauses(clauses)
+	}
+
+	// Distribute the other operator over the clauses of the children.
+	clauses := [][]literal{{}}
+	for _, child := range f.children {
+		var expanded [][]literal
+		childClauses := child.clauses(op)
+		for _, clause := range clauses {
+			for _, c := range childClauses {
+				merged := make([]literal, 0, len(clause)+len(c))
+				merged = append(merged, clause...)
+				merged = append(merged, c...)
+				if merged, ok := normaliseClause(merged); ok {
+					expanded = append(expanded, merged)
+				}
+			}
+		}
+		clauses = expanded
+	}
+	return dedupeClauses(clauses)
+}
+
+// normaliseClause sorts and removes duplicate literals from a clause. The clause is discarded if it contains an atom
+// and its negation.
+func normaliseClause(clause []literal) ([]literal, bool) {
+	sort.SliceStable(clause, func(i, j int) bool {
+		if clause[i].key == clause[j].key {
+			return !clause[i].negated && clause[j].negated
+		}
+		return clause[i].key < clause[j].key
+	})
+	var normalised []literal
+	for i, l := range clause {
+		if i > 0 && clause[i-1].key == l.key {
+			if clause[i-1].negated != l.negated {
+				return nil, false
+			}
+			continue
+		}
+		normalised = append(normalised, l)
+	}
+	return normalised, true
+}
+
+func clauseKey(clause []literal) string {
+	keys := make([]string, len(clause))
+	for i, l := range clause {
+		keys[i] = l.key
+		if l.negated {
+			keys[i] = "!" + keys[i]
+		}
+	}
+	return strings.Join(keys, ",")
+}
+
+func dedupeClauses(clauses [][]literal) [][]literal {
+	seen := make(map[string]bool)
+	var deduped [][]literal
+	for _, clause := range clauses {
+		k := clauseKey(clause)
+		if !seen[k] {
+			seen[k] = true
+			deduped = append(deduped, clause)
+		}
+	}
+	sort.SliceStable(deduped, func(i, j int) bool {
+		return clauseKey(deduped[i]) < clauseKey(deduped[j])
+	})
+	return deduped
+}
+
+// query creates the query of a literal. A negated atom that is not excluded from a conjunction cannot be executed.
+func (l literal) query() cqr.CommonQueryRepresentation {
+	if l.negated {
+		return cqr.NewBooleanQuery(cqr.NOT, []cqr.CommonQueryRepresentation{l.atom})
+	}
+	return l.atom
+}
+
+// conjunction creates the query of a conjunction of clauses and negated atoms. When there are clauses, the negated atoms
+// are excluded from their conjunction with a `not` query.
+func conjunction(clauses []cqr.CommonQueryRepresentation, negated []literal) cqr.BooleanQuery {
+	if len(negated) == 0 {
+		return cqr.NewBooleanQuery(cqr.AND, clauses)
+	}
+	if len(clauses) == 0 {
+		children := make([]cqr.CommonQueryRepresentation, len(negated))
+		for i, l := range negated {
+			children[i] = l.query()
+		}
+		return cqr.NewBooleanQuery(cqr.AND, children)
+	}
+	children := []cqr.CommonQueryRepresentation{cqr.NewBooleanQuery(cqr.AND, clauses)}
+	for _, l := range negated {
+		children = append(children, l.atom)
+	}
+	return cqr.NewBooleanQuery(cqr.NOT, children)
+}
+
+func (f formula) query() cqr.CommonQueryRepresentation {
+	switch f.op {
+	case "", cqr.NOT:
+		return f.toLiteral().query()
+	case cqr.AND:
+		var (
+			clauses []cqr.CommonQueryRepresentation
+			negated []literal
+		)
+		for _, child := range f.children {
+			if child.op == cqr.NOT {
+				negated = append(negated, child.toLiteral())
+				continue
+			}
+			clauses = append(clauses, child.query())
+		}
+		return conjunction(clauses, negated)
+	}
+	children := make([]cqr.CommonQueryRepresentation, len(f.children))
+	for i, child := range f.children {
+		children[i] = child.query()
+	}
+	return cqr.NewBooleanQuery(f.op, children)
+}
+
+// normalForm creates the query of a conjunction of disjunctions (when op is cqr.AND), or a disjunction of conjunctions
+// (when op is cqr.OR).
+func normalForm(query cqr.CommonQueryRepresentation, op string) cqr.BooleanQuery {
+	inner := cqr.AND
+	if op == cqr.AND {
+		inner = cqr.OR
+	}
+	var (
+		children []cqr.CommonQueryRepresentation
+		negated  []literal
+	)
+	for _, clause := range toFormula(query).nnf(false).clauses(op) {
+		// Clauses of conjunctive normal form that are a single negated atom are excluded from the other clauses.
+		if op == cqr.AND && len(clause) == 1 && clause[0].negated {
+			negated = append(negated, clause[0])
+			continue
+		}
+		var (
+			literals []cqr.CommonQueryRepresentation
+			excluded []literal
+		)
+		for _, l := range clause {
+			if inner == cqr.AND && l.negated {
+				excluded = append(excluded, l)
+				continue
+			}
+			literals = append(literals, l.query())
+		}
+		if inner == cqr.AND {
+			children = append(children, conjunction(literals, excluded))
+		} else {
+			children = append(children, cqr.NewBooleanQuery(inner, literals))
+		}
+	}
+	if op == cqr.AND {
+		return conjunction(children, negated)
+	}
+	return cqr.NewBooleanQuery(op, children)
+}
+
+// NNF converts a query to negation normal form, where only atoms are negated and the only other operators are `and`
+// and `or` (negated atoms in a conjunction are excluded from it with a `not` query).
+func NNF(query cqr.CommonQueryRepresentation) cqr.CommonQueryRepresentation {
+	return toFormula(query).nnf(false).query()
+}
+
+// CNF converts a query to conjunctive normal form, an `and` query of `or` queries of (possibly negated) atoms. Clauses
+// that are a single negated atom are excluded from the `and` query with a `not` query. The size of the query may grow
+// exponentially with the number of its clauses.
+func CNF(query cqr.CommonQueryRepresentation) cqr.BooleanQuery {
+	return normalForm(query, cqr.AND)
+}
+
+// DNF converts a query to disjunctive normal form, an `or` query of `and` queries of (possibly negated) atoms. The
+// negated atoms of a clause are excluded from its `and` query with a `not` query. The size of the query may grow
+// exponentially with the number of its clauses.
+func DNF(query cqr.CommonQueryRepresentation) cqr.BooleanQuery {
+	return normalForm(query, cqr.OR)
+}
+
+// canonicalKeyword copies a keyword with its fields sorted.
+func canonicalKeyword(k cqr.Keyword) cqr.Keyword {
+	fields := make([]string, len(k.Fields))
+	copy(fields, k.Fields)
+	sort.Strings(fields)
+	k.Fields = fields
+	return k
+}
+
+// queryKey is a deterministic string of a query, used to order and compare queries.
+func queryKey(query cqr.CommonQueryRepresentation) string {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		options := make([]string, 0, len(q.Options))
+		for k, v := range q.Options {
+			options = append(options, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(options)
+		return fmt.Sprintf("%s[%s]{%s}", q.QueryString, strings.Join(q.Fields, ","), strings.Join(options, ","))
+	case cqr.BooleanQuery:
+		keys := make([]string, len(q.Children))
+		for i, child := range q.Children {
+			keys[i] = queryKey(child)
+		}
+		return fmt.Sprintf("%s(%s)", strings.ToLower(q.Operator), strings.Join(keys, ","))
+	}
+	return ""
+}
+
+// sortChildren sorts and removes duplicates from the children of a query.
+func sortChildren(children []cqr.CommonQueryRepresentation) []cqr.CommonQueryRepresentation {
+	sort.SliceStable(children, func(i, j int) bool {
+		return queryKey(children[i]) < queryKey(children[j])
+	})
+	var sorted []cqr.CommonQueryRepresentation
+	for i, child := range children {
+		if i > 0 && queryKey(children[i-1]) == queryKey(child) {
+			continue
+		}
+		sorted = append(sorted, child)
+	}
+	return sorted
+}
+
+// Canonical creates the canonical form of a query, so that queries that differ only in the order of the children of
+// commutative operators have the same form. Operators are lowercased, nested `and` and `or` queries are flattened,
+// duplicate children of commutative operators are removed, `and` and `or` queries with a single child are replaced by
+// it, and the fields of keywords are sorted. The first child of a `not` query and the children of adjacency queries
+// keep their order. The query is not modified.
+func Canonical(query cqr.CommonQueryRepresentation) cqr.CommonQueryRepresentation {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		return canonicalKeyword(q)
+	case cqr.BooleanQuery:
+		q.Operator = strings.ToLower(q.Operator)
+		children := make([]cqr.CommonQueryRepresentation, 0, len(q.Children))
+		for _, child := range q.Children {
+			c := Canonical(child)
+			if b, ok := c.(cqr.BooleanQuery); ok && (q.Operator == cqr.AND || q.Operator == cqr.OR) && b.Operator == q.Operator {
+				children = append(children, b.Children...)
+				continue
+			}
+			children = append(children, c)
+		}
+
+		switch q.Operator {
+		case cqr.AND, cqr.OR:
+			children = sortChildren(children)
+			if len(children) == 1 {
+				return children[0]
+			}
+		case cqr.NOT:
+			if len(children) > 2 {
+				children = append(children[:1], sortChildren(children[1:])...)
+			}
+		}
+		q.Children = children
+		return q
+	}
+	return query
+}
@@ -0,0 +1,74 @@
+package preprocess
+
+import (
+	"github.com/hscells/cqr"
+	"testing"
+)
+
+func TestEquivalent(t *testing.T) {
+	kw := func(term string) cqr.CommonQueryRepresentation {
+		return cqr.NewKeyword(term, "title")
+	}
+	bq := func(operator string, children ...cqr.CommonQueryRepresentation) cqr.CommonQueryRepresentation {
+		return cqr.NewBooleanQuery(operator, children)
+	}
+	a, b, c, d := kw("a"), kw("b"), kw("c"), kw("d")
+
+	cases := []struct {
+		x, y       cqr.CommonQueryRepresentation
+		equivalent bool
+	}{
+		{bq("and", a, b), bq("AND", b, a), true},
+		{bq("and", a, bq("or", b, c)), bq("or", bq("and", a, b), bq("and", a, c)), true},
+		{bq("not", a, b, c), bq("not", bq("and", a), bq("or", b, c)), true},
+		// A not query with a single clause retrieves the documents of the clause when it is executed.
+		{bq("not", a), a, true},
+		{bq("not", a, b, c), bq("and", a, bq("not", bq("or", b, c))), false},
+		{bq("and", a, bq("not", bq("or", b, c))), bq("and", a, bq("or", b, c)), true},
+		{bq("not", a, b), bq("not", b, a), false},
+		{bq("or", a, bq("and", a, b)), a, true},
+		{bq("and", a, bq("or", b, c, d)), bq("or", bq("and", a, d), bq("and", a, c), bq("and", b, a)), true},
+		{bq("not", a, b), bq("and", a, b), false},
+		{bq("or", a, b), a, false},
+	}
+	for i, q := range cases {
+		if Equivalent(q.x, q.y) != q.equivalent {
+			t.Errorf("case %d: expected equivalent to be %v", i, q.equivalent)
+		}
+		for name, f := range map[string]func(cqr.CommonQueryRepresentation) cqr.CommonQueryRepresentation{
+			"nnf":       NNF,
+			"cnf":       func(q cqr.CommonQueryRepresentation) cqr.CommonQueryRepresentation { return CNF(q) },
+			"dnf":       func(q cqr.CommonQueryRepresentation) cqr.CommonQueryRepresentation { return DNF(q) },
+			"canonical": Canonical,
+		} {
+			if !Equivalent(f(q.x), q.x) {
+				t.Errorf("case %d: expected the %s of the query to be equivalent", i, name)
+			}
+		}
+	}
+
+	if !Subsumes(bq("or", a, b), a) || Subsumes(a, bq("or", a, b)) {
+		t.Errorf("expected a or b to subsume a, and not the reverse")
+	}
+	if !Subsumes(a, bq("not", a, b)) {
+		t.Errorf("expected a to subsume a not b")
+	}
+
+	// Negated atoms that are conjoined with other clauses are excluded from them, so the normal forms can be executed.
+	for name, q := range map[string]cqr.CommonQueryRepresentation{
+		"nnf": NNF(bq("not", a, b, c)),
+		"cnf": CNF(bq("not", a, b, c)),
+		"dnf": DNF(bq("not", a, b, c)),
+	} {
+		if n, ok := q.(cqr.BooleanQuery); ok && n.Operator == cqr.OR {
+			q = n.Children[0]
+		}
+		if n, ok := q.(cqr.BooleanQuery); !ok || n.Operator != cqr.NOT || len(n.Children) != 3 {
+			t.Errorf("expected the %s of a not b c to be a not query excluding b and c, got %v", name, q)
+		}
+	}
+
+	if queryKey(Canonical(bq("OR", d, bq("or", c, a), a))) != queryKey(bq("or", a, c, d)) {
+		t.Errorf("expected canonical form to be flattened, sorted and deduplicated, got %v", Canonical(bq("OR", d, bq("or", c, a), a)))
+	}
+}
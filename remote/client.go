@@ -0,0 +1,203 @@
+package remote
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/combinator"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Client reads and writes the caches of a Server. Items are read from the local caches of the client first, and items
+// read from the server are written to the local caches. The server is used on a best-effort basis: requests that fail
+// are logged and treated as cache misses, and nothing is written to the server without a token (see ClientToken).
+type Client struct {
+	url          string
+	namespace    string
+	token        string
+	client       *http.Client
+	queries      combinator.QueryCacher
+	measurements analysis.MeasurementCacher
+}
+
+// ClientNamespace sets the namespace of the query cache on the server, typically stats.Namespace of the statistics
+// source the documents are retrieved from.
+func ClientNamespace(namespace string) func(c *Client) {
+	return func(c *Client) {
+		c.namespace = namespace
+	}
+}
+
+// ClientToken sets the token presented to the server when writing to its caches.
+func ClientToken(token string) func(c *Client) {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// ClientHTTP sets the HTTP client used to make requests to the server.
+func ClientHTTP(client *http.Client) func(c *Client) {
+	return func(c *Client) {
+		c.client = client
+	}
+}
+
+// ClientQueryCache sets the local query cache.
+func ClientQueryCache(cache combinator.QueryCacher) func(c *Client) {
+	return func(c *Client) {
+		c.queries = cache
+	}
+}
+
+// ClientMeasurementCache sets the local measurement cache.
+func ClientMeasurementCache(cache analysis.MeasurementCacher) func(c *Client) {
+	return func(c *Client) {
+		c.measurements = cache
+	}
+}
+
+// NewClient creates a client for the server at an address (e.g. http://localhost:8787). By default, items are cached
+// locally in memory, the token is read from the TokenEnv environment variable, and requests time out after ten
+// seconds.
+func NewClient(addr string, options ...func(c *Client)) *Client {
+	c := &Client{
+		url:          strings.TrimRight(addr, "/"),
+		token:        os.Getenv(TokenEnv),
+		client:       &http.Client{Timeout: 10 * time.Second},
+		queries:      combinator.NewMapQueryCache(),
+		measurements: make(analysis.MemoryMeasurementCache),
+	}
+	for _, option := range options {
+		option(c)
+	}
+	return c
+}
+
+// do makes a request to the server. A response of not found is a cache miss.
+func (c *Client) do(method, path string, body io.Reader) ([]byte, error) {
+	req, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return b, nil
+	case http.StatusNotFound:
+		return nil, combinator.ErrCacheMiss
+	default:
+		return nil, fmt.Errorf("cache server responded with %s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+}
+
+// queryPath is the path of a query request in the namespace of the client.
+func (c *Client) queryPath(path string) string {
+	return path + "?" + url.Values{namespaceParam: {c.namespace}}.Encode()
+}
+
+func encodeQuery(q queryRequest) (io.Reader, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(q)
+	return &buf, err
+}
+
+// Get looks up results in the local cache, and then on the server. The server is only consulted on a best-effort
+// basis: if it cannot be reached, the failure is logged and the query is a cache miss.
+func (c *Client) Get(query cqr.CommonQueryRepresentation) (combinator.Documents, error) {
+	docs, err := c.queries.Get(query)
+	if err != combinator.ErrCacheMiss {
+		return docs, err
+	}
+
+	body, err := encodeQuery(queryRequest{Query: query})
+	if err != nil {
+		return combinator.Documents{}, err
+	}
+	b, err := c.do(http.MethodPost, c.queryPath(queryGetPath), body)
+	if err != nil {
+		if err != combinator.ErrCacheMiss {
+			log.Printf("could not read %s from the cache server: %v\n", query, err)
+		}
+		return combinator.Documents{}, combinator.ErrCacheMiss
+	}
+	if err := docs.UnmarshalBinary(b); err != nil {
+		log.Printf("could not decode %s from the cache server: %v\n", query, err)
+		return combinator.Documents{}, combinator.ErrCacheMiss
+	}
+	return docs, c.queries.Set(query, docs)
+}
+
+// Set caches results locally, and on the server if the client has a token. Failing to write to the server is logged,
+// rather than returned, as the results are already cached locally.
+func (c *Client) Set(query cqr.CommonQueryRepresentation, docs combinator.Documents) error {
+	if err := c.queries.Set(query, docs); err != nil {
+		return err
+	}
+	if len(c.token) == 0 {
+		return nil
+	}
+	b, err := docs.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	body, err := encodeQuery(queryRequest{Query: query, Documents: b})
+	if err != nil {
+		return err
+	}
+	if _, err := c.do(http.MethodPost, c.queryPath(querySetPath), body); err != nil {
+		log.Printf("could not write %s to the cache server: %v\n", query, err)
+	}
+	return nil
+}
+
+// Read reads a measurement from the local cache, and then from the server. A server that cannot be reached is a cache
+// miss.
+func (c *Client) Read(key string) ([]byte, error) {
+	if v, err := c.measurements.Read(key); err == nil {
+		return v, nil
+	}
+	v, err := c.do(http.MethodGet, measurementPath+url.PathEscape(key), nil)
+	if err != nil {
+		if err != combinator.ErrCacheMiss {
+			log.Printf("could not read measurement %s from the cache server: %v\n", key, err)
+		}
+		return nil, combinator.ErrCacheMiss
+	}
+	return v, c.measurements.Write(key, v)
+}
+
+// Write writes a measurement to the local cache, and to the server if the client has a token. Failing to write to the
+// server is logged, rather than returned.
+func (c *Client) Write(key string, val []byte) error {
+	if err := c.measurements.Write(key, val); err != nil {
+		return err
+	}
+	if len(c.token) == 0 {
+		return nil
+	}
+	if _, err := c.do(http.MethodPut, measurementPath+url.PathEscape(key), bytes.NewReader(val)); err != nil {
+		log.Printf("could not write measurement %s to the cache server: %v\n", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package remote_test
+
+import (
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/combinator"
+	"github.com/hscells/groove/remote"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRemoteCache(t *testing.T) {
+	queries := make(map[string]combinator.QueryCacher)
+	server := httptest.NewServer(remote.NewServer(func(namespace string) combinator.QueryCacher {
+		queries[namespace] = combinator.NewMapQueryCache()
+		return queries[namespace]
+	}, make(analysis.MemoryMeasurementCache), remote.ServerToken("secret")))
+	defer server.Close()
+
+	kw := cqr.NewKeyword("heart attack", "title")
+	docs := combinator.NewDocuments(1, 2, 3)
+
+	// Only clients with the token of the server can write to it; other clients only cache locally.
+	if err := remote.NewClient(server.URL, remote.ClientNamespace("pubmed"), remote.ClientToken("wrong")).Set(kw, docs); err != nil {
+		t.Errorf("expected a refused write to only be logged, got %v", err)
+	}
+	if err := remote.NewClient(server.URL, remote.ClientToken("")).Write("measurement", []byte{1, 2, 3}); err != nil {
+		t.Errorf("expected a client without a token to only write locally, got %v", err)
+	}
+	if _, err := remote.NewClient(server.URL, remote.ClientNamespace("pubmed")).Get(kw); err != combinator.ErrCacheMiss {
+		t.Errorf("expected a cache miss, got %v", err)
+	}
+	if _, err := remote.NewClient(server.URL).Read("measurement"); err != combinator.ErrCacheMiss {
+		t.Errorf("expected a cache miss, got %v", err)
+	}
+
+	// A server that cannot be reached is a cache miss.
+	unreachable := remote.NewClient("http://127.0.0.1:1", remote.ClientToken("secret"))
+	if _, err := unreachable.Get(kw); err != combinator.ErrCacheMiss {
+		t.Errorf("expected a cache miss, got %v", err)
+	}
+	if err := unreachable.Set(kw, docs); err != nil {
+		t.Errorf("expected a failed write to only be logged, got %v", err)
+	}
+
+	// Results written by one client can be read by another.
+	if err := remote.NewClient(server.URL, remote.ClientNamespace("pubmed"), remote.ClientToken("secret")).Set(kw, docs); err != nil {
+		t.Fatal(err)
+	}
+	local := combinator.NewMapQueryCache()
+	got, err := remote.NewClient(server.URL, remote.ClientNamespace("pubmed"), remote.ClientQueryCache(local)).Get(kw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Equals(docs) {
+		t.Errorf("expected %v, got %v", docs, got)
+	}
+	// The results are read through to the local cache.
+	if _, err := local.Get(kw); err != nil {
+		t.Errorf("expected results to be cached locally, got %v", err)
+	}
+
+	// Results are not shared between namespaces.
+	if _, err := remote.NewClient(server.URL, remote.ClientNamespace("medline")).Get(kw); err != combinator.ErrCacheMiss {
+		t.Errorf("expected a cache miss, got %v", err)
+	}
+
+	client := remote.NewClient(server.URL, remote.ClientToken("secret"))
+	if _, err := client.Read("missing"); err != combinator.ErrCacheMiss {
+		t.Errorf("expected a cache miss, got %v", err)
+	}
+	if err := client.Write("measurement", []byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	v, err := remote.NewClient(server.URL).Read("measurement")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(v) != string([]byte{1, 2, 3}) {
+		t.Errorf("expected [1 2 3], got %v", v)
+	}
+}
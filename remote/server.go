@@ -0,0 +1,231 @@
+// Package remote shares query and measurement caches between machines over HTTP. A Server exposes existing caches, and
+// a Client implements both combinator.QueryCacher and analysis.MeasurementCacher, reading through a local cache.
+//
+// Queries are sent to the server gob encoded and documents are sent in their compressed binary format. Query caches are
+// namespaced by the statistics source the documents were retrieved from (see stats.Namespace).
+//
+// Anyone who can reach a server can read its caches, however writes must present the token the server was created with
+// (see ServerToken and ClientToken). A server without a token is read-only.
+package remote
+
+import (
+	"crypto/subtle"
+	"encoding/gob"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/analysis"
+	"github.com/hscells/groove/combinator"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	queryGetPath    = "/query/get"
+	querySetPath    = "/query/set"
+	measurementPath = "/measurement/"
+	namespaceParam  = "namespace"
+	// TokenEnv is the environment variable the token shared by servers and clients is read from by default.
+	TokenEnv = "GROOVE_CACHE_TOKEN"
+)
+
+// queryRequest is the body of a request to read or write the documents of a query.
+type queryRequest struct {
+	Query     cqr.CommonQueryRepresentation
+	Documents []byte
+}
+
+func init() {
+	gob.Register(cqr.Keyword{})
+	gob.Register(cqr.BooleanQuery{})
+}
+
+// Server serves query and measurement caches over HTTP.
+type Server struct {
+	queries      func(namespace string) combinator.QueryCacher
+	measurements analysis.MeasurementCacher
+	token        string
+
+	caches map[string]combinator.QueryCacher
+	cmu    sync.Mutex
+	// mu guards the caches, which are not necessarily safe for concurrent writes.
+	mu  sync.RWMutex
+	mux *http.ServeMux
+}
+
+// ServerToken sets the token that requests to write to the caches of the server must present.
+func ServerToken(token string) func(s *Server) {
+	return func(s *Server) {
+		s.token = token
+	}
+}
+
+// NewServer creates a server for the query caches created for each namespace, and a measurement cache. Query caches are
+// created the first time a namespace is requested. By default, the token is read from the TokenEnv environment
+// variable.
+func NewServer(queries func(namespace string) combinator.QueryCacher, measurements analysis.MeasurementCacher, options ...func(s *Server)) *Server {
+	s := &Server{
+		queries:      queries,
+		measurements: measurements,
+		token:        os.Getenv(TokenEnv),
+		caches:       make(map[string]combinator.QueryCacher),
+		mux:          http.NewServeMux(),
+	}
+	for _, option := range options {
+		option(s)
+	}
+	s.mux.HandleFunc(queryGetPath, s.getQuery)
+	s.mux.HandleFunc(querySetPath, s.setQuery)
+	s.mux.HandleFunc(measurementPath, s.measurement)
+	return s
+}
+
+// ServeHTTP handles requests to the caches of the server.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// cache gets the query cache of a namespace.
+func (s *Server) cache(namespace string) combinator.QueryCacher {
+	s.cmu.Lock()
+	defer s.cmu.Unlock()
+	if c, ok := s.caches[namespace]; ok {
+		return c
+	}
+	c := s.queries(namespace)
+	s.caches[namespace] = c
+	return c
+}
+
+// authorised returns if a request may write to the caches of the server, responding with an error if not.
+func (s *Server) authorised(w http.ResponseWriter, r *http.Request) bool {
+	if len(s.token) == 0 {
+		http.Error(w, "server is read-only", http.StatusForbidden)
+		return false
+	}
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// readQuery decodes the body of a query request.
+func readQuery(r *http.Request) (queryRequest, error) {
+	var q queryRequest
+	err := gob.NewDecoder(r.Body).Decode(&q)
+	return q, err
+}
+
+// isMiss returns if an error returned by a cache is because the item was not present.
+func isMiss(err error) bool {
+	return err == combinator.ErrCacheMiss || os.IsNotExist(err)
+}
+
+func (s *Server) getQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := readQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Reading from a cache may write to it (e.g. to record when the entry was accessed).
+	c := s.cache(r.URL.Query().Get(namespaceParam))
+	s.mu.Lock()
+	docs, err := c.Get(q.Query)
+	s.mu.Unlock()
+	if isMiss(err) {
+		http.Error(w, "cache miss", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	b, err := docs.MarshalBinary()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(b)
+}
+
+func (s *Server) setQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if !s.authorised(w, r) {
+		return
+	}
+	q, err := readQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var docs combinator.Documents
+	if err := docs.UnmarshalBinary(q.Documents); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c := s.cache(r.URL.Query().Get(namespaceParam))
+	s.mu.Lock()
+	err = c.Set(q.Query, docs)
+	s.mu.Unlock()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) measurement(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, measurementPath)
+	if len(key) == 0 {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		v, err := s.measurements.Read(key)
+		s.mu.RUnlock()
+		if isMiss(err) {
+			http.Error(w, "cache miss", http.StatusNotFound)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(v)
+	case http.MethodPut:
+		if !s.authorised(w, r) {
+			return
+		}
+		v, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.mu.Lock()
+		err = s.measurements.Write(key, v)
+		s.mu.Unlock()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -367,7 +367,11 @@ func Evaluate(query cqr.CommonQueryRepresentation, e stats.EntrezStatisticsSourc
 	if err != nil {
 		panic(err)
 	}
-	results := tree.Documents(filecache).Results(pq, "0")
+	docs, err := tree.Documents(filecache)
+	if err != nil {
+		return Evaluation{}, err
+	}
+	results := docs.Results(pq, "0")
 	eval.RelevanceGrade = 0
 	ev := []eval.Evaluator{eval.NumRel, eval.NumRet, eval.NumRelRet, eval.Recall, eval.Precision, eval.F1Measure, eval.F05Measure, eval.F3Measure, eval.NNR}
 	devEval := eval.Evaluate(ev, &results, trecresults.QrelsFile{Qrels: map[string]trecresults.Qrels{"0": makeQrels(dev)}}, "0")
@@ -789,8 +793,12 @@ func FilterQueryTerms(conditions, treatments, studyTypes []string, field string,
 			if err != nil {
 				return nil, nil, nil, err
 			}
+			docs, err := tree.Documents(fileCache)
+			if err != nil {
+				return nil, nil, nil, err
+			}
 			var relret []string
-			for _, result := range tree.Documents(fileCache).Results(pq, "0") {
+			for _, result := range docs.Results(pq, "0") {
 				if _, ok := development[result.DocId]; ok {
 					relret = append(relret, result.DocId)
 				}
@@ -0,0 +1,159 @@
+package stats
+
+import (
+	"github.com/hscells/cqr"
+)
+
+const (
+	// defaultBatchSize is the number of terms or queries sent to a backend in a single request.
+	defaultBatchSize = 100
+	// defaultBatchConcurrency is the number of concurrent requests a batch is split across.
+	defaultBatchConcurrency = 10
+)
+
+// TermField is a term and the field it is looked up in. It is the unit of work for batched statistics requests.
+type TermField struct {
+	Term  string
+	Field string
+}
+
+// BatchStatisticsSource is a statistics source that can compute statistics for many terms or queries in a single
+// call, cutting down the number of round trips made to the backend. The values returned by each method are in the
+// same order as the terms or queries that were supplied, and are the same as the values of the equivalent single term
+// or query method.
+type BatchStatisticsSource interface {
+	StatisticsSource
+
+	DocumentFrequencies(terms []TermField) ([]float64, error)
+	TotalTermFrequencies(terms []TermField) ([]float64, error)
+	InverseDocumentFrequencies(terms []TermField) ([]float64, error)
+	RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error)
+}
+
+// uniqueTermFields removes duplicate terms so that each one is only requested from the backend once. The returned
+// index maps each of the original terms to its position in the unique slice.
+func uniqueTermFields(terms []TermField) ([]TermField, []int) {
+	seen := make(map[TermField]int, len(terms))
+	unique := make([]TermField, 0, len(terms))
+	idx := make([]int, len(terms))
+	for i, t := range terms {
+		j, ok := seen[t]
+		if !ok {
+			j = len(unique)
+			seen[t] = j
+			unique = append(unique, t)
+		}
+		idx[i] = j
+	}
+	return unique, idx
+}
+
+// expand maps values computed for unique terms back onto the original terms.
+func expand(values []float64, idx []int) []float64 {
+	v := make([]float64, len(idx))
+	for i, j := range idx {
+		v[i] = values[j]
+	}
+	return v
+}
+
+// batch finds a statistics source that implements BatchStatisticsSource, which may be wrapped.
+func batch(ss StatisticsSource) (BatchStatisticsSource, bool) {
+	b, ok := find(ss, func(ss StatisticsSource) bool {
+		_, ok := ss.(BatchStatisticsSource)
+		return ok
+	}).(BatchStatisticsSource)
+	return b, ok
+}
+
+// DocumentFrequencies computes the document frequency of each term. When the statistics source implements
+// BatchStatisticsSource, all of the terms are requested at once, otherwise they are requested one at a time.
+func DocumentFrequencies(ss StatisticsSource, terms []TermField) ([]float64, error) {
+	unique, idx := uniqueTermFields(terms)
+	var (
+		values []float64
+		err    error
+	)
+	if b, ok := batch(ss); ok {
+		values, err = b.DocumentFrequencies(unique)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		values = make([]float64, len(unique))
+		for i, t := range unique {
+			values[i], err = ss.DocumentFrequency(t.Term, t.Field)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return expand(values, idx), nil
+}
+
+// TotalTermFrequencies computes the total term frequency of each term. When the statistics source implements
+// BatchStatisticsSource, all of the terms are requested at once, otherwise they are requested one at a time.
+func TotalTermFrequencies(ss StatisticsSource, terms []TermField) ([]float64, error) {
+	unique, idx := uniqueTermFields(terms)
+	var (
+		values []float64
+		err    error
+	)
+	if b, ok := batch(ss); ok {
+		values, err = b.TotalTermFrequencies(unique)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		values = make([]float64, len(unique))
+		for i, t := range unique {
+			values[i], err = ss.TotalTermFrequency(t.Term, t.Field)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return expand(values, idx), nil
+}
+
+// InverseDocumentFrequencies computes the inverse document frequency of each term. When the statistics source
+// implements BatchStatisticsSource, all of the terms are requested at once, otherwise they are requested one at a time.
+func InverseDocumentFrequencies(ss StatisticsSource, terms []TermField) ([]float64, error) {
+	unique, idx := uniqueTermFields(terms)
+	var (
+		values []float64
+		err    error
+	)
+	if b, ok := batch(ss); ok {
+		values, err = b.InverseDocumentFrequencies(unique)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		values = make([]float64, len(unique))
+		for i, t := range unique {
+			values[i], err = ss.InverseDocumentFrequency(t.Term, t.Field)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return expand(values, idx), nil
+}
+
+// RetrievalSizes computes the number of documents each query retrieves. When the statistics source implements
+// BatchStatisticsSource, all of the queries are issued at once, otherwise they are issued one at a time.
+func RetrievalSizes(ss StatisticsSource, queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	if b, ok := batch(ss); ok {
+		return b.RetrievalSizes(queries)
+	}
+	values := make([]float64, len(queries))
+	for i, q := range queries {
+		var err error
+		values[i], err = ss.RetrievalSize(q)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
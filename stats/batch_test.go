@@ -0,0 +1,250 @@
+package stats_test
+
+import (
+	"encoding/json"
+	"github.com/hscells/cqr"
+	"github.com/hscells/groove/pipeline"
+	"github.com/hscells/groove/stats"
+	"github.com/hscells/trecresults"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// countingSource is a statistics source that counts how many requests are made to it.
+type countingSource struct {
+	df       map[string]float64
+	requests int
+}
+
+func (c *countingSource) SearchOptions() stats.SearchOptions { return stats.SearchOptions{} }
+func (c *countingSource) Parameters() map[string]float64     { return nil }
+func (c *countingSource) TermFrequency(term, field, document string) (float64, error) {
+	return 0, nil
+}
+func (c *countingSource) TermVector(document string) (stats.TermVector, error) { return nil, nil }
+func (c *countingSource) DocumentFrequency(term, field string) (float64, error) {
+	c.requests++
+	return c.df[term], nil
+}
+func (c *countingSource) TotalTermFrequency(term, field string) (float64, error) {
+	c.requests++
+	return c.df[term] * 2, nil
+}
+func (c *countingSource) InverseDocumentFrequency(term, field string) (float64, error) {
+	c.requests++
+	return 1 / c.df[term], nil
+}
+func (c *countingSource) RetrievalSize(query cqr.CommonQueryRepresentation) (float64, error) {
+	c.requests++
+	return 1, nil
+}
+func (c *countingSource) VocabularySize(field string) (float64, error) { return 0, nil }
+func (c *countingSource) Execute(query pipeline.Query, options stats.SearchOptions) (trecresults.ResultList, error) {
+	return nil, nil
+}
+func (c *countingSource) CollectionSize() (float64, error) { return 100, nil }
+
+// batchSource additionally implements the batch interface, and records the size of each batch.
+type batchSource struct {
+	countingSource
+	batches []int
+}
+
+func (b *batchSource) DocumentFrequencies(terms []stats.TermField) ([]float64, error) {
+	b.batches = append(b.batches, len(terms))
+	v := make([]float64, len(terms))
+	for i, t := range terms {
+		v[i] = b.df[t.Term]
+	}
+	return v, nil
+}
+
+func (b *batchSource) TotalTermFrequencies(terms []stats.TermField) ([]float64, error) {
+	b.batches = append(b.batches, len(terms))
+	return make([]float64, len(terms)), nil
+}
+
+func (b *batchSource) InverseDocumentFrequencies(terms []stats.TermField) ([]float64, error) {
+	b.batches = append(b.batches, len(terms))
+	v := make([]float64, len(terms))
+	for i, t := range terms {
+		if b.df[t.Term] > 0 {
+			v[i] = 1 / b.df[t.Term]
+		}
+	}
+	return v, nil
+}
+
+func (b *batchSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	b.batches = append(b.batches, len(queries))
+	return make([]float64, len(queries)), nil
+}
+
+// wrapped wraps a statistics source, hiding its optional interfaces unless it is unwrapped.
+type wrapped struct {
+	stats.StatisticsSource
+}
+
+func (w wrapped) Unwrap() stats.StatisticsSource {
+	return w.StatisticsSource
+}
+
+func TestDocumentFrequencies(t *testing.T) {
+	terms := []stats.TermField{
+		{Term: "a", Field: "title"},
+		{Term: "b", Field: "title"},
+		{Term: "a", Field: "title"},
+		{Term: "c", Field: "title"},
+	}
+	df := map[string]float64{"a": 1, "b": 2, "c": 0}
+
+	// A regular source is asked once for each unique term.
+	c := &countingSource{df: df}
+	v, err := stats.DocumentFrequencies(c, terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.requests != 3 {
+		t.Errorf("expected 3 requests, got %d", c.requests)
+	}
+	expected := []float64{1, 2, 1, 0}
+	for i := range expected {
+		if v[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, v)
+			break
+		}
+	}
+
+	// A batch source is asked once, for all of the unique terms.
+	b := &batchSource{countingSource: countingSource{df: df}}
+	v, err = stats.DocumentFrequencies(b, terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.requests != 0 || len(b.batches) != 1 || b.batches[0] != 3 {
+		t.Errorf("expected a single batch of 3 terms, got %v (and %d single requests)", b.batches, b.requests)
+	}
+	for i := range expected {
+		if v[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, v)
+			break
+		}
+	}
+
+	// A batch source is still asked once when it is wrapped.
+	b.batches = nil
+	if _, err := stats.DocumentFrequencies(wrapped{b}, terms); err != nil {
+		t.Fatal(err)
+	}
+	if b.requests != 0 || len(b.batches) != 1 {
+		t.Errorf("expected a wrapped source to be asked for a single batch, got %v (and %d single requests)", b.batches, b.requests)
+	}
+
+	// Terms that do not appear in the collection have an idf of zero.
+	v, err = stats.InverseDocumentFrequencies(b, terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v[3] != 0 || v[0] <= v[1] {
+		t.Errorf("unexpected idf values %v", v)
+	}
+}
+
+// fakeElasticsearch answers index statistics and (multi) term vector requests. The term vectors of an artificial
+// document contain each of its terms, analysed by lowercasing them and, for the medline analyser, removing
+// truncation, in both the field and its stemmed sub-field.
+func fakeElasticsearch(t *testing.T, df map[string]int64) *httptest.Server {
+	type item struct {
+		Doc              map[string]string `json:"doc"`
+		PerFieldAnalyzer map[string]string `json:"per_field_analyzer"`
+	}
+	vectors := func(it item) map[string]interface{} {
+		tv := make(map[string]interface{})
+		for field, text := range it.Doc {
+			term := strings.ToLower(text)
+			if it.PerFieldAnalyzer[field] == "medline_analyser" {
+				term = strings.Replace(term, "*", "", -1)
+			}
+			terms := map[string]interface{}{term: map[string]int64{"doc_freq": df[term], "ttf": df[term] * 2, "term_freq": 1}}
+			tv[field] = map[string]interface{}{"terms": terms}
+			tv[field+".stemmed"] = map[string]interface{}{"terms": terms}
+		}
+		return map[string]interface{}{"found": true, "term_vectors": tv}
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var resp interface{}
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/_stats"):
+			resp = map[string]interface{}{"_all": map[string]interface{}{"total": map[string]interface{}{"docs": map[string]int64{"count": 1000}}}}
+		case strings.HasSuffix(r.URL.Path, "/_mtermvectors"):
+			var body struct {
+				Docs []item `json:"docs"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Error(err)
+			}
+			docs := make([]interface{}, len(body.Docs))
+			for i, it := range body.Docs {
+				docs[i] = vectors(it)
+			}
+			resp = map[string]interface{}{"docs": docs}
+		case strings.HasSuffix(r.URL.Path, "/_termvectors"):
+			var it item
+			if err := json.NewDecoder(r.Body).Decode(&it); err != nil {
+				t.Error(err)
+			}
+			resp = vectors(it)
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestInverseDocumentFrequenciesElasticsearch(t *testing.T) {
+	server := fakeElasticsearch(t, map[string]int64{"cancer": 10, "cancer*": 20, "heart": 5})
+	defer server.Close()
+
+	es, err := stats.NewElasticsearchStatisticsSource(
+		stats.ElasticsearchHosts(strings.Replace(server.URL, "127.0.0.1", "localhost", 1)),
+		stats.ElasticsearchIndex("med"),
+		stats.ElasticsearchDocumentType("doc"),
+		stats.ElasticsearchAnalysedField(".stemmed"),
+		stats.ElasticsearchBatchSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wildcard terms, terms not in the collection, and terms restricted to analysed and unanalysed fields.
+	terms := []stats.TermField{
+		{Term: "cancer", Field: "title"},
+		{Term: "cancer*", Field: "title"},
+		{Term: "unseen", Field: "title"},
+		{Term: "heart", Field: "title.stemmed"},
+		{Term: "cancer*", Field: "abstract.stemmed"},
+		{Term: "heart", Field: "abstract"},
+	}
+	batch, err := stats.InverseDocumentFrequencies(es, terms)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, term := range terms {
+		single, err := es.InverseDocumentFrequency(term.Term, term.Field)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if batch[i] != single {
+			t.Errorf("expected the batch idf of %v to be %f, got %f", term, single, batch[i])
+		}
+	}
+	if batch[2] != 0 {
+		t.Errorf("expected unseen terms to have an idf of zero, got %f", batch[2])
+	}
+	if batch[0] == 0 || batch[3] == 0 {
+		t.Errorf("expected terms in the collection to have an idf, got %v", batch)
+	}
+}
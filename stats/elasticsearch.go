@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"fmt"
 	"github.com/hscells/cqr"
 	gpipeline "github.com/hscells/groove/pipeline"
 	"github.com/hscells/transmute/backend"
@@ -34,6 +35,9 @@ type ElasticsearchStatisticsSource struct {
 	Analyser     string
 	AnalyseField string
 
+	// BatchSize is the number of terms or queries sent to Elasticsearch in a single multi request.
+	BatchSize int
+
 	wg sync.WaitGroup
 }
 
@@ -42,6 +46,11 @@ func (es *ElasticsearchStatisticsSource) SearchOptions() SearchOptions {
 	return es.options
 }
 
+// Namespace identifies the index, document type and analyser used by the statistics source.
+func (es *ElasticsearchStatisticsSource) Namespace() string {
+	return fmt.Sprintf("elasticsearch/%s/%s/%s/%s", es.index, es.documentType, es.Analyser, es.AnalyseField)
+}
+
 // Parameters gets the immutable parameters for the statistics source.
 func (es *ElasticsearchStatisticsSource) Parameters() map[string]float64 {
 	return es.parameters
@@ -115,17 +124,14 @@ func (es *ElasticsearchStatisticsSource) TotalTermFrequency(term, field string)
 // InverseDocumentFrequency is the ratio of of documents in the collection to the number of documents the term appears
 // in, logarithmically smoothed.
 func (es *ElasticsearchStatisticsSource) InverseDocumentFrequency(term, field string) (float64, error) {
-	resp1, err := es.client.IndexStats(es.index).Do(context.Background())
+	N, err := es.CollectionSize()
 	if err != nil {
 		return 0.0, err
 	}
 
-	N := resp1.All.Total.Docs.Count
-
-	docField := strings.Replace(field, es.AnalyseField, "", -1)
-
+	doc, analyser := es.idfRequest(term, field)
 	req := es.client.TermVectors(es.index, es.documentType).
-		Doc(map[string]string{docField: term}).
+		Doc(doc).
 		FieldStatistics(false).
 		TermStatistics(true).
 		Offsets(false).
@@ -133,24 +139,40 @@ func (es *ElasticsearchStatisticsSource) InverseDocumentFrequency(term, field st
 		Pretty(false).
 		Payloads(false)
 
-	if strings.ContainsRune(term, '*') {
-		req = req.PerFieldAnalyzer(map[string]string{docField: "medline_analyser"})
+	if analyser != nil {
+		req = req.PerFieldAnalyzer(analyser)
 	}
 
-	resp2, err := req.Do(context.Background())
+	resp, err := req.Do(context.Background())
 	if err != nil {
 		return 0.0, err
 	}
 
-	if tv, ok := resp2.TermVectors[field]; ok {
+	return termVectorsIDF(N, resp.TermVectors, term, field), nil
+}
+
+// idfRequest is the document and per field analyser of the term vector request used to compute the inverse document
+// frequency of a term. Terms are looked up in the field without the analysed suffix, and wildcard terms are analysed
+// with the medline analyser.
+func (es *ElasticsearchStatisticsSource) idfRequest(term, field string) (map[string]string, map[string]string) {
+	docField := strings.Replace(field, es.AnalyseField, "", -1)
+	if strings.ContainsRune(term, '*') {
+		return map[string]string{docField: term}, map[string]string{docField: "medline_analyser"}
+	}
+	return map[string]string{docField: term}, nil
+}
+
+// termVectorsIDF computes the inverse document frequency of a term from the term vectors of an idfRequest. Terms
+// which do not appear in the collection have an idf of zero.
+func termVectorsIDF(N float64, vectors map[string]elastic.TermVectorsFieldInfo, term, field string) float64 {
+	if tv, ok := vectors[field]; ok {
 		nt := tv.Terms[term].DocFreq
 		if nt == 0 {
-			return 0.0, nil
+			return 0.0
 		}
-		return idf(float64(N), float64(nt)), nil
+		return idf(N, float64(nt))
 	}
-
-	return 0.0, nil
+	return 0.0
 }
 
 // VocabularySize is the total number of terms in the vocabulary.
@@ -233,14 +255,28 @@ func (es *ElasticsearchStatisticsSource) ExecuteFast(query gpipeline.Query, opti
 	if err != nil {
 		return nil, err
 	}
+	log.Println("executing as fast as possible with Elasticsearch", query.Query)
+	return es.executeFast(q, options)
+}
+
+// ProximityDocumentIDs retrieves the document ids for an adjacency query using an Elasticsearch span query.
+func (es *ElasticsearchStatisticsSource) ProximityDocumentIDs(query cqr.BooleanQuery) ([]uint32, error) {
+	q, err := toElasticsearchSpan(query)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("executing proximity query with Elasticsearch", query)
+	return es.executeFast(q, es.options)
+}
 
+// executeFast concurrently scrolls the document ids of a raw Elasticsearch query.
+func (es *ElasticsearchStatisticsSource) executeFast(q string, options SearchOptions) ([]uint32, error) {
 	// Set the Limit to how many goroutines can be run.
 	// http://jmoiron.net/blog/limiting-concurrency-in-go/
 	concurrency := runtime.NumCPU()
 	sem := make(chan bool, concurrency)
 	hits := make([][]uint32, concurrency)
 
-	log.Println("executing as fast as possible with Elasticsearch", query.Query)
 	for i := 0; i < concurrency; i++ {
 		sem <- true
 
@@ -291,7 +327,7 @@ func (es *ElasticsearchStatisticsSource) ExecuteFast(query gpipeline.Query, opti
 				log.Printf("%v: %v/%v\n", n, len(hits[n]), result.Hits.TotalHits)
 			}
 
-			err = svc.Clear(context.Background())
+			err := svc.Clear(context.Background())
 			if err != nil {
 				log.Println(err)
 				//panic(err)
@@ -407,8 +443,164 @@ func (es *ElasticsearchStatisticsSource) Execute(query gpipeline.Query, options
 	return results, nil
 }
 
+// CollectionSize is the number of documents in the index.
 func (es *ElasticsearchStatisticsSource) CollectionSize() (float64, error) {
-	panic("implement me")
+	resp, err := es.client.IndexStats(es.index).Do(context.Background())
+	if err != nil {
+		return 0.0, err
+	}
+	return float64(resp.All.Total.Docs.Count), nil
+}
+
+// batches splits n items into consecutive [start, end) ranges of at most size items.
+func batches(n, size int) [][2]int {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	var b [][2]int
+	for i := 0; i < n; i += size {
+		j := i + size
+		if j > n {
+			j = n
+		}
+		b = append(b, [2]int{i, j})
+	}
+	return b
+}
+
+// DocumentFrequencies computes the document frequency of many terms using multi term vector requests.
+func (es *ElasticsearchStatisticsSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	values := make([]float64, len(terms))
+	for _, b := range batches(len(terms), es.BatchSize) {
+		svc := es.client.MultiTermVectors().Index(es.index).Type(es.documentType)
+		for _, t := range terms[b[0]:b[1]] {
+			svc = svc.Add(elastic.NewMultiTermvectorItem().
+				Index(es.index).
+				Type(es.documentType).
+				Doc(map[string]string{t.Field: t.Term}).
+				FieldStatistics(false).
+				TermStatistics(true).
+				Offsets(false).
+				Positions(false).
+				Payloads(false).
+				Fields(t.Field).
+				PerFieldAnalyzer(map[string]string{t.Field: ""}))
+		}
+		resp, err := svc.Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for i, doc := range resp.Docs {
+			t := terms[b[0]+i]
+			if tv, ok := doc.TermVectors[t.Field]; ok {
+				values[b[0]+i] = float64(tv.Terms[t.Term].DocFreq)
+			}
+		}
+	}
+	return values, nil
+}
+
+// TotalTermFrequencies computes the total term frequency of many terms using multi term vector requests.
+func (es *ElasticsearchStatisticsSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	values := make([]float64, len(terms))
+	for _, b := range batches(len(terms), es.BatchSize) {
+		svc := es.client.MultiTermVectors().Index(es.index).Type(es.documentType)
+		for _, t := range terms[b[0]:b[1]] {
+			item := elastic.NewMultiTermvectorItem().
+				Index(es.index).
+				Type(es.documentType).
+				Doc(map[string]string{t.Field: t.Term}).
+				TermStatistics(true).
+				Offsets(false).
+				Positions(false).
+				Payloads(false)
+			if strings.ContainsRune(t.Term, '*') {
+				docField := strings.Replace(t.Field, es.AnalyseField, "", -1)
+				item = item.PerFieldAnalyzer(map[string]string{docField: "medline_analyser"})
+			}
+			svc = svc.Add(item)
+		}
+		resp, err := svc.Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for i, doc := range resp.Docs {
+			t := terms[b[0]+i]
+			if tv, ok := doc.TermVectors[t.Field]; ok {
+				term := strings.ToLower(strings.Replace(strings.Replace(strings.Replace(t.Term, "\"", "", -1), "*", "", -1), "~", "", -1))
+				values[b[0]+i] = float64(tv.Terms[term].Ttf)
+			}
+		}
+	}
+	return values, nil
+}
+
+// InverseDocumentFrequencies computes the inverse document frequency of many terms using multi term vector requests.
+func (es *ElasticsearchStatisticsSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	N, err := es.CollectionSize()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]float64, len(terms))
+	for _, b := range batches(len(terms), es.BatchSize) {
+		svc := es.client.MultiTermVectors().Index(es.index).Type(es.documentType)
+		for _, t := range terms[b[0]:b[1]] {
+			doc, analyser := es.idfRequest(t.Term, t.Field)
+			item := elastic.NewMultiTermvectorItem().
+				Index(es.index).
+				Type(es.documentType).
+				Doc(doc).
+				FieldStatistics(false).
+				TermStatistics(true).
+				Offsets(false).
+				Positions(false).
+				Payloads(false)
+			if analyser != nil {
+				item = item.PerFieldAnalyzer(analyser)
+			}
+			svc = svc.Add(item)
+		}
+		resp, err := svc.Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for i, doc := range resp.Docs {
+			t := terms[b[0]+i]
+			values[b[0]+i] = termVectorsIDF(N, doc.TermVectors, t.Term, t.Field)
+		}
+	}
+	return values, nil
+}
+
+// RetrievalSizes computes the number of documents retrieved by many queries using multi search requests.
+func (es *ElasticsearchStatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	values := make([]float64, len(queries))
+	for _, b := range batches(len(queries), es.BatchSize) {
+		svc := es.client.MultiSearch()
+		for _, query := range queries[b[0]:b[1]] {
+			q, err := toElasticsearch(query)
+			if err != nil {
+				return nil, err
+			}
+			svc = svc.Add(elastic.NewSearchRequest().
+				Index(es.index).
+				Type(es.documentType).
+				SearchSource(elastic.NewSearchSource().
+					Query(elastic.NewRawStringQuery(q)).
+					Size(0)))
+		}
+		resp, err := svc.Do(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		for i, r := range resp.Responses {
+			if r.Error != nil {
+				return nil, fmt.Errorf("%s: %s", r.Error.Type, r.Error.Reason)
+			}
+			values[b[0]+i] = float64(r.Hits.TotalHits)
+		}
+	}
+	return values, nil
 }
 
 // Analyse is a specific Elasticsearch method used in the analyse transformation.
@@ -545,6 +737,14 @@ func ElasticsearchAnalysedField(field string) func(*ElasticsearchStatisticsSourc
 	}
 }
 
+// ElasticsearchBatchSize sets how many terms or queries are sent to Elasticsearch in a single multi request.
+func ElasticsearchBatchSize(size int) func(*ElasticsearchStatisticsSource) {
+	return func(es *ElasticsearchStatisticsSource) {
+		es.BatchSize = size
+		return
+	}
+}
+
 // ElasticsearchScroll sets the scroll for the statistic source.
 func ElasticsearchScroll(scroll bool) func(*ElasticsearchStatisticsSource) {
 	return func(es *ElasticsearchStatisticsSource) {
@@ -555,7 +755,9 @@ func ElasticsearchScroll(scroll bool) func(*ElasticsearchStatisticsSource) {
 
 // NewElasticsearchStatisticsSource creates a new ElasticsearchStatisticsSource using functional options.
 func NewElasticsearchStatisticsSource(options ...func(*ElasticsearchStatisticsSource)) (*ElasticsearchStatisticsSource, error) {
-	es := &ElasticsearchStatisticsSource{}
+	es := &ElasticsearchStatisticsSource{
+		BatchSize: defaultBatchSize,
+	}
 
 	if len(options) == 0 {
 		var err error
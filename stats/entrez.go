@@ -289,6 +289,11 @@ func (e EntrezStatisticsSource) SearchOptions() SearchOptions {
 	return e.options
 }
 
+// Namespace identifies the database searched by the statistics source.
+func (e EntrezStatisticsSource) Namespace() string {
+	return fmt.Sprintf("entrez/%s", e.db)
+}
+
 func (e EntrezStatisticsSource) Parameters() map[string]float64 {
 	return e.parameters
 }
@@ -523,6 +528,59 @@ func (e EntrezStatisticsSource) CollectionSize() (float64, error) {
 	return float64(info.DbInfo.Count), nil
 }
 
+// batch concurrently computes a value for each of n items, issuing at most defaultBatchConcurrency requests at a
+// time. Requests are still subject to the entrez rate limiter.
+func (e EntrezStatisticsSource) batch(n int, f func(i int) (float64, error)) ([]float64, error) {
+	values := make([]float64, n)
+	errs := make([]error, n)
+	sem := make(chan bool, defaultBatchConcurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		sem <- true
+		wg.Add(1)
+		go func(j int) {
+			defer func() { <-sem }()
+			defer wg.Done()
+			values[j], errs[j] = f(j)
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// DocumentFrequencies computes the document frequency of many terms using concurrent esearch requests.
+func (e EntrezStatisticsSource) DocumentFrequencies(terms []TermField) ([]float64, error) {
+	return e.batch(len(terms), func(i int) (float64, error) {
+		return e.DocumentFrequency(terms[i].Term, terms[i].Field)
+	})
+}
+
+// TotalTermFrequencies computes the total term frequency of many terms concurrently.
+func (e EntrezStatisticsSource) TotalTermFrequencies(terms []TermField) ([]float64, error) {
+	return e.batch(len(terms), func(i int) (float64, error) {
+		return e.TotalTermFrequency(terms[i].Term, terms[i].Field)
+	})
+}
+
+// InverseDocumentFrequencies computes the inverse document frequency of many terms using concurrent esearch requests.
+func (e EntrezStatisticsSource) InverseDocumentFrequencies(terms []TermField) ([]float64, error) {
+	return e.batch(len(terms), func(i int) (float64, error) {
+		return e.InverseDocumentFrequency(terms[i].Term, terms[i].Field)
+	})
+}
+
+// RetrievalSizes computes the number of documents retrieved by many queries using concurrent esearch requests.
+func (e EntrezStatisticsSource) RetrievalSizes(queries []cqr.CommonQueryRepresentation) ([]float64, error) {
+	return e.batch(len(queries), func(i int) (float64, error) {
+		return e.RetrievalSize(queries[i])
+	})
+}
+
 // EntrezTool sets the tool name for entrez.
 func EntrezTool(tool string) func(source *EntrezStatisticsSource) {
 	return func(source *EntrezStatisticsSource) {
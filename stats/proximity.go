@@ -0,0 +1,241 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hscells/cqr"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// ErrProximityUnsupported indicates that a statistics source is unable to natively evaluate an adjacency query.
+var ErrProximityUnsupported = errors.New("statistics source does not support proximity queries")
+
+// ProximityStatisticsSource is a statistics source that can natively retrieve the documents for an adjacency (or
+// proximity) query, rather than approximating it with a conjunction of its keywords.
+type ProximityStatisticsSource interface {
+	StatisticsSource
+	ProximityDocumentIDs(query cqr.BooleanQuery) ([]uint32, error)
+}
+
+// ProximitySource finds a statistics source that implements ProximityStatisticsSource, which may be wrapped.
+func ProximitySource(ss StatisticsSource) (ProximityStatisticsSource, bool) {
+	p, ok := find(ss, func(ss StatisticsSource) bool {
+		_, ok := ss.(ProximityStatisticsSource)
+		return ok
+	}).(ProximityStatisticsSource)
+	return p, ok
+}
+
+// IsProximityOperator returns if an operator is an adjacency operator such as `adj`, `adj3`, `near` or `NEAR/3`.
+func IsProximityOperator(operator string) bool {
+	_, ok := ProximityDistance(operator)
+	return ok
+}
+
+// ProximityDistance parses the distance of an adjacency operator. Operators without a distance (i.e. `adj`) have a
+// distance of one. The second return value is false when the operator is not an adjacency operator.
+func ProximityDistance(operator string) (int, bool) {
+	op := strings.ToLower(strings.TrimSpace(operator))
+	var rest string
+	switch {
+	case strings.HasPrefix(op, "adj"):
+		rest = op[3:]
+	case strings.HasPrefix(op, "near"):
+		rest = op[4:]
+	default:
+		return 0, false
+	}
+	rest = strings.TrimPrefix(rest, "/")
+	if len(rest) == 0 {
+		return 1, true
+	}
+	n, err := strconv.Atoi(rest)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return n, true
+}
+
+// proximityFields are the fields that every keyword in an adjacency query is searched in. Span queries can only
+// operate on a single field, so a keyword which is not searched in a field cannot take part in a span on that field.
+func proximityFields(query cqr.CommonQueryRepresentation) []string {
+	var fields []string
+	first := true
+	var walk func(r cqr.CommonQueryRepresentation)
+	walk = func(r cqr.CommonQueryRepresentation) {
+		switch q := r.(type) {
+		case cqr.Keyword:
+			if first {
+				fields = append(fields, q.Fields...)
+				first = false
+				return
+			}
+			var intersection []string
+			for _, f := range fields {
+				for _, g := range q.Fields {
+					if f == g {
+						intersection = append(intersection, f)
+						break
+					}
+				}
+			}
+			fields = intersection
+		case cqr.BooleanQuery:
+			for _, child := range q.Children {
+				walk(child)
+			}
+		}
+	}
+	walk(query)
+	return fields
+}
+
+// spanSlop converts an adjacency distance into span slop. Adjacency is measured between the positions of the terms (so
+// `adj` and `adj1` are directly adjacent), whereas slop counts the positions in between them.
+func spanSlop(distance int) int {
+	if distance < 1 {
+		return 0
+	}
+	return distance - 1
+}
+
+// spanTerm creates the span query for a single word of a keyword.
+func spanTerm(word, field string, truncated bool) map[string]interface{} {
+	word = strings.ToLower(word)
+	if strings.HasSuffix(word, "*") || strings.HasSuffix(word, "$") || truncated {
+		return map[string]interface{}{
+			"span_multi": map[string]interface{}{
+				"match": map[string]interface{}{
+					"prefix": map[string]interface{}{
+						field: map[string]interface{}{"value": strings.TrimRight(word, "*$")},
+					},
+				},
+			},
+		}
+	}
+	if strings.ContainsAny(word, "*?") {
+		return map[string]interface{}{
+			"span_multi": map[string]interface{}{
+				"match": map[string]interface{}{
+					"wildcard": map[string]interface{}{
+						field: map[string]interface{}{"value": word},
+					},
+				},
+			},
+		}
+	}
+	return map[string]interface{}{
+		"span_term": map[string]interface{}{field: word},
+	}
+}
+
+// spanClause recursively creates a span query on a single field for a clause inside an adjacency query. Keywords with
+// many words become an exact (in order, zero slop) span, disjunctions become span_or and nested adjacency operators
+// become span_near. Other operators cannot be represented as spans.
+func spanClause(query cqr.CommonQueryRepresentation, field string) (map[string]interface{}, error) {
+	switch q := query.(type) {
+	case cqr.Keyword:
+		truncated, _ := q.Options[cqr.TruncatedString].(bool)
+		words := strings.Fields(strings.Replace(q.QueryString, `"`, "", -1))
+		if len(words) == 0 {
+			return nil, errors.Errorf("keyword %s contains no words to search for", q)
+		}
+		if len(words) == 1 {
+			return spanTerm(words[0], field, truncated), nil
+		}
+		clauses := make([]interface{}, len(words))
+		for i, word := range words {
+			clauses[i] = spanTerm(word, field, truncated && i == len(words)-1)
+		}
+		return map[string]interface{}{
+			"span_near": map[string]interface{}{
+				"clauses":  clauses,
+				"slop":     0,
+				"in_order": true,
+			},
+		}, nil
+	case cqr.BooleanQuery:
+		clauses := make([]interface{}, len(q.Children))
+		for i, child := range q.Children {
+			var err error
+			clauses[i], err = spanClause(child, field)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if strings.ToLower(q.Operator) == cqr.OR {
+			return map[string]interface{}{
+				"span_or": map[string]interface{}{
+					"clauses": clauses,
+				},
+			}, nil
+		}
+		if distance, ok := ProximityDistance(q.Operator); ok {
+			return map[string]interface{}{
+				"span_near": map[string]interface{}{
+					"clauses":  clauses,
+					"slop":     spanSlop(distance),
+					"in_order": false,
+				},
+			}, nil
+		}
+		return nil, errors.Wrapf(ErrProximityUnsupported, "operator %s cannot be nested inside an adjacency operator", q.Operator)
+	}
+	return nil, errors.Errorf("supplied query is not supported: %s", query)
+}
+
+// toElasticsearchSpan transforms an adjacency query into an Elasticsearch span query. The span is repeated for each
+// field that all of the keywords are searched in, and any of them may match.
+func toElasticsearchSpan(query cqr.BooleanQuery) (string, error) {
+	if !IsProximityOperator(query.Operator) {
+		return "", errors.Errorf("%s is not an adjacency operator", query.Operator)
+	}
+	fields := proximityFields(query)
+	if len(fields) == 0 {
+		return "", errors.Errorf("the keywords in %s do not share a field to search in", query)
+	}
+	should := make([]interface{}, len(fields))
+	for i, field := range fields {
+		span, err := spanClause(query, field)
+		if err != nil {
+			return "", err
+		}
+		should[i] = span
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// toTerrierProximity transforms an adjacency query into a terrier proximity query, i.e. `"a b"~n`, optionally
+// restricted to a field.
+// Terrier can only express proximity between single terms, so nested clauses are not supported.
+func toTerrierProximity(query cqr.BooleanQuery, field string) (string, error) {
+	distance, ok := ProximityDistance(query.Operator)
+	if !ok {
+		return "", errors.Errorf("%s is not an adjacency operator", query.Operator)
+	}
+	var words []string
+	for _, child := range query.Children {
+		kw, ok := child.(cqr.Keyword)
+		if !ok {
+			return "", errors.Wrapf(ErrProximityUnsupported, "terrier cannot nest %s inside an adjacency operator", child)
+		}
+		words = append(words, strings.Fields(strings.Replace(kw.QueryString, `"`, "", -1))...)
+	}
+	// Terrier counts the window over all of the terms, rather than the distance between them.
+	window := distance + len(words) - 1
+	if len(field) > 0 {
+		return fmt.Sprintf(`%s:"%s"~%d`, field, strings.Join(words, " "), window), nil
+	}
+	return fmt.Sprintf(`"%s"~%d`, strings.Join(words, " "), window), nil
+}
@@ -0,0 +1,32 @@
+package stats_test
+
+import (
+	"github.com/hscells/groove/stats"
+	"testing"
+)
+
+func TestProximityDistance(t *testing.T) {
+	cases := []struct {
+		operator  string
+		distance  int
+		proximity bool
+	}{
+		{"adj", 1, true},
+		{"adj3", 3, true},
+		{"ADJ10", 10, true},
+		{"near", 1, true},
+		{"NEAR/5", 5, true},
+		{"and", 0, false},
+		{"or", 0, false},
+		{"adjx", 0, false},
+	}
+	for _, c := range cases {
+		d, ok := stats.ProximityDistance(c.operator)
+		if ok != c.proximity || d != c.distance {
+			t.Errorf("%s: expected (%d, %t), got (%d, %t)", c.operator, c.distance, c.proximity, d, ok)
+		}
+		if stats.IsProximityOperator(c.operator) != c.proximity {
+			t.Errorf("%s: expected proximity to be %t", c.operator, c.proximity)
+		}
+	}
+}
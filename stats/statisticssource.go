@@ -3,6 +3,7 @@ package stats
 
 import (
 	"errors"
+	"fmt"
 	"github.com/hscells/cqr"
 	"github.com/hscells/groove/pipeline"
 	"github.com/hscells/trecresults"
@@ -48,6 +49,60 @@ type StatisticsSource interface {
 	CollectionSize() (float64, error)
 }
 
+// NamespacedStatisticsSource is a statistics source that can describe the configuration (i.e. the index, field mapping
+// or database) that determines the documents it retrieves, so that cached results are never shared between sources
+// that would retrieve different documents.
+type NamespacedStatisticsSource interface {
+	StatisticsSource
+	Namespace() string
+}
+
+// WrappedStatisticsSource is a statistics source that wraps another (e.g. to override its parameters). The optional
+// interfaces of the wrapped source, such as BatchStatisticsSource and ProximityStatisticsSource, are used through the
+// wrapper.
+type WrappedStatisticsSource interface {
+	StatisticsSource
+	Unwrap() StatisticsSource
+}
+
+// Underlying is the statistics source at the bottom of a chain of wrapped statistics sources.
+func Underlying(ss StatisticsSource) StatisticsSource {
+	for {
+		w, ok := ss.(WrappedStatisticsSource)
+		if !ok {
+			return ss
+		}
+		ss = w.Unwrap()
+	}
+}
+
+// find is the first statistics source in a chain of wrapped statistics sources that matches, or nil if none do.
+func find(ss StatisticsSource, match func(ss StatisticsSource) bool) StatisticsSource {
+	for ss != nil {
+		if match(ss) {
+			return ss
+		}
+		w, ok := ss.(WrappedStatisticsSource)
+		if !ok {
+			return nil
+		}
+		ss = w.Unwrap()
+	}
+	return nil
+}
+
+// Namespace identifies the configuration of a statistics source. Sources that do not implement
+// NamespacedStatisticsSource are identified by their type.
+func Namespace(ss StatisticsSource) string {
+	if ss == nil {
+		return ""
+	}
+	if n, ok := ss.(NamespacedStatisticsSource); ok {
+		return n.Namespace()
+	}
+	return fmt.Sprintf("%T", ss)
+}
+
 // ToPipelineQuery creates a pipeline query from a term vector. This can be used to perform analysis on documents (since
 // the term vector is a representation of a document).
 func (tv TermVector) ToPipelineQuery(topic, name string) pipeline.Query {
@@ -68,7 +123,7 @@ func GetDocumentIDs(query pipeline.Query, ss StatisticsSource) ([]uint32, error)
 	var docs []uint32
 
 	// Elasticsearch has a "fast" execute to scroll quickly so we can account for that here.
-	switch x := ss.(type) {
+	switch x := Underlying(ss).(type) {
 	case *ElasticsearchStatisticsSource:
 		ids, err := x.ExecuteFast(query, x.SearchOptions())
 		if err != nil {
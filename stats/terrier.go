@@ -39,6 +39,11 @@ func (t TerrierStatisticsSource) SearchOptions() SearchOptions {
 	return t.options
 }
 
+// Namespace identifies the index and field used by this source.
+func (t TerrierStatisticsSource) Namespace() string {
+	return fmt.Sprintf("terrier/%s/%s/%s", t.indexPath, t.indexPrefix, t.field)
+}
+
 // Parameters gets the parameters for this source.
 func (t TerrierStatisticsSource) Parameters() map[string]float64 {
 	return t.parameters
@@ -209,6 +214,33 @@ func (t TerrierStatisticsSource) Execute(query gpipeline.Query, options SearchOp
 	return trecResultSet, nil
 }
 
+// ProximityDocumentIDs retrieves the document ids for an adjacency query using the native proximity operator of
+// terrier.
+func (t TerrierStatisticsSource) ProximityDocumentIDs(query cqr.BooleanQuery) ([]uint32, error) {
+	q, err := toTerrierProximity(query, t.field)
+	if err != nil {
+		return nil, err
+	}
+	resultSet, err := executeRaw(t.env, q, t.options, t)
+	if err != nil {
+		return nil, err
+	}
+	resultSize, err := resultSet.CallMethod(t.env, "getResultSize", jnigi.Int)
+	if err != nil {
+		return nil, err
+	}
+	docIdsRef, err := resultSet.CallMethod(t.env, "getDocids", jnigi.Int|jnigi.Array)
+	if err != nil {
+		return nil, err
+	}
+	docIDs := docIdsRef.([]interface{})
+	ids := make([]uint32, resultSize.(int))
+	for i := range ids {
+		ids[i] = uint32(docIDs[i].(int64))
+	}
+	return ids, nil
+}
+
 func (t TerrierStatisticsSource) CollectionSize() (float64, error) {
 	panic("implement me")
 }
@@ -225,11 +257,16 @@ func execute(env *jnigi.Env, query cqr.CommonQueryRepresentation, options Search
 		return nil, err
 	}
 
-	// Wrap arguments in Java strings.
 	s, err := terrierQuery.String()
 	if err != nil {
 		return nil, err
 	}
+	return executeRaw(env, s, options, t)
+}
+
+// executeRaw executes a query that is already in the terrier query language.
+func executeRaw(env *jnigi.Env, s string, options SearchOptions, t TerrierStatisticsSource) (*jnigi.ObjectRef, error) {
+	// Wrap arguments in Java strings.
 	jQuery, err := env.NewObject("java/lang/String", []byte(s))
 	if err != nil {
 		log.Fatal(err)